@@ -0,0 +1,112 @@
+// Command projection resets a projection and replays it from the beginning
+// of the user event topic, then prints the rebuilt state.
+//
+// Only persistent projections can be replayed, and only while the server is
+// down: the server applies events to the same table and checkpoints, and
+// would race the reset. In-memory projections are rebuilt by every server
+// start anyway.
+//
+//	go run ./cmd/projection -name status_breakdown
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	sqlc "UserManagement/internal/db/sqlc"
+	"UserManagement/internal/instance"
+	"UserManagement/internal/kafka"
+	"UserManagement/internal/projection"
+	"UserManagement/internal/repository"
+	"UserManagement/internal/schema"
+	"UserManagement/internal/util"
+)
+
+func main() {
+	name := flag.String("name", "", "projection to replay")
+	flag.Parse()
+
+	config, err := util.LoadConfig(".")
+	if err != nil {
+		log.Fatal("cannot load config:", err)
+	}
+	conn, err := sql.Open(config.DBDriver, config.DBSource)
+	if err != nil {
+		log.Fatal("cannot connect to the database:", err)
+	}
+	defer func(conn *sql.DB) {
+		_ = conn.Close()
+	}(conn)
+
+	var setup *projection.Setup
+	for _, s := range projection.Builtin(sqlc.New(conn)) {
+		if s.Projection.Name() == *name {
+			setup = &s
+		}
+	}
+	if setup == nil {
+		log.Fatalf("unknown projection %q", *name)
+	}
+	instances := repository.NewPostgresInstanceRepository(sqlc.New(conn))
+	err = checkReplayable(context.Background(), *setup, func(ctx context.Context) (bool, error) {
+		return instance.ServerRunning(ctx, instances)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	registry, err := schema.NewRegistry(config.SchemaRegistryURL, config.SchemaRegistryPath)
+	if err != nil {
+		log.Fatal("cannot open schema registry:", err)
+	}
+	runner := &kafka.ProjectionRunner{
+		Broker:       config.KafkaBroker,
+		Topic:        config.KafkaTopic,
+		Deserializer: schema.NewDeserializer(registry),
+		Checkpoints:  setup.Checkpoints,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log.Printf("Replaying %s from the beginning of %s", *name, config.KafkaTopic)
+	if err := runner.Replay(ctx, setup.Projection); err != nil {
+		log.Fatal("replay failed:", err)
+	}
+
+	state, err := setup.Projection.State(ctx)
+	if err != nil {
+		log.Fatal("cannot read projection state:", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(state)
+}
+
+// checkReplayable fails for in-memory projections, and unless running says
+// the server is down. If that can't be told, it fails too.
+func checkReplayable(ctx context.Context, setup projection.Setup, running func(ctx context.Context) (bool, error)) error {
+	if !setup.Persistent {
+		return fmt.Errorf("%s is kept in memory and rebuilt whenever the server starts; there is nothing to replay", setup.Projection.Name())
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	up, err := running(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot tell whether the server is running: %w", err)
+	}
+	if up {
+		return errors.New("the server is running; stop every instance before replaying a projection")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/projection"
+)
+
+func TestCheckReplayable(t *testing.T) {
+	persistent := projection.Setup{Projection: projection.NewUserCount(), Persistent: true}
+	inMemory := projection.Setup{Projection: projection.NewUserCount(), Checkpoints: projection.NewMemoryCheckpointStore()}
+	for _, tc := range []struct {
+		name    string
+		setup   projection.Setup
+		up      bool
+		err     error
+		wantErr bool
+	}{
+		{name: "server down", setup: persistent},
+		{name: "server up", setup: persistent, up: true, wantErr: true},
+		{name: "database unreachable", setup: persistent, err: errors.New("connection refused"), wantErr: true},
+		{name: "in-memory projection", setup: inMemory, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			asked := false
+			err := checkReplayable(context.Background(), tc.setup, func(context.Context) (bool, error) {
+				asked = true
+				return tc.up, tc.err
+			})
+			if tc.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.setup.Persistent, asked)
+		})
+	}
+}
@@ -3,19 +3,32 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"expvar"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	_ "github.com/lib/pq"
 
+	"UserManagement/internal/command"
 	sqlc "UserManagement/internal/db/sqlc"
 	"UserManagement/internal/handler"
+	"UserManagement/internal/idempotency"
+	"UserManagement/internal/importer"
+	"UserManagement/internal/instance"
 	"UserManagement/internal/kafka"
+	"UserManagement/internal/projection"
 	"UserManagement/internal/repository"
 	"UserManagement/internal/router"
+	"UserManagement/internal/schema"
 	"UserManagement/internal/service"
 	"UserManagement/internal/util"
 	"UserManagement/internal/validator"
+	"UserManagement/internal/webhook"
 	"UserManagement/internal/ws"
 )
 
@@ -33,36 +46,256 @@ func main() {
 		_ = conn.Close()
 	}(conn)
 
-	ctx := context.Background()
+	// ctx is cancelled on SIGINT/SIGTERM, which starts the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	v := validator.NewValidator()
-	producer := kafka.NewProducer(config.KafkaBroker, config.KafkaTopic)
+
+	// Event encoding and schema registry
+	registry, err := schema.NewRegistry(config.SchemaRegistryURL, config.SchemaRegistryPath)
+	if err != nil {
+		log.Fatal("cannot open schema registry:", err)
+	}
+	serializer, err := schema.NewSerializer(config.KafkaEncoding, registry, schema.SubjectFor(config.KafkaTopic))
+	if err != nil {
+		log.Fatal("cannot set up event encoding:", err)
+	}
+	if config.KafkaSnapshotTopic != "" {
+		if err := kafka.EnsureCompactedTopic(config.KafkaBroker, config.KafkaSnapshotTopic, config.KafkaSnapshotPartitions); err != nil {
+			log.Fatal("cannot create snapshot topic:", err)
+		}
+	}
+	producer, err := kafka.NewProducer(kafka.ProducerConfig{
+		Broker:        config.KafkaBroker,
+		Topic:         config.KafkaTopic,
+		SnapshotTopic: config.KafkaSnapshotTopic,
+		BatchSize:     config.KafkaBatchSize,
+		BatchTimeout:  config.KafkaBatchTimeout,
+		Compression:   config.KafkaCompression,
+		RequiredAcks:  config.KafkaRequiredAcks,
+		BufferSize:    config.KafkaBufferSize,
+		OnDelivery:    reportDelivery,
+	}, serializer)
+	if err != nil {
+		log.Fatal("cannot create kafka producer:", err)
+	}
 
 	// Initialize the repository
-	repo := repository.NewPostgresUserRepository(sqlc.New(conn))
+	queries := sqlc.New(conn)
+	// The lease tells offline tools that this server is up
+	lease, err := instance.Acquire(ctx, repository.NewPostgresInstanceRepository(queries))
+	if err != nil {
+		log.Fatal("cannot take a server lease:", err)
+	}
+	go lease.Run(ctx)
+	repo := repository.NewPostgresUserRepository(conn)
+	deserializer := schema.NewDeserializer(registry)
 
-	us := service.NewUserService(ctx, repo, v, producer)
-	uh := handler.NewUserHandler(us)
-	r := router.NewRouter(uh)
+	// Build read models from the event stream
+	var projections []projection.Projection
+	for _, setup := range projection.Builtin(queries) {
+		runner := &kafka.ProjectionRunner{
+			Broker:       config.KafkaBroker,
+			Topic:        config.KafkaTopic,
+			Deserializer: deserializer,
+			Checkpoints:  setup.Checkpoints,
+		}
+		go func(p projection.Projection) {
+			if err := runner.Run(ctx, p); err != nil {
+				log.Printf("projection %s stopped: %v", p.Name(), err)
+			}
+		}(setup.Projection)
+		projections = append(projections, setup.Projection)
+	}
 
-	// WebSocket setup
-	m := ws.NewManager(us)
+	// The service outlives ctx so that it can drain its queue on shutdown
+	us := service.NewUserService(context.Background(), repo, v, producer, config.WorkerPoolSize, config.ReadConcurrency)
 
-	// Start Kafka consumer
-	go kafka.StartConsumer(config.KafkaBroker, config.KafkaTopic, m)
+	// Every transport dispatches user commands through the same bus
+	bus := command.NewBus(command.Logging(), command.Metrics(), command.Validation())
+	us.Register(bus)
+	uh := handler.NewUserHandler(bus, config.BatchMaxSize)
+	ph := handler.NewProjectionHandler(projection.NewRegistry(projections...))
 
-	// Run REST API server on port 8080
+	// Outbound webhooks: deliveries are queued from the event stream and
+	// POSTed by the dispatcher
+	webhooks := repository.NewPostgresWebhookRepository(queries)
+	dispatcher := webhook.NewDispatcher(webhooks, webhook.Config{
+		Timeout:        config.WebhookTimeout,
+		MaxAttempts:    config.WebhookMaxAttempts,
+		InitialBackoff: config.WebhookInitialBackoff,
+		MaxBackoff:     config.WebhookMaxBackoff,
+		MaxFailures:    config.WebhookMaxFailures,
+		PollInterval:   config.WebhookPollInterval,
+		BatchSize:      config.WebhookBatchSize,
+		// Off by default: webhooks are registered without authentication
+		AllowPrivateTargets: config.WebhookAllowPrivateTargets,
+	})
+	dispatcherDone := make(chan struct{})
 	go func() {
-		log.Println("Starting REST server at :8080")
-		err = http.ListenAndServe(":8080", r)
-		if err != nil {
-			log.Fatal("error starting REST server:", err)
-		}
+		defer close(dispatcherDone)
+		dispatcher.Run(ctx)
 	}()
+	webhookConsumerDone := kafka.StartWebhookConsumer(ctx, config.KafkaBroker, config.KafkaTopic, dispatcher, deserializer)
+
+	// Responses to requests sent with an Idempotency-Key, scoped to the caller
+	idempotencyKeys := idempotency.NewKeys(repository.NewPostgresIdempotencyRepository(queries), config.IdempotencyTTL)
+	go idempotencyKeys.Run(ctx, time.Hour)
+
+	// WebSocket setup
+	m, err := ws.NewManager(bus, ws.Config{
+		SendQueueSize:      config.WsSendQueueSize,
+		OverflowPolicy:     config.WsOverflowPolicy,
+		ReadLimit:          config.WsReadLimit,
+		ReadBufferSize:     config.WsReadBufferSize,
+		WriteBufferSize:    config.WsWriteBufferSize,
+		PongWait:           config.WsPongWait,
+		PingInterval:       config.WsPingInterval,
+		WriteWait:          config.WsWriteWait,
+		RateLimit:          config.WsRateLimit,
+		RateBurst:          config.WsRateBurst,
+		PrincipalRateLimit: config.WsPrincipalRateLimit,
+		PrincipalRateBurst: config.WsPrincipalRateBurst,
+		MaxRateViolations:  config.WsMaxRateViolations,
+		SSEHeartbeat:       config.SSEHeartbeat,
+		AllowedOrigins:     config.AllowedOrigins,
+	})
+	if err != nil {
+		log.Fatal("cannot set up websocket manager:", err)
+	}
+	m.Idempotency = idempotencyKeys
+
+	// User imports run in the background and report progress over WebSocket
+	imports := importer.NewJobs(ctx, bus, v, m, importer.Config{
+		Dir:       config.ImportDir,
+		MaxSize:   config.ImportMaxSize,
+		Retention: config.ImportRetention,
+	})
+	importsDone := make(chan struct{})
+	go func() {
+		defer close(importsDone)
+		imports.Run(ctx)
+	}()
+
+	// Callers are known by the user a trusted proxy names, else their IP
+	identity, err := ws.NewIdentity(config.TrustedProxies)
+	if err != nil {
+		log.Fatal("cannot set up identity:", err)
+	}
+
+	// With no separate WS_PORT, /ws_users is served by the REST router
+	restAddr := orDefault(config.RestPort, ":8080")
+	singlePort := config.WsPort == "" || config.WsPort == restAddr
+	handlers := router.Handlers{
+		Users:       uh,
+		Projections: ph,
+		Admin:       handler.NewAdminHandler(m),
+		Events:      m,
+		Webhooks:    handler.NewWebhookHandler(webhooks, dispatcher),
+		Imports:     handler.NewImportHandler(imports),
+		AdminToken:  config.AdminToken,
+		Identity:    identity.Middleware,
+		Idempotency: idempotency.Middleware(idempotencyKeys, ws.UserOf),
+	}
+	if singlePort {
+		handlers.WebSocket = m
+	}
+	r := router.NewRouter(handlers)
 
-	http.HandleFunc("/ws_users", m.ServeWS)           // Handle WebSocket connection
-	log.Println("Starting WebSocket server at :8082") // Run WebSocket server on port 8082
-	err = http.ListenAndServe(":8082", nil)
+	// Start Kafka consumer; every instance reads every partition so that its
+	// clients see every event
+	consumerDone, err := kafka.StartConsumer(ctx, config.KafkaBroker, config.KafkaTopic, m, deserializer)
 	if err != nil {
-		log.Fatal("error starting WebSocket server:", err)
+		log.Fatal("cannot start kafka consumer:", err)
+	}
+
+	restServer := &http.Server{Addr: restAddr, Handler: r}
+	// Event streams never go idle on their own, and exports can take longer
+	// than the shutdown timeout
+	restServer.RegisterOnShutdown(m.EndStreams)
+	restServer.RegisterOnShutdown(us.CancelExports)
+	go serve(restServer, "REST")
+
+	var wsServer *http.Server
+	if !singlePort {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/ws_users", m.ServeWS) // Handle WebSocket connection
+		wsServer = &http.Server{Addr: config.WsPort, Handler: identity.Middleware(mux)}
+		go serve(wsServer, "WebSocket")
+	}
+
+	<-ctx.Done()
+	timeout := config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	log.Printf("Shutting down (timeout %s)", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Stop accepting connections and let in-flight HTTP requests finish
+	if err := restServer.Shutdown(shutdownCtx); err != nil {
+		log.Println("error shutting down REST server:", err)
+	}
+	if wsServer != nil {
+		if err := wsServer.Shutdown(shutdownCtx); err != nil {
+			log.Println("error shutting down WebSocket server:", err)
+		}
+	}
+	// Upgraded connections aren't tracked by http.Server
+	if err := m.Shutdown(shutdownCtx); err != nil {
+		log.Println("error closing websocket clients:", err)
+	}
+	// Handle what's queued; its events go to the producer, which is flushed next
+	if err := us.Shutdown(shutdownCtx); err != nil {
+		log.Println("error draining user service queue:", err)
+	}
+	if err := producer.Close(shutdownCtx); err != nil {
+		log.Println("error flushing kafka producer:", err)
+	}
+	for name, done := range map[string]<-chan struct{}{
+		"kafka consumer":     consumerDone,
+		"webhook consumer":   webhookConsumerDone,
+		"webhook dispatcher": dispatcherDone,
+		"user imports":       importsDone,
+	} {
+		select {
+		case <-done:
+		case <-shutdownCtx.Done():
+			log.Printf("error closing %s: %v", name, shutdownCtx.Err())
+		}
+	}
+	if err := lease.Release(shutdownCtx); err != nil {
+		log.Println("error releasing server lease:", err)
+	}
+	log.Println("Shutdown complete")
+}
+
+// deliveryMetrics counts event deliveries on /debug/vars, keyed by
+// "<event type>.<outcome>"
+var deliveryMetrics = expvar.NewMap("event_deliveries")
+
+// reportDelivery is the producer's delivery callback: it counts each outcome
+// and logs failures.
+func reportDelivery(report kafka.DeliveryReport) {
+	outcome := "delivered"
+	if report.Err != nil {
+		outcome = "failed"
+	}
+	deliveryMetrics.Add(report.EventType+"."+outcome, 1)
+	kafka.LogDelivery(report)
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func serve(srv *http.Server, name string) {
+	log.Printf("Starting %s server at %s", name, srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("error starting %s server: %v", name, err)
 	}
 }
@@ -0,0 +1,134 @@
+// Command snapshot re-publishes the current state of every user from
+// Postgres to the compacted snapshot topic, for example after the topic was
+// recreated or a new encoding was rolled out.
+//
+// It only runs while the server is down. A user updated while it runs would
+// otherwise have the snapshot the server published overwritten by the older
+// state read here.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	sqlc "UserManagement/internal/db/sqlc"
+	"UserManagement/internal/instance"
+	"UserManagement/internal/kafka"
+	"UserManagement/internal/model"
+	"UserManagement/internal/repository"
+	"UserManagement/internal/schema"
+	"UserManagement/internal/util"
+)
+
+func main() {
+	config, err := util.LoadConfig(".")
+	if err != nil {
+		log.Fatal("cannot load config:", err)
+	}
+	if config.KafkaSnapshotTopic == "" {
+		log.Fatal("KAFKA_SNAPSHOT_TOPIC is not set")
+	}
+	conn, err := sql.Open(config.DBDriver, config.DBSource)
+	if err != nil {
+		log.Fatal("cannot connect to the database:", err)
+	}
+	defer func(conn *sql.DB) {
+		_ = conn.Close()
+	}(conn)
+	instances := repository.NewPostgresInstanceRepository(sqlc.New(conn))
+	err = ensureOffline(context.Background(), func(ctx context.Context) (bool, error) {
+		return instance.ServerRunning(ctx, instances)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := kafka.EnsureCompactedTopic(config.KafkaBroker, config.KafkaSnapshotTopic, config.KafkaSnapshotPartitions); err != nil {
+		log.Fatal("cannot create snapshot topic:", err)
+	}
+
+	registry, err := schema.NewRegistry(config.SchemaRegistryURL, config.SchemaRegistryPath)
+	if err != nil {
+		log.Fatal("cannot open schema registry:", err)
+	}
+	serializer, err := schema.NewSerializer(config.KafkaEncoding, registry, schema.SubjectFor(config.KafkaSnapshotTopic))
+	if err != nil {
+		log.Fatal("cannot set up event encoding:", err)
+	}
+	producer, err := kafka.NewProducer(kafka.ProducerConfig{
+		Broker:        config.KafkaBroker,
+		SnapshotTopic: config.KafkaSnapshotTopic,
+		BatchSize:     config.KafkaBatchSize,
+		BatchTimeout:  config.KafkaBatchTimeout,
+		Compression:   config.KafkaCompression,
+		RequiredAcks:  config.KafkaRequiredAcks,
+		BufferSize:    config.KafkaBufferSize,
+	}, serializer)
+	if err != nil {
+		log.Fatal("cannot create kafka producer:", err)
+	}
+
+	repo := repository.NewPostgresUserRepository(conn)
+	users, err := repo.ListUsersRepo(context.Background())
+	if err != nil {
+		log.Fatal("cannot list users:", err)
+	}
+
+	published, err := publishAll(users, producer.PublishSnapshot)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := producer.Close(ctx); err != nil {
+		log.Fatal("error flushing kafka producer:", err)
+	}
+	stats := producer.Stats()
+	log.Printf("Published %d users to %s (%d failed)", published, config.KafkaSnapshotTopic, stats.Failed)
+}
+
+// ensureOffline fails unless running says the server is down. If that can't
+// be told, it fails too.
+func ensureOffline(ctx context.Context, running func(ctx context.Context) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	up, err := running(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot tell whether the server is running: %w", err)
+	}
+	if up {
+		return errors.New("the server is running; stop every instance before taking a snapshot")
+	}
+	return nil
+}
+
+// bufferFullWait is how long to wait for the producer buffer to drain
+var bufferFullWait = 100 * time.Millisecond
+
+// publishAll publishes every user and returns how many were published.
+func publishAll(users []model.User, publish func(model.User) error) (int, error) {
+	published := 0
+	for _, user := range users {
+		// The buffer fills faster than the broker drains it; wait and retry
+		var err error
+		for {
+			err = publish(user)
+			if !errors.Is(err, kafka.ErrBufferFull) {
+				break
+			}
+			time.Sleep(bufferFullWait)
+		}
+		if err != nil {
+			return published, fmt.Errorf("cannot publish user %d: %w", user.ID, err)
+		}
+		published++
+	}
+	return published, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/kafka"
+	"UserManagement/internal/model"
+)
+
+func TestEnsureOffline(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		up      bool
+		err     error
+		wantErr bool
+	}{
+		{name: "server down"},
+		{name: "server up", up: true, wantErr: true},
+		{name: "database unreachable", err: errors.New("connection refused"), wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ensureOffline(context.Background(), func(context.Context) (bool, error) { return tc.up, tc.err })
+			if tc.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPublishAllWaitsForBuffer(t *testing.T) {
+	defer func(wait time.Duration) { bufferFullWait = wait }(bufferFullWait)
+	bufferFullWait = time.Millisecond
+
+	var published []int64
+	full := 2
+	n, err := publishAll([]model.User{{ID: 1}, {ID: 2}, {ID: 3}}, func(user model.User) error {
+		if user.ID == 2 && full > 0 {
+			full--
+			return kafka.ErrBufferFull
+		}
+		published = append(published, user.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, []int64{1, 2, 3}, published)
+}
+
+func TestPublishAllStopsOnError(t *testing.T) {
+	n, err := publishAll([]model.User{{ID: 1}, {ID: 2}, {ID: 3}}, func(user model.User) error {
+		if user.ID == 2 {
+			return errors.New("unknown topic")
+		}
+		return nil
+	})
+	require.ErrorContains(t, err, "cannot publish user 2")
+	require.Equal(t, 1, n)
+}
@@ -11,6 +11,8 @@ require (
 	github.com/gorilla/websocket v1.5.3
 	github.com/segmentio/kafka-go v0.4.47
 	github.com/spf13/viper v1.20.1
+	golang.org/x/time v0.8.0
+	google.golang.org/protobuf v1.36.12
 )
 
 require (
@@ -0,0 +1,37 @@
+//go:build integration
+
+package suite
+
+import (
+	"testing"
+
+	"UserManagement/integration/test_util"
+)
+
+// Every client on every replica must see every event, whichever replica
+// handled the write.
+func TestEveryNodeReceivesEveryEvent(t *testing.T) {
+	urls := []string{test_util.WebSocketURL, test_util.SecondWebSocketURL}
+	var clients []*test_util.WebSocketTestUtil
+	for _, url := range urls {
+		for i := 0; i < 3; i++ {
+			wsUtil, err := test_util.NewWebSocketTestUtil(url)
+			if err != nil {
+				t.Fatalf("Failed to connect to %s: %v", url, err)
+			}
+			t.Cleanup(wsUtil.Close)
+			clients = append(clients, wsUtil)
+		}
+	}
+
+	// Alternate the replica that takes the write
+	restURLs := []string{test_util.RestURL, test_util.SecondRestURL}
+	var userIDs []int
+	for i := 0; i < 6; i++ {
+		userIDs = append(userIDs, test_util.CreateUserOn(t, restURLs[i%len(restURLs)]))
+	}
+
+	for _, wsUtil := range clients {
+		test_util.WaitForUserEvents(t, wsUtil, "user_created", userIDs)
+	}
+}
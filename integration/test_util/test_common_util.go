@@ -16,6 +16,10 @@ const (
 	RestURL      = "http://localhost:8080"
 	WebSocketURL = "ws://localhost:8082/ws_users"
 	TestTimeout  = 20 * time.Second
+
+	// The second replica, as seen from inside the compose network
+	SecondRestURL      = "http://usermanagement-2:8080"
+	SecondWebSocketURL = "ws://usermanagement-2:8082/ws_users"
 )
 
 func SetupWebSocket(t *testing.T) *WebSocketTestUtil {
@@ -38,9 +42,14 @@ func CreateUserPayload() map[string]interface{} {
 }
 
 func CreateUser(t *testing.T) int {
+	return CreateUserOn(t, RestURL)
+}
+
+// CreateUserOn creates a user through the instance at restURL
+func CreateUserOn(t *testing.T, restURL string) int {
 	user := CreateUserPayload()
 	payload, _ := json.Marshal(user)
-	resp, err := http.Post(RestURL+"/users", "application/json", bytes.NewBuffer(payload))
+	resp, err := http.Post(restURL+"/users", "application/json", bytes.NewBuffer(payload))
 	if err != nil {
 		t.Fatalf("Failed to send REST request: %v", err)
 	}
@@ -86,6 +95,36 @@ func WaitForWebSocketEvent(t *testing.T, wsUtil *WebSocketTestUtil) {
 	}
 }
 
+// WaitForUserEvents waits until the client has seen an event of eventType for
+// every user in userIDs
+func WaitForUserEvents(t *testing.T, wsUtil *WebSocketTestUtil, eventType string, userIDs []int) {
+	pending := make(map[int]bool, len(userIDs))
+	for _, id := range userIDs {
+		pending[id] = true
+	}
+	deadline := time.After(TestTimeout)
+	for len(pending) > 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("Timed out waiting for %s events for users %v", eventType, pending)
+		default:
+		}
+		msg, ok := wsUtil.GetMessages()
+		if !ok {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if msg["type"] != eventType {
+			continue
+		}
+		if payload, ok := msg["payload"].(map[string]interface{}); ok {
+			if id, ok := payload["id"].(float64); ok {
+				delete(pending, int(id))
+			}
+		}
+	}
+}
+
 // ValidateResponseKeys validates the response keys dynamically
 func ValidateResponseKeys(t *testing.T, response *http.Response) {
 	var data interface{}
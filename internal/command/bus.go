@@ -0,0 +1,96 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoHandler is returned for a command nobody registered a handler for
+var ErrNoHandler = errors.New("no handler registered for command")
+
+// Any is a command whose result type isn't known, as seen by middleware.
+type Any interface {
+	CommandName() string
+}
+
+// Command is a command or query with result type R. Commands embed Returns[R]
+// to declare it:
+//
+//	type GetUser struct {
+//		command.Returns[model.User]
+//		UserID int64
+//	}
+type Command[R any] interface {
+	Any
+	returns(R)
+}
+
+// Returns declares the result type of the command it is embedded in.
+type Returns[R any] struct{}
+
+func (Returns[R]) returns(R) {}
+
+// Handler handles a command; Middleware wraps one.
+type Handler func(ctx context.Context, cmd Any) (interface{}, error)
+
+type Middleware func(next Handler) Handler
+
+// Bus routes commands to their handlers through a chain of middleware shared
+// by every transport. Handlers and middleware are registered at startup,
+// before the first Dispatch.
+type Bus struct {
+	handlers   map[string]Handler
+	middleware []Middleware
+}
+
+// NewBus creates a bus. Middleware runs in the order given, the first one
+// outermost.
+func NewBus(middleware ...Middleware) *Bus {
+	return &Bus{
+		handlers:   make(map[string]Handler),
+		middleware: middleware,
+	}
+}
+
+// Use appends middleware to the chain.
+func (b *Bus) Use(middleware ...Middleware) {
+	b.middleware = append(b.middleware, middleware...)
+}
+
+// Register sets the handler for commands of type C, replacing any previous one.
+func Register[R any, C Command[R]](b *Bus, handle func(ctx context.Context, cmd C) (R, error)) {
+	var zero C
+	b.handlers[zero.CommandName()] = func(ctx context.Context, cmd Any) (interface{}, error) {
+		c, ok := cmd.(C)
+		if !ok {
+			return nil, fmt.Errorf("command %s: unexpected type %T", cmd.CommandName(), cmd)
+		}
+		return handle(ctx, c)
+	}
+}
+
+// Dispatch runs cmd through the middleware and its handler, and returns the
+// handler's result.
+func Dispatch[R any](ctx context.Context, b *Bus, cmd Command[R]) (R, error) {
+	var zero R
+	handler, ok := b.handlers[cmd.CommandName()]
+	if !ok {
+		return zero, fmt.Errorf("%w: %s", ErrNoHandler, cmd.CommandName())
+	}
+	for i := len(b.middleware) - 1; i >= 0; i-- {
+		handler = b.middleware[i](handler)
+	}
+	result, err := handler(ctx, cmd)
+	if err != nil {
+		return zero, err
+	}
+	if result == nil {
+		return zero, nil
+	}
+	r, ok := result.(R)
+	if !ok {
+		return zero, fmt.Errorf("command %s: handler returned %T", cmd.CommandName(), result)
+	}
+	return r, nil
+}
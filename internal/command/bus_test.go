@@ -0,0 +1,110 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/errs"
+)
+
+type double struct {
+	Returns[int]
+	N int
+}
+
+func (double) CommandName() string { return "double" }
+
+func (c double) Validate() error {
+	if c.N < 0 {
+		return errors.New("n must not be negative")
+	}
+	return nil
+}
+
+type greet struct {
+	Returns[string]
+}
+
+func (greet) CommandName() string { return "greet" }
+
+func newTestBus(middleware ...Middleware) *Bus {
+	bus := NewBus(middleware...)
+	Register(bus, func(ctx context.Context, cmd double) (int, error) {
+		return cmd.N * 2, nil
+	})
+	return bus
+}
+
+func TestDispatch(t *testing.T) {
+	bus := newTestBus()
+	n, err := Dispatch(context.Background(), bus, double{N: 21})
+	require.NoError(t, err)
+	require.Equal(t, 42, n)
+
+	_, err = Dispatch(context.Background(), bus, greet{})
+	require.ErrorIs(t, err, ErrNoHandler)
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, cmd Any) (interface{}, error) {
+				calls = append(calls, name+":"+cmd.CommandName())
+				return next(ctx, cmd)
+			}
+		}
+	}
+	bus := newTestBus(trace("first"))
+	bus.Use(trace("second"))
+
+	_, err := Dispatch(context.Background(), bus, double{N: 1})
+	require.NoError(t, err)
+	require.Equal(t, []string{"first:double", "second:double"}, calls)
+}
+
+func TestValidation(t *testing.T) {
+	bus := newTestBus(Validation())
+	_, err := Dispatch(context.Background(), bus, double{N: -1})
+	require.ErrorIs(t, err, errs.ErrInvalidInput)
+}
+
+func TestAuth(t *testing.T) {
+	bus := newTestBus(Auth(func(ctx context.Context, principal string, cmd Any) error {
+		if principal != "user:alice" {
+			return errors.New("only alice may double")
+		}
+		return nil
+	}))
+
+	_, err := Dispatch(context.Background(), bus, double{N: 1})
+	require.ErrorIs(t, err, errs.ErrForbidden)
+
+	n, err := Dispatch(WithPrincipal(context.Background(), "user:alice"), bus, double{N: 1})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+}
+
+type recordingTracer struct {
+	spans []string
+	errs  []error
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, func(error)) {
+	r.spans = append(r.spans, name)
+	return ctx, func(err error) { r.errs = append(r.errs, err) }
+}
+
+func TestTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	bus := newTestBus(Tracing(tracer), Validation())
+
+	_, _ = Dispatch(context.Background(), bus, double{N: 1})
+	_, _ = Dispatch(context.Background(), bus, double{N: -1})
+	require.Equal(t, []string{"command.double", "command.double"}, tracer.spans)
+	require.NoError(t, tracer.errs[0])
+	require.ErrorIs(t, tracer.errs[1], errs.ErrInvalidInput)
+}
@@ -0,0 +1,114 @@
+package command
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"UserManagement/internal/errs"
+)
+
+// commandMetrics counts commands, failures and time spent per command name,
+// served at /debug/vars
+var commandMetrics = expvar.NewMap("commands")
+
+type principalKey struct{}
+
+// WithPrincipal records who issued the commands dispatched with ctx.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal set by WithPrincipal, if any.
+func PrincipalFrom(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// Logging logs every command with how long it took.
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Any) (interface{}, error) {
+			start := time.Now()
+			result, err := next(ctx, cmd)
+			if err != nil {
+				log.Printf("Command %s failed after %s: %v", cmd.CommandName(), time.Since(start), err)
+			} else {
+				log.Printf("Command %s handled in %s", cmd.CommandName(), time.Since(start))
+			}
+			return result, err
+		}
+	}
+}
+
+// Metrics counts commands, errors and total handling time per command.
+func Metrics() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Any) (interface{}, error) {
+			start := time.Now()
+			result, err := next(ctx, cmd)
+			name := cmd.CommandName()
+			commandMetrics.Add(name+"_count", 1)
+			if err != nil {
+				commandMetrics.Add(name+"_errors", 1)
+			}
+			commandMetrics.Add(name+"_duration_us", time.Since(start).Microseconds())
+			return result, err
+		}
+	}
+}
+
+// Validator is implemented by commands that can check their own input.
+type Validator interface {
+	Validate() error
+}
+
+// Validation rejects commands whose Validate fails with errs.ErrInvalidInput,
+// before they reach a handler.
+func Validation() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Any) (interface{}, error) {
+			if v, ok := cmd.(Validator); ok {
+				if err := v.Validate(); err != nil {
+					return nil, fmt.Errorf("%w: %v", errs.ErrInvalidInput, err)
+				}
+			}
+			return next(ctx, cmd)
+		}
+	}
+}
+
+// Authorizer decides whether principal may run cmd.
+type Authorizer func(ctx context.Context, principal string, cmd Any) error
+
+// Auth rejects commands the authorizer refuses with errs.ErrForbidden.
+func Auth(authorize Authorizer) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Any) (interface{}, error) {
+			if err := authorize(ctx, PrincipalFrom(ctx), cmd); err != nil {
+				return nil, fmt.Errorf("%w: %v", errs.ErrForbidden, err)
+			}
+			return next(ctx, cmd)
+		}
+	}
+}
+
+// Tracer starts a span for a command. end is called with the outcome.
+type Tracer interface {
+	Start(ctx context.Context, name string) (spanCtx context.Context, end func(err error))
+}
+
+// Tracing wraps every command in a span, so an OpenTelemetry or similar
+// tracer can be plugged in without the handlers knowing.
+func Tracing(tracer Tracer) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, cmd Any) (interface{}, error) {
+			ctx, end := tracer.Start(ctx, "command."+cmd.CommandName())
+			result, err := next(ctx, cmd)
+			end(err)
+			return result, err
+		}
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS projection_user_status;
+DROP TABLE IF EXISTS projection_checkpoints;
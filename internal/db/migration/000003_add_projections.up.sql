@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS projection_checkpoints (
+    projection VARCHAR(100) NOT NULL,
+    kafka_partition INTEGER NOT NULL,
+    kafka_offset BIGINT NOT NULL,
+    updated_at TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (projection, kafka_partition)
+    );
+
+CREATE TABLE IF NOT EXISTS projection_user_status (
+    user_id BIGINT PRIMARY KEY,
+    status VARCHAR(10),
+    sequence BIGINT NOT NULL,
+    deleted BOOLEAN NOT NULL DEFAULT FALSE
+    );
@@ -0,0 +1,13 @@
+-- An event is queued at most once per webhook, however often the consumer
+-- retries it. Events without a sequence number can't be told apart.
+DELETE FROM webhook_deliveries a
+    USING webhook_deliveries b
+WHERE a.webhook_id = b.webhook_id
+  AND a.user_id = b.user_id
+  AND a.sequence = b.sequence
+  AND a.event_type = b.event_type
+  AND a.sequence > 0
+  AND a.delivery_id > b.delivery_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_event_idx
+    ON webhook_deliveries (webhook_id, user_id, sequence, event_type) WHERE sequence > 0;
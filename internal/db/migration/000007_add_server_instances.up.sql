@@ -0,0 +1,7 @@
+-- One lease per running server, refreshed on a heartbeat. Offline tools
+-- refuse to run while any lease is live.
+CREATE TABLE IF NOT EXISTS server_instances (
+    instance_id TEXT PRIMARY KEY,
+    started_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT NOW(),
+    heartbeat_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT NOW()
+    );
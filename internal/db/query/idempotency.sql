@@ -0,0 +1,35 @@
+-- name: ClaimIdempotencyKey :execrows
+-- Inserts the key, or takes over one that has expired or whose request was
+-- abandoned before it completed.
+INSERT INTO idempotency_keys (scope, idempotency_key, fingerprint, expires_at)
+VALUES (sqlc.arg(scope), sqlc.arg(idempotency_key), sqlc.arg(fingerprint), sqlc.arg(expires_at))
+ON CONFLICT (scope, idempotency_key) DO UPDATE
+SET fingerprint = EXCLUDED.fingerprint,
+    completed = FALSE,
+    response_status = NULL,
+    content_type = NULL,
+    response_body = NULL,
+    created_at = NOW(),
+    expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at < NOW()
+   OR (NOT idempotency_keys.completed AND idempotency_keys.created_at < sqlc.arg(stale_before));
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys
+WHERE scope = $1 AND idempotency_key = $2 LIMIT 1;
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET completed = TRUE,
+    response_status = $3,
+    content_type = $4,
+    response_body = $5
+WHERE scope = $1 AND idempotency_key = $2;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE scope = $1 AND idempotency_key = $2;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at < NOW();
@@ -0,0 +1,18 @@
+-- name: HeartbeatServerInstance :exec
+INSERT INTO server_instances (instance_id)
+VALUES ($1)
+ON CONFLICT (instance_id) DO UPDATE SET heartbeat_at = NOW();
+
+-- name: DeleteServerInstance :exec
+DELETE FROM server_instances
+WHERE instance_id = $1;
+
+-- name: DeleteStaleServerInstances :execrows
+DELETE FROM server_instances
+WHERE heartbeat_at < NOW() - make_interval(secs => sqlc.arg(ttl_seconds)::float8);
+
+-- name: ListServerInstances :many
+SELECT instance_id, started_at, heartbeat_at,
+       heartbeat_at >= NOW() - make_interval(secs => sqlc.arg(ttl_seconds)::float8) AS live
+FROM server_instances
+ORDER BY instance_id;
@@ -0,0 +1,28 @@
+-- name: GetCheckpoints :many
+SELECT kafka_partition, kafka_offset FROM projection_checkpoints
+WHERE projection = $1;
+
+-- name: SaveCheckpoint :exec
+INSERT INTO projection_checkpoints (projection, kafka_partition, kafka_offset)
+VALUES ($1, $2, $3)
+ON CONFLICT (projection, kafka_partition)
+    DO UPDATE SET kafka_offset = EXCLUDED.kafka_offset, updated_at = NOW();
+
+-- name: DeleteCheckpoints :exec
+DELETE FROM projection_checkpoints
+WHERE projection = $1;
+
+-- name: UpsertUserStatus :exec
+INSERT INTO projection_user_status (user_id, status, sequence, deleted)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id)
+    DO UPDATE SET status = EXCLUDED.status, sequence = EXCLUDED.sequence, deleted = EXCLUDED.deleted
+    WHERE projection_user_status.sequence < EXCLUDED.sequence;
+
+-- name: CountUsersByStatus :many
+SELECT COALESCE(status, '')::text AS status, COUNT(*) AS count FROM projection_user_status
+WHERE NOT deleted
+GROUP BY status;
+
+-- name: DeleteAllUserStatus :exec
+DELETE FROM projection_user_status;
@@ -7,6 +7,12 @@ VALUES ($1, $2, $3, $4, $5, $6)
 SELECT * FROM users
 WHERE user_id = $1;
 
+-- name: GetUserByEmail :one
+SELECT * FROM users
+WHERE lower(email) = lower($1)
+ORDER BY user_id
+LIMIT 1;
+
 -- name: ListUsers :many
 SELECT * FROM users;
 
@@ -19,7 +25,8 @@ SET
     phone = COALESCE(sqlc.narg(phone), phone),
     age = COALESCE(sqlc.narg(age), age),
     status = COALESCE(sqlc.narg(status), status),
-    updated_at = NOW()
+    updated_at = NOW(),
+    version = version + 1
 WHERE user_id = $1
     RETURNING *;
 
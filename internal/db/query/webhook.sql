@@ -0,0 +1,98 @@
+-- name: CreateWebhook :one
+INSERT INTO webhooks (url, secret, event_types)
+VALUES ($1, $2, $3)
+    RETURNING *;
+
+-- name: GetWebhook :one
+SELECT * FROM webhooks
+WHERE webhook_id = $1 LIMIT 1;
+
+-- name: ListWebhooks :many
+SELECT * FROM webhooks
+ORDER BY webhook_id;
+
+-- name: ListActiveWebhooks :many
+SELECT * FROM webhooks
+WHERE active
+ORDER BY webhook_id;
+
+-- name: UpdateWebhook :one
+UPDATE webhooks
+SET url = $2,
+    secret = $3,
+    event_types = $4,
+    active = $5,
+    consecutive_failures = CASE WHEN $5 THEN 0 ELSE consecutive_failures END,
+    disabled_at = CASE WHEN $5 THEN NULL ELSE disabled_at END,
+    updated_at = NOW()
+WHERE webhook_id = $1
+    RETURNING *;
+
+-- name: DeleteWebhook :execrows
+DELETE FROM webhooks
+WHERE webhook_id = $1;
+
+-- name: RecordWebhookSuccess :exec
+UPDATE webhooks
+SET consecutive_failures = 0
+WHERE webhook_id = $1 AND consecutive_failures > 0;
+
+-- name: RecordWebhookFailure :one
+UPDATE webhooks
+SET consecutive_failures = consecutive_failures + 1,
+    active = active AND consecutive_failures + 1 < sqlc.arg(max_failures)::int,
+    disabled_at = CASE WHEN active AND consecutive_failures + 1 >= sqlc.arg(max_failures)::int THEN NOW() ELSE disabled_at END,
+    updated_at = NOW()
+WHERE webhook_id = sqlc.arg(webhook_id)
+    RETURNING active;
+
+-- name: CreateWebhookDelivery :execrows
+-- Does nothing if the event is already queued for the webhook.
+INSERT INTO webhook_deliveries (webhook_id, event_type, user_id, sequence, payload, next_attempt_at)
+VALUES ($1, $2, $3, $4, $5, NOW())
+ON CONFLICT (webhook_id, user_id, sequence, event_type) WHERE sequence > 0 DO NOTHING;
+
+-- name: GetWebhookDelivery :one
+SELECT * FROM webhook_deliveries
+WHERE delivery_id = $1 LIMIT 1;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE webhook_id = sqlc.arg(webhook_id)
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status)::text)
+ORDER BY delivery_id DESC
+LIMIT sqlc.arg(row_limit);
+
+-- name: ClaimDueWebhookDeliveries :many
+-- Leases due deliveries by pushing next_attempt_at out, so that other
+-- instances skip them while they are being attempted.
+UPDATE webhook_deliveries
+SET next_attempt_at = sqlc.arg(lease_until), updated_at = NOW()
+WHERE delivery_id IN (
+    SELECT delivery_id FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at
+    LIMIT sqlc.arg(row_limit)
+    FOR UPDATE SKIP LOCKED
+)
+    RETURNING *;
+
+-- name: RecordWebhookDeliveryAttempt :exec
+UPDATE webhook_deliveries
+SET status = $2,
+    attempts = attempts + 1,
+    response_status = $3,
+    last_error = $4,
+    next_attempt_at = $5,
+    updated_at = NOW()
+WHERE delivery_id = $1;
+
+-- name: RedeliverWebhookDelivery :one
+UPDATE webhook_deliveries
+SET status = 'pending',
+    attempts = 0,
+    last_error = NULL,
+    next_attempt_at = NOW(),
+    updated_at = NOW()
+WHERE delivery_id = $1
+    RETURNING *;
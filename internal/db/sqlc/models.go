@@ -6,8 +6,42 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"time"
 )
 
+type IdempotencyKey struct {
+	Scope          string         `json:"scope"`
+	IdempotencyKey string         `json:"idempotency_key"`
+	Fingerprint    string         `json:"fingerprint"`
+	Completed      bool           `json:"completed"`
+	ResponseStatus sql.NullInt32  `json:"response_status"`
+	ContentType    sql.NullString `json:"content_type"`
+	ResponseBody   []byte         `json:"response_body"`
+	CreatedAt      time.Time      `json:"created_at"`
+	ExpiresAt      time.Time      `json:"expires_at"`
+}
+
+type ProjectionCheckpoint struct {
+	Projection     string       `json:"projection"`
+	KafkaPartition int32        `json:"kafka_partition"`
+	KafkaOffset    int64        `json:"kafka_offset"`
+	UpdatedAt      sql.NullTime `json:"updated_at"`
+}
+
+type ProjectionUserStatus struct {
+	UserID   int64          `json:"user_id"`
+	Status   sql.NullString `json:"status"`
+	Sequence int64          `json:"sequence"`
+	Deleted  bool           `json:"deleted"`
+}
+
+type ServerInstance struct {
+	InstanceID  string    `json:"instance_id"`
+	StartedAt   time.Time `json:"started_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+}
+
 type User struct {
 	UserID    int64          `json:"user_id"`
 	FirstName string         `json:"first_name"`
@@ -18,4 +52,33 @@ type User struct {
 	Status    sql.NullString `json:"status"`
 	CreatedAt sql.NullTime   `json:"created_at"`
 	UpdatedAt sql.NullTime   `json:"updated_at"`
+	Version   int64          `json:"version"`
+}
+
+type Webhook struct {
+	WebhookID           int64        `json:"webhook_id"`
+	Url                 string       `json:"url"`
+	Secret              string       `json:"secret"`
+	EventTypes          []string     `json:"event_types"`
+	Active              bool         `json:"active"`
+	ConsecutiveFailures int32        `json:"consecutive_failures"`
+	DisabledAt          sql.NullTime `json:"disabled_at"`
+	CreatedAt           time.Time    `json:"created_at"`
+	UpdatedAt           time.Time    `json:"updated_at"`
+}
+
+type WebhookDelivery struct {
+	DeliveryID     int64           `json:"delivery_id"`
+	WebhookID      int64           `json:"webhook_id"`
+	EventType      string          `json:"event_type"`
+	UserID         int64           `json:"user_id"`
+	Sequence       int64           `json:"sequence"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	ResponseStatus sql.NullInt32   `json:"response_status"`
+	LastError      sql.NullString  `json:"last_error"`
+	NextAttemptAt  sql.NullTime    `json:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
 }
@@ -13,7 +13,7 @@ import (
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (first_name, last_name, email, phone, age, status)
 VALUES ($1, $2, $3, $4, $5, $6)
-    RETURNING user_id, first_name, last_name, email, phone, age, status, created_at, updated_at
+    RETURNING user_id, first_name, last_name, email, phone, age, status, created_at, updated_at, version
 `
 
 type CreateUserParams struct {
@@ -45,6 +45,7 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Status,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Version,
 	)
 	return i, err
 }
@@ -52,7 +53,7 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 const deleteUser = `-- name: DeleteUser :one
 DELETE FROM users
 WHERE user_id = $1
-    RETURNING user_id, first_name, last_name, email, phone, age, status, created_at, updated_at
+    RETURNING user_id, first_name, last_name, email, phone, age, status, created_at, updated_at, version
 `
 
 func (q *Queries) DeleteUser(ctx context.Context, userID int64) (User, error) {
@@ -68,12 +69,13 @@ func (q *Queries) DeleteUser(ctx context.Context, userID int64) (User, error) {
 		&i.Status,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Version,
 	)
 	return i, err
 }
 
 const getUser = `-- name: GetUser :one
-SELECT user_id, first_name, last_name, email, phone, age, status, created_at, updated_at FROM users
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, updated_at, version FROM users
 WHERE user_id = $1
 `
 
@@ -90,12 +92,38 @@ func (q *Queries) GetUser(ctx context.Context, userID int64) (User, error) {
 		&i.Status,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, updated_at, version FROM users
+WHERE lower(email) = lower($1)
+ORDER BY user_id
+LIMIT 1
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
+	var i User
+	err := row.Scan(
+		&i.UserID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.Age,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Version,
 	)
 	return i, err
 }
 
 const listUsers = `-- name: ListUsers :many
-SELECT user_id, first_name, last_name, email, phone, age, status, created_at, updated_at FROM users
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, updated_at, version FROM users
 `
 
 func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
@@ -117,6 +145,7 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 			&i.Status,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -140,9 +169,10 @@ SET
     phone = COALESCE($5, phone),
     age = COALESCE($6, age),
     status = COALESCE($7, status),
-    updated_at = NOW()
+    updated_at = NOW(),
+    version = version + 1
 WHERE user_id = $1
-    RETURNING user_id, first_name, last_name, email, phone, age, status, created_at, updated_at
+    RETURNING user_id, first_name, last_name, email, phone, age, status, created_at, updated_at, version
 `
 
 type UpdateUserParams struct {
@@ -176,6 +206,7 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Status,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Version,
 	)
 	return i, err
 }
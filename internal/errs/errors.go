@@ -3,7 +3,18 @@ package errs
 import "errors"
 
 var (
-	ErrUserNotFound  = errors.New("user not found")
-	ErrDuplicateUser = errors.New("user already exists")
-	ErrInternal      = errors.New("internal error")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrDuplicateUser    = errors.New("user already exists")
+	ErrInternal         = errors.New("internal error")
+	ErrQueueFull        = errors.New("request queue is full")
+	ErrServiceClosed    = errors.New("service is shutting down")
+	ErrInvalidInput     = errors.New("invalid input")
+	ErrForbidden        = errors.New("forbidden")
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrKeyNotFound      = errors.New("idempotency key not found")
+	ErrKeyReused        = errors.New("idempotency key was used for a different request")
+	ErrKeyInProgress    = errors.New("a request with this idempotency key is in progress")
+	ErrImportNotFound   = errors.New("import not found")
+	ErrImportTooLarge   = errors.New("import file is too large")
 )
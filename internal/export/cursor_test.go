@@ -0,0 +1,112 @@
+package export
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// fakeCursor is a database holding only a cursor over generated users; it
+// answers the DECLARE and FETCH statements of ExportUsersRepo.
+// Rows are made as they are fetched, like a driver decoding them off the
+// wire, so the database holds nothing in memory.
+type fakeCursor struct {
+	rows int
+}
+
+func (c fakeCursor) Connect(context.Context) (driver.Conn, error) {
+	return &cursorConn{rows: c.rows}, nil
+}
+
+func (c fakeCursor) Driver() driver.Driver {
+	return nil
+}
+
+type cursorConn struct {
+	rows int
+	// next is the ID of the next row to fetch; zero without a cursor
+	next int
+}
+
+func (c *cursorConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *cursorConn) Close() error {
+	return nil
+}
+
+func (c *cursorConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *cursorConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *cursorConn) Commit() error {
+	c.next = 0
+	return nil
+}
+
+func (c *cursorConn) Rollback() error {
+	c.next = 0
+	return nil
+}
+
+func (c *cursorConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if !strings.HasPrefix(query, "DECLARE ") {
+		return nil, fmt.Errorf("unexpected statement %q", query)
+	}
+	c.next = 1
+	return driver.RowsAffected(0), nil
+}
+
+func (c *cursorConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	var batch int
+	if _, err := fmt.Sscanf(query, "FETCH FORWARD %d", &batch); err != nil || c.next == 0 {
+		return nil, fmt.Errorf("unexpected query %q", query)
+	}
+	last := min(c.next+batch-1, c.rows)
+	rows := &cursorRows{next: c.next, last: last}
+	c.next = last + 1
+	return rows, nil
+}
+
+type cursorRows struct {
+	next, last int
+}
+
+func (r *cursorRows) Columns() []string {
+	return []string{"user_id", "first_name", "last_name", "email", "phone", "age", "status", "created_at", "updated_at", "version"}
+}
+
+func (r *cursorRows) Close() error {
+	return nil
+}
+
+func (r *cursorRows) Next(dest []driver.Value) error {
+	if r.next > r.last {
+		return io.EOF
+	}
+	id := r.next
+	r.next++
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(id) * time.Second)
+	copy(dest, []driver.Value{
+		int64(id),
+		fmt.Sprintf("First%d", id),
+		fmt.Sprintf("Last%d", id),
+		fmt.Sprintf("user%d@example.com", id),
+		fmt.Sprintf("555-%04d", id%10_000),
+		int64(20 + id%50),
+		"Active",
+		created,
+		created,
+		int64(1),
+	})
+	return nil
+}
@@ -0,0 +1,187 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"UserManagement/internal/model"
+)
+
+// Export formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatXLSX   = "xlsx"
+)
+
+// Fields are the user fields an export can hold, in their default order.
+var Fields = []string{"id", "first_name", "last_name", "email", "phone", "age", "status", "version"}
+
+// Writer writes users one at a time, so an export of any size is never held
+// in memory. Close finishes the file.
+type Writer interface {
+	Write(user model.User) error
+	Close() error
+}
+
+// NewWriter creates a writer for format holding fields, in the order given.
+func NewWriter(format string, w io.Writer, fields []string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, fields)
+	case FormatNDJSON:
+		return &ndjsonWriter{w: bufio.NewWriter(w), fields: fields}, nil
+	case FormatXLSX:
+		return newXLSXWriter(w, fields)
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected csv, ndjson or xlsx", format)
+	}
+}
+
+// ContentType is the media type of a format.
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// ParseFields reads a comma-separated field selection; an empty one selects
+// every field.
+func ParseFields(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return Fields, nil
+	}
+	var fields []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if !isField(field) {
+			return nil, fmt.Errorf("unknown field %q, expected some of %s", field, strings.Join(Fields, ", "))
+		}
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+func isField(name string) bool {
+	for _, field := range Fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// value is a field of user as text. ok is false when it isn't set, and
+// number is true for numeric fields.
+func value(user model.User, field string) (text string, ok, number bool) {
+	switch field {
+	case "id":
+		return strconv.FormatInt(user.ID, 10), true, true
+	case "first_name":
+		return user.FirstName, true, false
+	case "last_name":
+		return user.LastName, true, false
+	case "email":
+		return user.Email, true, false
+	case "phone":
+		return optional(user.Phone)
+	case "age":
+		if user.Age == nil {
+			return "", false, true
+		}
+		return strconv.FormatInt(int64(*user.Age), 10), true, true
+	case "status":
+		return optional(user.Status)
+	case "version":
+		return strconv.FormatInt(user.Version, 10), true, true
+	default:
+		return "", false, false
+	}
+}
+
+func optional(s *string) (string, bool, bool) {
+	if s == nil {
+		return "", false, false
+	}
+	return *s, true, false
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	fields []string
+	record []string
+}
+
+// newCSVWriter writes the header row straight away.
+func newCSVWriter(w io.Writer, fields []string) (*csvWriter, error) {
+	c := &csvWriter{w: csv.NewWriter(w), fields: fields, record: make([]string, len(fields))}
+	if err := c.w.Write(fields); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *csvWriter) Write(user model.User) error {
+	for i, field := range c.fields {
+		c.record[i], _, _ = value(user, field)
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonWriter writes one object per line with the fields in order; unset
+// fields are null.
+type ndjsonWriter struct {
+	w      *bufio.Writer
+	fields []string
+}
+
+func (n *ndjsonWriter) Write(user model.User) error {
+	n.w.WriteByte('{')
+	for i, field := range n.fields {
+		if i > 0 {
+			n.w.WriteByte(',')
+		}
+		n.w.WriteString(strconv.Quote(field))
+		n.w.WriteByte(':')
+		text, ok, number := value(user, field)
+		switch {
+		case !ok:
+			n.w.WriteString("null")
+		case number:
+			n.w.WriteString(text)
+		default:
+			encoded, err := json.Marshal(text)
+			if err != nil {
+				return err
+			}
+			n.w.Write(encoded)
+		}
+	}
+	_, err := n.w.WriteString("}\n")
+	return err
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
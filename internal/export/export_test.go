@@ -0,0 +1,182 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/model"
+	"UserManagement/internal/repository"
+)
+
+func testUsers() []model.User {
+	phone, status, age := "555-0100", "Active", int32(36)
+	return []model.User{
+		{ID: 1, FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com", Phone: &phone, Age: &age, Status: &status, Version: 3},
+		{ID: 2, FirstName: `Grace "Amazing"`, LastName: "Hopper, Jr. <& co>", Email: "grace@example.com", Version: 1},
+	}
+}
+
+func export(t *testing.T, format string, fields []string, users []model.User) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf, fields)
+	require.NoError(t, err)
+	for _, user := range users {
+		require.NoError(t, w.Write(user))
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestCSV(t *testing.T) {
+	out := export(t, FormatCSV, []string{"id", "last_name", "age"}, testUsers())
+	require.Equal(t, "id,last_name,age\n1,Lovelace,36\n2,\"Hopper, Jr. <& co>\",\n", string(out))
+}
+
+func TestNDJSON(t *testing.T) {
+	out := export(t, FormatNDJSON, Fields, testUsers())
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	require.Len(t, lines, 2)
+	require.True(t, strings.HasPrefix(lines[0], `{"id":1,"first_name":"Ada",`))
+
+	var user model.User
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &user))
+	require.Equal(t, testUsers()[1], user)
+}
+
+// sheetRows reads the cells of every sheet in an XLSX file.
+func sheetRows(t *testing.T, out []byte) [][][]string {
+	r, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	require.NoError(t, err)
+	names := make(map[string]*zip.File)
+	for _, f := range r.File {
+		names[f.Name] = f
+	}
+	for _, part := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels"} {
+		require.Contains(t, names, part)
+	}
+
+	var sheets [][][]string
+	for i := 1; names[fmt.Sprintf("xl/worksheets/sheet%d.xml", i)] != nil; i++ {
+		f, err := names[fmt.Sprintf("xl/worksheets/sheet%d.xml", i)].Open()
+		require.NoError(t, err)
+		var sheet struct {
+			Rows []struct {
+				Cells []struct {
+					Value  string `xml:"v"`
+					Inline string `xml:"is>t"`
+				} `xml:"c"`
+			} `xml:"sheetData>row"`
+		}
+		require.NoError(t, xml.NewDecoder(f).Decode(&sheet))
+		var rows [][]string
+		for _, row := range sheet.Rows {
+			var cells []string
+			for _, c := range row.Cells {
+				cells = append(cells, c.Value+c.Inline)
+			}
+			rows = append(rows, cells)
+		}
+		sheets = append(sheets, rows)
+	}
+	return sheets
+}
+
+func TestXLSX(t *testing.T) {
+	out := export(t, FormatXLSX, []string{"id", "last_name", "age"}, testUsers())
+	require.Equal(t, [][][]string{{
+		{"id", "last_name", "age"},
+		{"1", "Lovelace", "36"},
+		{"2", "Hopper, Jr. <& co>", ""},
+	}}, sheetRows(t, out))
+}
+
+func TestXLSXStartsNewSheetsWhenFull(t *testing.T) {
+	defer func(rows int) { maxSheetRows = rows }(maxSheetRows)
+	maxSheetRows = 2
+
+	users := append(testUsers(), model.User{ID: 3, LastName: "Turing"})
+	sheets := sheetRows(t, export(t, FormatXLSX, []string{"id", "last_name"}, users))
+	require.Equal(t, [][][]string{
+		{{"id", "last_name"}, {"1", "Lovelace"}},
+		{{"id", "last_name"}, {"2", "Hopper, Jr. <& co>"}},
+		{{"id", "last_name"}, {"3", "Turing"}},
+	}, sheets)
+}
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields("")
+	require.NoError(t, err)
+	require.Equal(t, Fields, fields)
+
+	fields, err = ParseFields(" email, id,email")
+	require.NoError(t, err)
+	require.Equal(t, []string{"email", "id"}, fields)
+
+	_, err = ParseFields("email,password")
+	require.Error(t, err)
+}
+
+// BenchmarkExport exports growing numbers of users from a fake cursor
+// through ExportUsersRepo and reports the most heap still live at any point,
+// which stays the same however many rows there are.
+func BenchmarkExport(b *testing.B) {
+	for _, format := range []string{FormatCSV, FormatNDJSON, FormatXLSX} {
+		for _, rows := range []int{10_000, 100_000, 1_000_000} {
+			b.Run(fmt.Sprintf("%s/%d", format, rows), func(b *testing.B) {
+				db := sql.OpenDB(fakeCursor{rows: rows})
+				defer db.Close()
+				repo := repository.NewPostgresUserRepository(db)
+
+				// The heap is sampled ten times an export
+				every := rows / 10
+				base := liveHeap()
+				var peak uint64
+				for i := 0; i < b.N; i++ {
+					w, err := NewWriter(format, io.Discard, Fields)
+					if err != nil {
+						b.Fatal(err)
+					}
+					exported := 0
+					err = repo.ExportUsersRepo(context.Background(), model.UserFilter{}, func(user model.User) error {
+						if exported++; exported%every == 0 {
+							b.StopTimer()
+							peak = max(peak, liveHeap())
+							b.StartTimer()
+						}
+						return w.Write(user)
+					})
+					if err != nil {
+						b.Fatal(err)
+					}
+					if exported != rows {
+						b.Fatalf("exported %d users, want %d", exported, rows)
+					}
+					if err := w.Close(); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(max(peak, base)-base)/(1<<20), "live-heap-MB")
+			})
+		}
+	}
+}
+
+// liveHeap is the heap still reachable: what is allocated once a collection
+// has freed the garbage.
+func liveHeap() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
@@ -0,0 +1,148 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"UserManagement/internal/model"
+)
+
+// maxSheetRows is the most rows Excel shows on one sheet; longer exports
+// carry on in another sheet, each starting with the header
+var maxSheetRows = 1 << 20
+
+const (
+	xmlHeader     = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+	spreadsheetNS = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	relsNS        = "http://schemas.openxmlformats.org/package/2006/relationships"
+	officeRelsNS  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+)
+
+// xlsxWriter streams a workbook: rows are written into the current sheet's
+// zip entry as they come, with strings inline so there is no shared string
+// table to keep. The parts listing the sheets are written by Close, once the
+// number of sheets is known.
+type xlsxWriter struct {
+	zip    *zip.Writer
+	sheet  *bufio.Writer
+	fields []string
+	sheets int
+	rows   int
+}
+
+func newXLSXWriter(w io.Writer, fields []string) (*xlsxWriter, error) {
+	x := &xlsxWriter{zip: zip.NewWriter(w), fields: fields}
+	if err := x.startSheet(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *xlsxWriter) startSheet() error {
+	x.sheets++
+	f, err := x.zip.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", x.sheets))
+	if err != nil {
+		return err
+	}
+	if x.sheet == nil {
+		x.sheet = bufio.NewWriterSize(f, 64*1024)
+	} else {
+		x.sheet.Reset(f)
+	}
+	x.sheet.WriteString(xmlHeader)
+	x.sheet.WriteString(`<worksheet xmlns="` + spreadsheetNS + `"><sheetData>`)
+	x.rows = 0
+	x.sheet.WriteString("<row>")
+	for _, field := range x.fields {
+		x.cell(field, false)
+	}
+	x.sheet.WriteString("</row>")
+	x.rows++
+	return nil
+}
+
+func (x *xlsxWriter) endSheet() error {
+	x.sheet.WriteString("</sheetData></worksheet>")
+	return x.sheet.Flush()
+}
+
+func (x *xlsxWriter) Write(user model.User) error {
+	if x.rows == maxSheetRows {
+		if err := x.endSheet(); err != nil {
+			return err
+		}
+		if err := x.startSheet(); err != nil {
+			return err
+		}
+	}
+	x.sheet.WriteString("<row>")
+	for _, field := range x.fields {
+		text, ok, number := value(user, field)
+		if !ok {
+			x.sheet.WriteString("<c/>")
+			continue
+		}
+		x.cell(text, number)
+	}
+	_, err := x.sheet.WriteString("</row>")
+	x.rows++
+	return err
+}
+
+func (x *xlsxWriter) cell(text string, number bool) {
+	if number {
+		x.sheet.WriteString("<c><v>" + text + "</v></c>")
+		return
+	}
+	x.sheet.WriteString(`<c t="inlineStr"><is><t`)
+	if strings.TrimSpace(text) != text {
+		x.sheet.WriteString(` xml:space="preserve"`)
+	}
+	x.sheet.WriteByte('>')
+	// Characters XML can't hold become U+FFFD
+	_ = xml.EscapeText(x.sheet, []byte(text))
+	x.sheet.WriteString("</t></is></c>")
+}
+
+func (x *xlsxWriter) Close() error {
+	if err := x.endSheet(); err != nil {
+		return err
+	}
+	var types, sheets, rels strings.Builder
+	for i := 1; i <= x.sheets; i++ {
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+		name := "Users"
+		if i > 1 {
+			name = fmt.Sprintf("Users %d", i)
+		}
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, name, i, i)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s/worksheet" Target="worksheets/sheet%d.xml"/>`, i, officeRelsNS, i)
+	}
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			types.String() + `</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="` + relsNS + `">` +
+			`<Relationship Id="rId1" Type="` + officeRelsNS + `/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="` + spreadsheetNS + `" xmlns:r="` + officeRelsNS + `"><sheets>` +
+			sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="` + relsNS + `">` + rels.String() + `</Relationships>`},
+	}
+	for _, part := range parts {
+		f, err := x.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, xmlHeader+part.body); err != nil {
+			return err
+		}
+	}
+	return x.zip.Close()
+}
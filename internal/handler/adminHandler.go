@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	chi "github.com/go-chi/chi/v5"
+
+	"UserManagement/internal/util"
+	"UserManagement/internal/ws"
+)
+
+type ConnectionManager interface {
+	Connections() []ws.ConnectionInfo
+	Disconnect(id string) error
+}
+
+type AdminHandler struct {
+	connections ConnectionManager
+}
+
+func NewAdminHandler(connections ConnectionManager) *AdminHandler {
+	return &AdminHandler{connections: connections}
+}
+
+// ListConnections returns the WebSocket clients connected to this instance.
+func (h *AdminHandler) ListConnections(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.connections.Connections())
+}
+
+// DisconnectConnection force-closes one WebSocket client.
+func (h *AdminHandler) DisconnectConnection(w http.ResponseWriter, r *http.Request) {
+	err := h.connections.Disconnect(chi.URLParam(r, "id"))
+	if errors.Is(err, ws.ErrConnectionNotFound) {
+		util.WriteJSONResponse(w, http.StatusNotFound, util.APIResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
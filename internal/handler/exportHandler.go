@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"UserManagement/internal/command"
+	"UserManagement/internal/export"
+	"UserManagement/internal/model"
+)
+
+// ExportUsers streams every user matching the listing filters as CSV (the
+// default), NDJSON or XLSX, with the columns chosen by ?fields=.
+func (h *UserHandler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fields, err := export.ParseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	out := &sentWriter{w: w}
+	users, err := export.NewWriter(format, out, fields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="users-%s.%s"`, time.Now().UTC().Format("20060102-150405"), format))
+	n, err := command.Dispatch(commandContext(r), h.bus, model.ExportUsers{Filter: filter, Each: users.Write})
+	if err == nil {
+		err = users.Close()
+	}
+	if err == nil {
+		log.Printf("Exported %d users as %s", n, format)
+		return
+	}
+	if !out.sent {
+		w.Header().Del("Content-Disposition")
+		writeCommandError(w, err)
+		return
+	}
+	// Part of the file has gone out already; cutting the response short is
+	// the only way left to tell the client it is incomplete
+	log.Printf("Export failed: %v", err)
+	panic(http.ErrAbortHandler)
+}
+
+// sentWriter notes whether anything reached the client, after which the
+// status can't be changed anymore.
+type sentWriter struct {
+	w    http.ResponseWriter
+	sent bool
+}
+
+func (s *sentWriter) Write(p []byte) (int, error) {
+	s.sent = true
+	return s.w.Write(p)
+}
+
+// parseUserFilter reads the export filters: status, email (any part of it),
+// and created_after and created_before as RFC 3339 times.
+func parseUserFilter(r *http.Request) (model.UserFilter, error) {
+	query := r.URL.Query()
+	filter := model.UserFilter{
+		Status: query.Get("status"),
+		Email:  query.Get("email"),
+	}
+	for name, dst := range map[string]**time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return model.UserFilter{}, fmt.Errorf("%s must be an RFC 3339 time", name)
+		}
+		*dst = &t
+	}
+	return filter, filter.Validate()
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	chi "github.com/go-chi/chi/v5"
+
+	"UserManagement/internal/errs"
+	"UserManagement/internal/importer"
+	"UserManagement/internal/model"
+	"UserManagement/internal/ws"
+)
+
+// mapPrefix starts the query parameters that map user fields to columns,
+// e.g. map.email=Work%20Email
+const mapPrefix = "map."
+
+// ImportTokenHeader carries the token returned when an import starts, which
+// callers who aren't authenticated need to read or cancel it
+const ImportTokenHeader = "Import-Token"
+
+// ImportJobs runs user imports in the background
+type ImportJobs interface {
+	Start(principal, format string, dryRun bool, mapping importer.Mapping, body io.Reader) (model.ImportJob, error)
+	Get(principal, token, id string) (model.ImportJob, error)
+	Report(principal, token, id, result string, w io.Writer) error
+	Cancel(principal, token, id string) (model.ImportJob, error)
+}
+
+type ImportHandler struct {
+	jobs ImportJobs
+}
+
+func NewImportHandler(jobs ImportJobs) *ImportHandler {
+	return &ImportHandler{jobs: jobs}
+}
+
+// ImportUsers starts importing the CSV or NDJSON body and answers 202 with
+// the job, whose status is at /imports/{id}.
+func (h *ImportHandler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format, err := importFormat(query.Get("format"), r.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(w, http.StatusUnsupportedMediaType, err)
+		return
+	}
+	dryRun := false
+	if value := query.Get("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid dry_run"))
+			return
+		}
+	}
+	mapping := importer.Mapping{}
+	for key, values := range query {
+		if field, ok := strings.CutPrefix(key, mapPrefix); ok && len(values) > 0 {
+			mapping[field] = values[0]
+		}
+	}
+
+	job, err := h.jobs.Start(ws.PrincipalOf(r), format, dryRun, mapping, r.Body)
+	switch {
+	case errors.Is(err, errs.ErrInvalidInput):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, errs.ErrImportTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+	case err != nil:
+		log.Printf("Failed to start import: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		w.Header().Set("Location", "/imports/"+job.ID)
+		writeJSONStatus(w, http.StatusAccepted, job)
+	}
+}
+
+// importFormat takes the format from the format parameter, or else from the
+// Content-Type.
+func importFormat(param, contentType string) (string, error) {
+	if param == importer.FormatCSV || param == importer.FormatNDJSON {
+		return param, nil
+	}
+	if param != "" {
+		return "", fmt.Errorf("unknown format %q, expected csv or ndjson", param)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return importer.FormatCSV, nil
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return importer.FormatNDJSON, nil
+	default:
+		return "", errors.New("send text/csv or application/x-ndjson, or set format")
+	}
+}
+
+func (h *ImportHandler) GetImport(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Get(jobOwner(r), r.Header.Get(ImportTokenHeader), chi.URLParam(r, "id"))
+	if err != nil {
+		h.jobError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusOK, job)
+}
+
+// GetImportReport streams the per-row report written so far as NDJSON,
+// optionally only the rows with ?result=created|updated|skipped|failed.
+func (h *ImportHandler) GetImportReport(w http.ResponseWriter, r *http.Request) {
+	result := r.URL.Query().Get("result")
+	switch result {
+	case "", model.RowCreated, model.RowUpdated, model.RowSkipped, model.RowFailed:
+	default:
+		writeError(w, http.StatusBadRequest, errors.New("invalid result"))
+		return
+	}
+	principal, token, id := jobOwner(r), r.Header.Get(ImportTokenHeader), chi.URLParam(r, "id")
+	if _, err := h.jobs.Get(principal, token, id); err != nil {
+		h.jobError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if err := h.jobs.Report(principal, token, id, result, w); err != nil {
+		log.Printf("Failed to write import report %s: %v", id, err)
+	}
+}
+
+// CancelImport stops a running import; rows already imported stay imported.
+func (h *ImportHandler) CancelImport(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Cancel(jobOwner(r), r.Header.Get(ImportTokenHeader), chi.URLParam(r, "id"))
+	if err != nil {
+		h.jobError(w, err)
+		return
+	}
+	writeJSONStatus(w, http.StatusAccepted, job)
+}
+
+// jobOwner is the principal whose imports the caller reaches without a
+// token: the authenticated user's, never an anonymous caller's IP address.
+func jobOwner(r *http.Request) string {
+	if ws.UserOf(r) == "" {
+		return ""
+	}
+	return ws.PrincipalOf(r)
+}
+
+func (h *ImportHandler) jobError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errs.ErrImportNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	log.Printf("Failed to read import: %v", err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	chi "github.com/go-chi/chi/v5"
+
+	"UserManagement/internal/projection"
+	"UserManagement/internal/util"
+)
+
+type ProjectionHandler struct {
+	projections projection.Registry
+}
+
+func NewProjectionHandler(projections projection.Registry) *ProjectionHandler {
+	return &ProjectionHandler{projections: projections}
+}
+
+func (h *ProjectionHandler) GetProjection(w http.ResponseWriter, r *http.Request) {
+	p, err := h.projections.Get(chi.URLParam(r, "name"))
+	if errors.Is(err, projection.ErrUnknownProjection) {
+		util.WriteJSONResponse(w, http.StatusNotFound, util.APIResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+	state, err := p.State(r.Context())
+	if err != nil {
+		log.Printf("Failed to read projection %s: %v", p.Name(), err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, state)
+}
@@ -4,50 +4,84 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 
+	"UserManagement/internal/command"
 	"UserManagement/internal/errs"
 	"UserManagement/internal/model"
 	"UserManagement/internal/util"
+	"UserManagement/internal/ws"
 )
 
-type UserService interface {
-	QueueCUDRequest(req model.CUDRequest)
-}
+// retryAfter is what clients are told to wait when the service is busy
+const retryAfter = "1"
+
+// DefaultMaxBatchSize caps POST /users:batch when no size is configured
+const DefaultMaxBatchSize = 500
+
+// maxBatchOpBytes is the room a batch body gets per allowed operation, so an
+// oversized batch is turned away before it is decoded in full
+const maxBatchOpBytes = 4 << 10
 
 type UserHandler struct {
-	us UserService
+	bus          *command.Bus
+	maxBatchSize int
 }
 
-func NewUserHandler(us UserService) *UserHandler {
-	return &UserHandler{us: us}
+// NewUserHandler creates a handler that accepts batches of up to
+// maxBatchSize operations, or DefaultMaxBatchSize when it is not positive.
+func NewUserHandler(bus *command.Bus, maxBatchSize int) *UserHandler {
+	if maxBatchSize <= 0 {
+		maxBatchSize = DefaultMaxBatchSize
+	}
+	return &UserHandler{bus: bus, maxBatchSize: maxBatchSize}
 }
 
-// Common function to handle requests
-func (h *UserHandler) handleRequest(ctx context.Context, w http.ResponseWriter, cudReq model.CUDRequest, successStatus int) {
-	responseChan := make(chan interface{})
-	cudReq.ResponseChannel = responseChan
-	h.us.QueueCUDRequest(cudReq)
-
-	select {
-	case response := <-responseChan:
-		if err, ok := response.(error); ok {
-			switch {
-			case errors.Is(err, errs.ErrUserNotFound):
-				http.Error(w, "User Not Found", http.StatusNotFound)
-			case errors.Is(err, errs.ErrDuplicateUser):
-				http.Error(w, "User Already Exists", http.StatusBadRequest)
-			default:
-				log.Println("Unhandled error: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			}
-			return
-		}
-		w.WriteHeader(successStatus)
-		writeJSON(w, response)
-	case <-ctx.Done():
-		http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+// commandContext is the request context with the caller's principal, for the
+// command middleware
+func commandContext(r *http.Request) context.Context {
+	return command.WithPrincipal(r.Context(), ws.PrincipalOf(r))
+}
+
+// writeResult writes a command's result with successStatus, or its error.
+func writeResult(w http.ResponseWriter, successStatus int, result interface{}, err error) {
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	writeJSONStatus(w, successStatus, result)
+}
+
+// writeCommandError maps a command's error onto a status. A busy or stopping
+// service is worth retrying, so it gets a 503 with Retry-After.
+func writeCommandError(w http.ResponseWriter, err error) {
+	status, message := commandStatus(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+	http.Error(w, message, status)
+}
+
+// commandStatus is the status and message for a command's error.
+func commandStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, errs.ErrUserNotFound):
+		return http.StatusNotFound, "User Not Found"
+	case errors.Is(err, errs.ErrDuplicateUser):
+		return http.StatusBadRequest, "User Already Exists"
+	case errors.Is(err, errs.ErrInvalidInput):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, errs.ErrForbidden):
+		return http.StatusForbidden, "Forbidden"
+	case errors.Is(err, errs.ErrQueueFull), errors.Is(err, errs.ErrServiceClosed):
+		return http.StatusServiceUnavailable, "Service Unavailable"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "Request timed out"
+	default:
+		log.Printf("Unhandled error: %v", err)
+		return http.StatusInternalServerError, "Internal Server Error"
 	}
 }
 
@@ -68,18 +102,13 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	if !decodeJSON(w, r, &req) {
 		return
 	}
-	cudReq := model.CUDRequest{
-		Type:      "create_user",
-		CreateReq: req,
-	}
-	h.handleRequest(r.Context(), w, cudReq, http.StatusCreated)
+	user, err := command.Dispatch(commandContext(r), h.bus, model.CreateUser{Req: req})
+	writeResult(w, http.StatusCreated, user, err)
 }
 
 func (h *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
-	cudReq := model.CUDRequest{
-		Type: "get_users",
-	}
-	h.handleRequest(r.Context(), w, cudReq, http.StatusOK)
+	users, err := command.Dispatch(commandContext(r), h.bus, model.ListUsers{})
+	writeResult(w, http.StatusOK, users, err)
 }
 
 func (h *UserHandler) GetUserById(w http.ResponseWriter, r *http.Request) {
@@ -87,11 +116,8 @@ func (h *UserHandler) GetUserById(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
-	cudReq := model.CUDRequest{
-		Type:   "get_user",
-		UserID: userID,
-	}
-	h.handleRequest(r.Context(), w, cudReq, http.StatusOK)
+	user, err := command.Dispatch(commandContext(r), h.bus, model.GetUser{UserID: userID})
+	writeResult(w, http.StatusOK, user, err)
 }
 
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
@@ -99,11 +125,8 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
-	cudReq := model.CUDRequest{
-		Type:   "delete_user",
-		UserID: userID,
-	}
-	h.handleRequest(r.Context(), w, cudReq, http.StatusOK)
+	user, err := command.Dispatch(commandContext(r), h.bus, model.DeleteUser{UserID: userID})
+	writeResult(w, http.StatusOK, user, err)
 }
 
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
@@ -115,17 +138,46 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	if !decodeJSON(w, r, &req) {
 		return
 	}
-	cudReq := model.CUDRequest{
-		Type: "update_user",
-		UpdateReq: struct {
-			UserID int64
-			Req    model.UpdateUserRequest
-		}{
-			UserID: userID,
-			Req:    req,
-		},
-	}
-	h.handleRequest(r.Context(), w, cudReq, http.StatusOK)
+	user, err := command.Dispatch(commandContext(r), h.bus, model.UpdateUser{UserID: userID, Req: req})
+	writeResult(w, http.StatusOK, user, err)
+}
+
+// BatchUsers applies a batch of creates, updates and deletes. Best-effort
+// batches always get a 200 with each operation's outcome; a failed atomic
+// batch gets the status of the operation that failed.
+func (h *UserHandler) BatchUsers(w http.ResponseWriter, r *http.Request) {
+	limit := int64(h.maxBatchSize) * maxBatchOpBytes
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	var req model.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("batch body is larger than %d bytes", limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Operations) > h.maxBatchSize {
+		http.Error(w, fmt.Sprintf("batch has %d operations, at most %d are allowed", len(req.Operations), h.maxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = model.BatchAtomic
+	}
+	result, err := command.Dispatch(commandContext(r), h.bus, model.BatchUsers{Mode: req.Mode, Operations: req.Operations})
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if err := result.FirstError(); err != nil && result.Mode == model.BatchAtomic {
+		status, _ = commandStatus(err)
+		if status == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+	}
+	writeJSONStatus(w, status, result)
 }
 
 // Helper to decode JSON with error handling
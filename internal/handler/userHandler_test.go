@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	chi "github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/command"
+	"UserManagement/internal/model"
+)
+
+func TestBatchBodyIsBounded(t *testing.T) {
+	bus := command.NewBus()
+	command.Register(bus, func(ctx context.Context, cmd model.BatchUsers) (model.BatchResult, error) {
+		return model.BatchResult{Mode: cmd.Mode, Succeeded: len(cmd.Operations)}, nil
+	})
+	h := NewUserHandler(bus, 2)
+	r := chi.NewRouter()
+	r.Post("/users:batch", h.BatchUsers)
+
+	op := `{"op": "delete", "user_id": 1}`
+	rec := do(r, http.MethodPost, "/users:batch", `{"operations": [`+op+`,`+op+`]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = do(r, http.MethodPost, "/users:batch", `{"operations": [`+op+`,`+op+`,`+op+`]}`)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	// Far too many operations to decode, let alone run
+	huge := `{"operations": [` + strings.Repeat(op+",", 10000) + op + `]}`
+	rec = do(r, http.MethodPost, "/users:batch", huge)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	require.Contains(t, rec.Body.String(), "larger than")
+}
@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	chi "github.com/go-chi/chi/v5"
+
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+	"UserManagement/internal/util"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, req model.CreateWebhookRequest) (model.Webhook, error)
+	GetWebhook(ctx context.Context, webhookID int64) (model.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]model.Webhook, error)
+	UpdateWebhook(ctx context.Context, webhookID int64, req model.UpdateWebhookRequest) (model.Webhook, error)
+	DeleteWebhook(ctx context.Context, webhookID int64) error
+	GetDelivery(ctx context.Context, deliveryID int64) (model.WebhookDelivery, error)
+	ListDeliveries(ctx context.Context, webhookID int64, status string, limit int) ([]model.WebhookDelivery, error)
+	Redeliver(ctx context.Context, deliveryID int64) (model.WebhookDelivery, error)
+}
+
+// WebhookDispatcher checks where webhooks may point, and wakes the delivery
+// worker after a redelivery is queued
+type WebhookDispatcher interface {
+	CheckURL(raw string) error
+	Notify()
+}
+
+type WebhookHandler struct {
+	store      WebhookStore
+	dispatcher WebhookDispatcher
+}
+
+func NewWebhookHandler(store WebhookStore, dispatcher WebhookDispatcher) *WebhookHandler {
+	return &WebhookHandler{store: store, dispatcher: dispatcher}
+}
+
+// CreateWebhook registers an endpoint. Without a secret one is generated; the
+// secret is only ever returned here.
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req model.CreateWebhookRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := h.dispatcher.CheckURL(req.URL); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			h.internalError(w, "generate webhook secret", err)
+			return
+		}
+		req.Secret = secret
+	}
+	webhook, err := h.store.CreateWebhook(r.Context(), req)
+	if err != nil {
+		h.internalError(w, "create webhook", err)
+		return
+	}
+	writeJSONStatus(w, http.StatusCreated, webhook)
+}
+
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.store.ListWebhooks(r.Context())
+	if err != nil {
+		h.internalError(w, "list webhooks", err)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	writeJSON(w, webhooks)
+}
+
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	webhook, err := h.store.GetWebhook(r.Context(), id)
+	if err != nil {
+		h.storeError(w, "get webhook", err)
+		return
+	}
+	webhook.Secret = ""
+	writeJSON(w, webhook)
+}
+
+// UpdateWebhook changes the fields given. Setting active to true re-enables a
+// webhook that was disabled after repeated failures.
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var req model.UpdateWebhookRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.URL != nil {
+		if err := h.dispatcher.CheckURL(*req.URL); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if req.Secret != nil && *req.Secret == "" {
+		writeError(w, http.StatusBadRequest, errors.New("secret must not be empty"))
+		return
+	}
+	webhook, err := h.store.UpdateWebhook(r.Context(), id, req)
+	if err != nil {
+		h.storeError(w, "update webhook", err)
+		return
+	}
+	webhook.Secret = ""
+	writeJSON(w, webhook)
+}
+
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteWebhook(r.Context(), id); err != nil {
+		h.storeError(w, "delete webhook", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries returns the delivery log of a webhook, newest first,
+// optionally filtered by ?status=.
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", model.DeliveryPending, model.DeliverySucceeded, model.DeliveryFailed:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid status %q", status))
+		return
+	}
+	limit := defaultDeliveryLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxDeliveryLimit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxDeliveryLimit))
+			return
+		}
+		limit = n
+	}
+	if _, err := h.store.GetWebhook(r.Context(), id); err != nil {
+		h.storeError(w, "get webhook", err)
+		return
+	}
+	deliveries, err := h.store.ListDeliveries(r.Context(), id, status, limit)
+	if err != nil {
+		h.internalError(w, "list webhook deliveries", err)
+		return
+	}
+	writeJSON(w, deliveries)
+}
+
+// Redeliver queues a delivery again with a fresh set of attempts.
+func (h *WebhookHandler) Redeliver(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseIDParam(w, r, "id")
+	if !ok {
+		return
+	}
+	deliveryID, ok := parseIDParam(w, r, "deliveryID")
+	if !ok {
+		return
+	}
+	delivery, err := h.store.GetDelivery(r.Context(), deliveryID)
+	if err == nil && delivery.WebhookID != id {
+		err = errs.ErrDeliveryNotFound
+	}
+	if err != nil {
+		h.storeError(w, "get webhook delivery", err)
+		return
+	}
+	delivery, err = h.store.Redeliver(r.Context(), deliveryID)
+	if err != nil {
+		h.storeError(w, "redeliver webhook delivery", err)
+		return
+	}
+	h.dispatcher.Notify()
+	writeJSONStatus(w, http.StatusAccepted, delivery)
+}
+
+func (h *WebhookHandler) storeError(w http.ResponseWriter, action string, err error) {
+	if errors.Is(err, errs.ErrWebhookNotFound) || errors.Is(err, errs.ErrDeliveryNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	h.internalError(w, action, err)
+}
+
+func (h *WebhookHandler) internalError(w http.ResponseWriter, action string, err error) {
+	log.Printf("Failed to %s: %v", action, err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+// writeJSONStatus is writeJSON with a status other than 200
+func writeJSONStatus(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Println("Failed to encode response:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	util.WriteJSONResponse(w, status, util.APIResponse{
+		Status:  "error",
+		Message: err.Error(),
+	})
+}
+
+func parseIDParam(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, name), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s", name))
+		return 0, false
+	}
+	return id, true
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	chi "github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+	"UserManagement/internal/webhook"
+)
+
+// memWebhooks is a WebhookStore in memory
+type memWebhooks struct {
+	mu         sync.Mutex
+	webhooks   map[int64]model.Webhook
+	deliveries map[int64]model.WebhookDelivery
+	nextID     int64
+}
+
+func newMemWebhooks() *memWebhooks {
+	return &memWebhooks{webhooks: make(map[int64]model.Webhook), deliveries: make(map[int64]model.WebhookDelivery)}
+}
+
+func (s *memWebhooks) CreateWebhook(ctx context.Context, req model.CreateWebhookRequest) (model.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	w := model.Webhook{ID: s.nextID, URL: req.URL, Secret: req.Secret, EventTypes: req.EventTypes, Active: true}
+	s.webhooks[w.ID] = w
+	return w, nil
+}
+
+func (s *memWebhooks) GetWebhook(ctx context.Context, webhookID int64) (model.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.webhooks[webhookID]
+	if !ok {
+		return model.Webhook{}, errs.ErrWebhookNotFound
+	}
+	return w, nil
+}
+
+func (s *memWebhooks) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []model.Webhook
+	for _, w := range s.webhooks {
+		out = append(out, w)
+	}
+	return out, nil
+}
+
+func (s *memWebhooks) UpdateWebhook(ctx context.Context, webhookID int64, req model.UpdateWebhookRequest) (model.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.webhooks[webhookID]
+	if !ok {
+		return model.Webhook{}, errs.ErrWebhookNotFound
+	}
+	if req.URL != nil {
+		w.URL = *req.URL
+	}
+	s.webhooks[webhookID] = w
+	return w, nil
+}
+
+func (s *memWebhooks) DeleteWebhook(ctx context.Context, webhookID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.webhooks[webhookID]; !ok {
+		return errs.ErrWebhookNotFound
+	}
+	delete(s.webhooks, webhookID)
+	return nil
+}
+
+func (s *memWebhooks) GetDelivery(ctx context.Context, deliveryID int64) (model.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[deliveryID]
+	if !ok {
+		return model.WebhookDelivery{}, errs.ErrDeliveryNotFound
+	}
+	return d, nil
+}
+
+func (s *memWebhooks) ListDeliveries(ctx context.Context, webhookID int64, status string, limit int) ([]model.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (s *memWebhooks) Redeliver(ctx context.Context, deliveryID int64) (model.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.deliveries[deliveryID]
+	d.Status, d.Attempts = model.DeliveryPending, 0
+	s.deliveries[deliveryID] = d
+	return d, nil
+}
+
+func newTestWebhookRouter(store WebhookStore) http.Handler {
+	h := NewWebhookHandler(store, webhook.NewDispatcher(nil, webhook.Config{}))
+	r := chi.NewRouter()
+	r.Post("/webhooks", h.CreateWebhook)
+	r.Get("/webhooks", h.ListWebhooks)
+	r.Patch("/webhooks/{id}", h.UpdateWebhook)
+	r.Post("/webhooks/{id}/deliveries/{deliveryID}/redeliver", h.Redeliver)
+	return r
+}
+
+func do(r http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestCreateWebhook(t *testing.T) {
+	store := newMemWebhooks()
+	r := newTestWebhookRouter(store)
+
+	rec := do(r, http.MethodPost, "/webhooks", `{"url": "https://partner.example.com/hook"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created model.Webhook
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.Len(t, created.Secret, 64, "a secret is generated and returned once")
+
+	rec = do(r, http.MethodGet, "/webhooks", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotContains(t, rec.Body.String(), created.Secret)
+}
+
+func TestWebhooksCannotTargetThisNetwork(t *testing.T) {
+	store := newMemWebhooks()
+	r := newTestWebhookRouter(store)
+
+	for _, url := range []string{
+		"http://localhost:8080/admin/connections",
+		"http://127.0.0.1:8080/users",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.5/hook",
+		"http://[::1]/hook",
+		"file:///etc/passwd",
+	} {
+		rec := do(r, http.MethodPost, "/webhooks", `{"url": "`+url+`"}`)
+		require.Equal(t, http.StatusBadRequest, rec.Code, url)
+	}
+	require.Empty(t, store.webhooks)
+
+	created, err := store.CreateWebhook(context.Background(), model.CreateWebhookRequest{URL: "https://partner.example.com/hook"})
+	require.NoError(t, err)
+	rec := do(r, http.MethodPatch, "/webhooks/1", `{"url": "http://192.168.1.1/hook"}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, created.URL, store.webhooks[1].URL)
+}
+
+func TestRedeliverChecksTheWebhook(t *testing.T) {
+	store := newMemWebhooks()
+	store.deliveries[5] = model.WebhookDelivery{ID: 5, WebhookID: 1, Status: model.DeliveryFailed, Attempts: 8}
+	r := newTestWebhookRouter(store)
+
+	rec := do(r, http.MethodPost, "/webhooks/2/deliveries/5/redeliver", "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Equal(t, model.DeliveryFailed, store.deliveries[5].Status)
+
+	rec = do(r, http.MethodPost, "/webhooks/1/deliveries/5/redeliver", "")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.Equal(t, model.DeliveryPending, store.deliveries[5].Status)
+}
@@ -0,0 +1,115 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+)
+
+// staleAfter is how long a claimed key can go without a response before it
+// is taken to be abandoned, e.g. by an instance that crashed mid-request.
+const staleAfter = time.Minute
+
+// Store persists keys; see repository.IdempotencyRepository.
+type Store interface {
+	ClaimKey(ctx context.Context, scope, key, fingerprint string, expiresAt, staleBefore time.Time) (bool, error)
+	GetKey(ctx context.Context, scope, key string) (model.IdempotencyRecord, error)
+	CompleteKey(ctx context.Context, scope, key string, response model.StoredResponse) error
+	ReleaseKey(ctx context.Context, scope, key string) error
+	DeleteExpiredKeys(ctx context.Context) (int64, error)
+}
+
+// Keys tracks idempotency keys and the responses they produced. Keys are
+// scoped, normally to the caller, so two callers can't collide on a key.
+type Keys struct {
+	store Store
+	ttl   time.Duration
+}
+
+// NewKeys creates Keys that remember responses for ttl (24h if zero).
+func NewKeys(store Store, ttl time.Duration) *Keys {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &Keys{store: store, ttl: ttl}
+}
+
+// Begin claims key for a request with the given fingerprint. It returns the
+// stored response when the request has already completed, nil when the
+// caller now holds the key and should run the request, errs.ErrKeyReused when
+// the key was used for a different request, and errs.ErrKeyInProgress while
+// the first request is still running.
+func (k *Keys) Begin(ctx context.Context, scope, key, fingerprint string) (*model.StoredResponse, error) {
+	// The columns have no time zone; like the other timestamps they hold UTC
+	now := time.Now().UTC()
+	claimed, err := k.store.ClaimKey(ctx, scope, key, fingerprint, now.Add(k.ttl), now.Add(-staleAfter))
+	if err != nil {
+		return nil, err
+	}
+	if claimed {
+		return nil, nil
+	}
+	record, err := k.store.GetKey(ctx, scope, key)
+	if errors.Is(err, errs.ErrKeyNotFound) {
+		// Released since the claim; the client can try again
+		return nil, errs.ErrKeyInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.Fingerprint != fingerprint {
+		return nil, errs.ErrKeyReused
+	}
+	if !record.Completed {
+		return nil, errs.ErrKeyInProgress
+	}
+	return &record.Response, nil
+}
+
+// Finish stores the response to replay for key.
+func (k *Keys) Finish(ctx context.Context, scope, key string, response model.StoredResponse) error {
+	return k.store.CompleteKey(ctx, scope, key, response)
+}
+
+// Abandon releases key without a response, so the request can be retried.
+func (k *Keys) Abandon(ctx context.Context, scope, key string) error {
+	return k.store.ReleaseKey(ctx, scope, key)
+}
+
+// Run deletes expired keys every interval until ctx is cancelled.
+func (k *Keys) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := k.store.DeleteExpiredKeys(ctx); err != nil {
+				log.Printf("idempotency: deleting expired keys: %v", err)
+			} else if n > 0 {
+				log.Printf("idempotency: deleted %d expired keys", n)
+			}
+		}
+	}
+}
+
+// Fingerprint hashes the parts that identify a request. Each part is length
+// prefixed so that ("ab", "c") and ("a", "bc") differ.
+func Fingerprint(parts ...[]byte) string {
+	h := sha256.New()
+	var size [8]byte
+	for _, part := range parts {
+		binary.BigEndian.PutUint64(size[:], uint64(len(part)))
+		h.Write(size[:])
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
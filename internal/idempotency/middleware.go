@@ -0,0 +1,150 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+	"UserManagement/internal/util"
+)
+
+// Request and response headers
+const (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+const (
+	// MaxKeyLength bounds the keys clients may send
+	MaxKeyLength = 255
+	// maxBodySize bounds the request bodies read for fingerprinting
+	maxBodySize = 1 << 20
+)
+
+// Middleware makes POST and PATCH requests carrying an Idempotency-Key safe to
+// retry: the first response is stored and replayed for repeats of the same
+// request under the same key. Keys are scoped by scopeOf, normally the
+// authenticated caller. Callers without a scope are anonymous; their keys are
+// ignored, since one caller could otherwise read another's response. Server
+// errors aren't stored, so those requests can be retried.
+func Middleware(keys *Keys, scopeOf func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			scope := scopeOf(r)
+			if key == "" || scope == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > MaxKeyLength {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("%s must be at most %d characters", Header, MaxKeyLength))
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			if len(body) > maxBodySize {
+				writeError(w, http.StatusRequestEntityTooLarge, errors.New("request body too large"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			fingerprint := Fingerprint([]byte(r.Method), []byte(r.URL.RequestURI()), body)
+			stored, err := keys.Begin(r.Context(), scope, key, fingerprint)
+			switch {
+			case errors.Is(err, errs.ErrKeyReused):
+				writeError(w, http.StatusUnprocessableEntity, err)
+				return
+			case errors.Is(err, errs.ErrKeyInProgress):
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusConflict, err)
+				return
+			case err != nil:
+				log.Printf("idempotency: claiming key: %v", err)
+				writeError(w, http.StatusInternalServerError, errs.ErrInternal)
+				return
+			case stored != nil:
+				replay(w, *stored)
+				return
+			}
+
+			rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+			// The key is released if the handler panics
+			completed := false
+			defer func() {
+				if !completed {
+					release(r.Context(), keys, scope, key)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+			completed = true
+
+			if rec.status >= http.StatusInternalServerError {
+				release(r.Context(), keys, scope, key)
+				return
+			}
+			// The response is stored even if the client has gone
+			err = keys.Finish(context.WithoutCancel(r.Context()), scope, key, model.StoredResponse{
+				Status:      rec.status,
+				ContentType: rec.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			})
+			if err != nil {
+				log.Printf("idempotency: storing response: %v", err)
+			}
+		})
+	}
+}
+
+func release(ctx context.Context, keys *Keys, scope, key string) {
+	if err := keys.Abandon(context.WithoutCancel(ctx), scope, key); err != nil {
+		log.Printf("idempotency: releasing key: %v", err)
+	}
+}
+
+func replay(w http.ResponseWriter, stored model.StoredResponse) {
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(stored.Status)
+	_, _ = w.Write(stored.Body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	util.WriteJSONResponse(w, status, util.APIResponse{
+		Status:  "error",
+		Message: err.Error(),
+	})
+}
+
+// recorder passes a response through while keeping a copy of it
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
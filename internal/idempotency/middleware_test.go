@@ -0,0 +1,192 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+)
+
+// fakeStore keeps keys in memory, with the same claim rules as Postgres
+type fakeStore struct {
+	mu   sync.Mutex
+	keys map[string]model.IdempotencyRecord
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{keys: make(map[string]model.IdempotencyRecord)}
+}
+
+func (s *fakeStore) ClaimKey(ctx context.Context, scope, key, fingerprint string, expiresAt, staleBefore time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.keys[scope+"|"+key]
+	if ok && existing.ExpiresAt.After(time.Now()) && (existing.Completed || existing.CreatedAt.After(staleBefore)) {
+		return false, nil
+	}
+	s.keys[scope+"|"+key] = model.IdempotencyRecord{
+		Scope:       scope,
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   expiresAt,
+	}
+	return true, nil
+}
+
+func (s *fakeStore) GetKey(ctx context.Context, scope, key string) (model.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.keys[scope+"|"+key]
+	if !ok {
+		return model.IdempotencyRecord{}, errs.ErrKeyNotFound
+	}
+	return record, nil
+}
+
+func (s *fakeStore) CompleteKey(ctx context.Context, scope, key string, response model.StoredResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.keys[scope+"|"+key]
+	record.Completed = true
+	record.Response = response
+	s.keys[scope+"|"+key] = record
+	return nil
+}
+
+func (s *fakeStore) ReleaseKey(ctx context.Context, scope, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, scope+"|"+key)
+	return nil
+}
+
+func (s *fakeStore) DeleteExpiredKeys(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+// newTestServer counts the requests that reach the handler, which answers
+// with status after release is closed (if set).
+func newTestServer(t *testing.T, status int, release chan struct{}) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if release != nil {
+			<-release
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = fmt.Fprintf(w, `{"call":%d,"echo":%s}`, n, body)
+	})
+	keys := NewKeys(newFakeStore(), time.Hour)
+	server := httptest.NewServer(Middleware(keys, func(r *http.Request) string {
+		return r.Header.Get("X-Forwarded-User")
+	})(handler))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func post(t *testing.T, server *httptest.Server, user, key, body string) (*http.Response, string) {
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/users", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("X-Forwarded-User", user)
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(data)
+}
+
+func TestReplay(t *testing.T) {
+	server, calls := newTestServer(t, http.StatusCreated, nil)
+
+	first, firstBody := post(t, server, "alice", "k1", `{"n":1}`)
+	require.Equal(t, http.StatusCreated, first.StatusCode)
+	require.Empty(t, first.Header.Get(ReplayedHeader))
+
+	again, againBody := post(t, server, "alice", "k1", `{"n":1}`)
+	require.Equal(t, http.StatusCreated, again.StatusCode)
+	require.Equal(t, "true", again.Header.Get(ReplayedHeader))
+	require.Equal(t, "application/json", again.Header.Get("Content-Type"))
+	require.Equal(t, firstBody, againBody)
+	require.EqualValues(t, 1, calls.Load())
+
+	// Keys belong to the caller, and requests without one aren't tracked
+	post(t, server, "bob", "k1", `{"n":1}`)
+	post(t, server, "alice", "", `{"n":1}`)
+	post(t, server, "alice", "", `{"n":1}`)
+	require.EqualValues(t, 4, calls.Load())
+
+	// Nor are anonymous callers' keys
+	post(t, server, "", "k1", `{"n":1}`)
+	anonymous, _ := post(t, server, "", "k1", `{"n":1}`)
+	require.Empty(t, anonymous.Header.Get(ReplayedHeader))
+	require.EqualValues(t, 6, calls.Load())
+}
+
+func TestKeyReused(t *testing.T) {
+	server, calls := newTestServer(t, http.StatusCreated, nil)
+
+	post(t, server, "alice", "k1", `{"n":1}`)
+	resp, _ := post(t, server, "alice", "k1", `{"n":2}`)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	require.EqualValues(t, 1, calls.Load())
+}
+
+func TestKeyInProgress(t *testing.T) {
+	release := make(chan struct{})
+	server, calls := newTestServer(t, http.StatusCreated, release)
+
+	done := make(chan int, 1)
+	go func() {
+		resp, _ := post(t, server, "alice", "k1", `{"n":1}`)
+		done <- resp.StatusCode
+	}()
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+
+	resp, _ := post(t, server, "alice", "k1", `{"n":1}`)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	close(release)
+	require.Equal(t, http.StatusCreated, <-done)
+}
+
+func TestServerErrorsAreNotStored(t *testing.T) {
+	server, calls := newTestServer(t, http.StatusServiceUnavailable, nil)
+
+	post(t, server, "alice", "k1", `{"n":1}`)
+	resp, _ := post(t, server, "alice", "k1", `{"n":1}`)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Empty(t, resp.Header.Get(ReplayedHeader))
+	require.EqualValues(t, 2, calls.Load())
+}
+
+func TestFingerprint(t *testing.T) {
+	require.Equal(t, Fingerprint([]byte("ab"), []byte("c")), Fingerprint([]byte("ab"), []byte("c")))
+	require.NotEqual(t, Fingerprint([]byte("ab"), []byte("c")), Fingerprint([]byte("a"), []byte("bc")))
+}
+
+func TestKeysAreStoredInUTC(t *testing.T) {
+	store := newFakeStore()
+	stored, err := NewKeys(store, time.Hour).Begin(context.Background(), "alice", "k1", "f")
+	require.NoError(t, err)
+	require.Nil(t, stored)
+	require.Equal(t, time.UTC, store.keys["alice|k1"].ExpiresAt.Location())
+}
@@ -0,0 +1,384 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"UserManagement/internal/command"
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+)
+
+// Notifier is told about a job's progress, e.g. to push it to the WebSocket
+// clients of the principal that started it.
+type Notifier interface {
+	NotifyImport(principal string, job model.ImportJob)
+}
+
+// Config tunes imports; zero values fall back to defaults.
+type Config struct {
+	// Dir holds uploads and reports; the system temp dir by default
+	Dir string
+	// MaxSize is the largest upload accepted, in bytes
+	MaxSize int64
+	// Retention is how long finished jobs and their reports are kept
+	Retention time.Duration
+	// ProgressEvery is how many rows go by between progress notifications
+	ProgressEvery int
+}
+
+func (c Config) withDefaults() Config {
+	if c.Dir == "" {
+		c.Dir = os.TempDir()
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = 100 << 20
+	}
+	if c.Retention <= 0 {
+		c.Retention = 24 * time.Hour
+	}
+	if c.ProgressEvery <= 0 {
+		c.ProgressEvery = 500
+	}
+	return c
+}
+
+// Jobs runs user imports in the background. Uploads are spooled to disk and
+// read one row at a time, and each row's outcome is appended to a report
+// file, so neither the upload nor the report is held in memory. Jobs are
+// kept by the instance that runs them.
+type Jobs struct {
+	ctx      context.Context
+	bus      *command.Bus
+	v        Validator
+	notifier Notifier
+	config   Config
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	wg   sync.WaitGroup
+}
+
+type job struct {
+	principal string
+	token     string
+	mapping   Mapping
+	input     string
+	report    string
+	cancel    context.CancelFunc
+
+	mu   sync.Mutex
+	info model.ImportJob
+}
+
+func (j *job) snapshot() model.ImportJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info
+}
+
+// NewJobs creates the job runner. Jobs still running when ctx is cancelled
+// are cancelled with it.
+func NewJobs(ctx context.Context, bus *command.Bus, v Validator, notifier Notifier, config Config) *Jobs {
+	return &Jobs{
+		ctx:      ctx,
+		bus:      bus,
+		v:        v,
+		notifier: notifier,
+		config:   config.withDefaults(),
+		jobs:     make(map[string]*job),
+	}
+}
+
+// Start spools body to disk and starts importing it for principal. The job
+// returned carries the token that opens it to callers who aren't its
+// principal. It fails with errs.ErrImportTooLarge when body is over the
+// configured size.
+func (js *Jobs) Start(principal, format string, dryRun bool, mapping Mapping, body io.Reader) (model.ImportJob, error) {
+	if format != FormatCSV && format != FormatNDJSON {
+		return model.ImportJob{}, fmt.Errorf("%w: unknown format %q", errs.ErrInvalidInput, format)
+	}
+	if err := mapping.Validate(); err != nil {
+		return model.ImportJob{}, fmt.Errorf("%w: %v", errs.ErrInvalidInput, err)
+	}
+	input, err := js.spool(body)
+	if err != nil {
+		return model.ImportJob{}, err
+	}
+	report, err := os.CreateTemp(js.config.Dir, "import-*.report")
+	if err != nil {
+		_ = os.Remove(input)
+		return model.ImportJob{}, err
+	}
+	_ = report.Close()
+
+	ctx, cancel := context.WithCancel(js.ctx)
+	j := &job{
+		principal: principal,
+		token:     randomHex(16),
+		mapping:   mapping,
+		input:     input,
+		report:    report.Name(),
+		cancel:    cancel,
+		info: model.ImportJob{
+			ID:        randomHex(8),
+			State:     model.ImportQueued,
+			Format:    format,
+			DryRun:    dryRun,
+			CreatedAt: time.Now(),
+		},
+	}
+	js.mu.Lock()
+	js.jobs[j.info.ID] = j
+	js.mu.Unlock()
+
+	js.wg.Add(1)
+	go func() {
+		defer js.wg.Done()
+		defer cancel()
+		js.run(ctx, j)
+	}()
+	info := j.snapshot()
+	info.Token = j.token
+	return info, nil
+}
+
+func (js *Jobs) spool(body io.Reader) (string, error) {
+	f, err := os.CreateTemp(js.config.Dir, "import-*.upload")
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(f, io.LimitReader(body, js.config.MaxSize+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > js.config.MaxSize {
+		err = errs.ErrImportTooLarge
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Get returns a job open to principal or token; see find.
+func (js *Jobs) Get(principal, token, id string) (model.ImportJob, error) {
+	j, err := js.find(principal, token, id)
+	if err != nil {
+		return model.ImportJob{}, err
+	}
+	return j.snapshot(), nil
+}
+
+// Report writes the job's report so far as NDJSON, keeping only rows with
+// the given result when it is set.
+func (js *Jobs) Report(principal, token, id, result string, w io.Writer) error {
+	j, err := js.find(principal, token, id)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(j.report)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A running job may be halfway through writing the last line
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if result != "" {
+			var row model.ImportRow
+			if err := json.Unmarshal(line, &row); err != nil || row.Result != result {
+				continue
+			}
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+}
+
+// Cancel stops a job; the rows already imported stay imported.
+func (js *Jobs) Cancel(principal, token, id string) (model.ImportJob, error) {
+	j, err := js.find(principal, token, id)
+	if err != nil {
+		return model.ImportJob{}, err
+	}
+	j.cancel()
+	return j.snapshot(), nil
+}
+
+// Jobs are open to the principal that started them and to anyone with their
+// token; others get not found. Callers pass no principal when theirs doesn't
+// prove who they are, such as an IP address.
+func (js *Jobs) find(principal, token, id string) (*job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.jobs[id]
+	if !ok {
+		return nil, errs.ErrImportNotFound
+	}
+	owner := principal != "" && principal == j.principal
+	if !owner && subtle.ConstantTimeCompare([]byte(token), []byte(j.token)) != 1 {
+		return nil, errs.ErrImportNotFound
+	}
+	return j, nil
+}
+
+func (js *Jobs) run(ctx context.Context, j *job) {
+	defer os.Remove(j.input)
+	started := time.Now()
+	js.update(j, func(info *model.ImportJob) {
+		info.State = model.ImportRunning
+		info.StartedAt = &started
+	})
+
+	err := js.process(ctx, j)
+	finished := time.Now()
+	js.update(j, func(info *model.ImportJob) {
+		info.FinishedAt = &finished
+		switch {
+		case errors.Is(err, context.Canceled):
+			info.State = model.ImportCancelled
+		case err != nil:
+			info.State = model.ImportFailed
+			info.Error = err.Error()
+		default:
+			info.State = model.ImportCompleted
+		}
+	})
+	info := j.snapshot()
+	log.Printf("Import %s %s: %d rows, %d created, %d updated, %d skipped, %d failed",
+		info.ID, info.State, info.Rows, info.Created, info.Updated, info.Skipped, info.Failed)
+}
+
+func (js *Jobs) process(ctx context.Context, j *job) error {
+	input, err := os.Open(j.input)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	out, err := os.OpenFile(j.report, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	report := json.NewEncoder(out)
+
+	info := j.snapshot()
+	rows, err := newRowReader(info.Format, bufio.NewReader(input), j.mapping)
+	if err != nil {
+		return err
+	}
+	u := &upserter{bus: js.bus, v: js.v, dryRun: info.DryRun}
+	ctx = command.WithPrincipal(ctx, j.principal)
+	lastNotified := time.Now()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rec, err := rows.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row, err := u.apply(ctx, rec)
+		if err != nil {
+			return err
+		}
+		if err := report.Encode(row); err != nil {
+			return err
+		}
+
+		js.count(j, row.Result)
+		if n := j.snapshot().Rows; n%js.config.ProgressEvery == 0 || time.Since(lastNotified) >= time.Second {
+			js.notify(j)
+			lastNotified = time.Now()
+		}
+	}
+}
+
+func (js *Jobs) count(j *job, result string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.info.Rows++
+	switch result {
+	case model.RowCreated:
+		j.info.Created++
+	case model.RowUpdated:
+		j.info.Updated++
+	case model.RowSkipped:
+		j.info.Skipped++
+	case model.RowFailed:
+		j.info.Failed++
+	}
+}
+
+// update changes a job's state and tells the notifier.
+func (js *Jobs) update(j *job, fn func(info *model.ImportJob)) {
+	j.mu.Lock()
+	fn(&j.info)
+	j.mu.Unlock()
+	js.notify(j)
+}
+
+func (js *Jobs) notify(j *job) {
+	if js.notifier != nil {
+		js.notifier.NotifyImport(j.principal, j.snapshot())
+	}
+}
+
+// Run removes finished jobs and their reports once they are older than the
+// retention period, until ctx is cancelled. It then waits for running jobs
+// to stop.
+func (js *Jobs) Run(ctx context.Context) {
+	defer js.wg.Wait()
+	ticker := time.NewTicker(max(js.config.Retention/24, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			js.expire(time.Now().Add(-js.config.Retention))
+		}
+	}
+}
+
+func (js *Jobs) expire(before time.Time) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	for id, j := range js.jobs {
+		info := j.snapshot()
+		if info.Done() && info.FinishedAt.Before(before) {
+			_ = os.Remove(j.report)
+			delete(js.jobs, id)
+		}
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
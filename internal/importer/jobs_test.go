@@ -0,0 +1,237 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/command"
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+	"UserManagement/internal/validator"
+)
+
+// memUsers serves the commands an import sends from memory.
+type memUsers struct {
+	mu     sync.Mutex
+	users  map[string]model.User
+	nextID int64
+	writes int
+}
+
+func newBus(users *memUsers) *command.Bus {
+	bus := command.NewBus(command.Validation())
+	command.Register(bus, func(ctx context.Context, cmd model.GetUserByEmail) (model.User, error) {
+		users.mu.Lock()
+		defer users.mu.Unlock()
+		user, ok := users.users[strings.ToLower(cmd.Email)]
+		if !ok {
+			return model.User{}, errs.ErrUserNotFound
+		}
+		return user, nil
+	})
+	command.Register(bus, func(ctx context.Context, cmd model.CreateUser) (model.User, error) {
+		users.mu.Lock()
+		defer users.mu.Unlock()
+		users.nextID++
+		users.writes++
+		user := model.User{ID: users.nextID, FirstName: cmd.Req.FirstName, LastName: cmd.Req.LastName, Email: cmd.Req.Email}
+		users.users[strings.ToLower(user.Email)] = user
+		return user, nil
+	})
+	command.Register(bus, func(ctx context.Context, cmd model.UpdateUser) (model.User, error) {
+		users.mu.Lock()
+		defer users.mu.Unlock()
+		users.writes++
+		for email, user := range users.users {
+			if user.ID != cmd.UserID {
+				continue
+			}
+			if cmd.Req.FirstName != nil {
+				user.FirstName = *cmd.Req.FirstName
+			}
+			if cmd.Req.Age != nil {
+				user.Age = cmd.Req.Age
+			}
+			users.users[email] = user
+			return user, nil
+		}
+		return model.User{}, errs.ErrUserNotFound
+	})
+	return bus
+}
+
+func newTestJobs(t *testing.T, config Config) (*Jobs, *memUsers) {
+	users := &memUsers{users: map[string]model.User{
+		"ada@example.com": {ID: 1, FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com"},
+	}, nextID: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	config.Dir = t.TempDir()
+	jobs := NewJobs(ctx, newBus(users), validator.NewValidator(), nil, config)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		jobs.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return jobs, users
+}
+
+func waitDone(t *testing.T, jobs *Jobs, principal, id string) model.ImportJob {
+	var job model.ImportJob
+	require.Eventually(t, func() bool {
+		var err error
+		job, err = jobs.Get(principal, "", id)
+		require.NoError(t, err)
+		return job.Done()
+	}, 5*time.Second, 10*time.Millisecond)
+	return job
+}
+
+func report(t *testing.T, jobs *Jobs, principal, id, result string) []model.ImportRow {
+	var buf bytes.Buffer
+	require.NoError(t, jobs.Report(principal, "", id, result, &buf))
+	var rows []model.ImportRow
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var row model.ImportRow
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &row))
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestCSVImportWithMapping(t *testing.T) {
+	jobs, users := newTestJobs(t, Config{})
+	body := "\ufeffWork Email,First Name,last_name,age\n" +
+		"ada@example.com,Augusta,Lovelace,36\n" +
+		"grace@example.com,Grace,Hopper,\n" +
+		"ada@example.com,Augusta,,36\n" +
+		"alan@example.com,Alan,Turing,forty\n" +
+		",Nobody,Here,\n"
+	mapping := Mapping{"email": "work email", "first_name": "First Name"}
+
+	job, err := jobs.Start("alice", FormatCSV, false, mapping, strings.NewReader(body))
+	require.NoError(t, err)
+	job = waitDone(t, jobs, "alice", job.ID)
+
+	require.Equal(t, model.ImportCompleted, job.State)
+	require.Equal(t, 5, job.Rows)
+	require.Equal(t, []int{1, 1, 1, 2}, []int{job.Created, job.Updated, job.Skipped, job.Failed})
+	require.Equal(t, "Augusta", users.users["ada@example.com"].FirstName)
+	require.Contains(t, users.users, "grace@example.com")
+
+	failed := report(t, jobs, "alice", job.ID, model.RowFailed)
+	require.Len(t, failed, 2)
+	require.Equal(t, 4, failed[0].Row)
+	require.Contains(t, failed[0].Reason, "age")
+	require.Equal(t, 5, failed[1].Row)
+	require.Len(t, report(t, jobs, "alice", job.ID, ""), 5)
+}
+
+func TestDryRunWritesNothing(t *testing.T) {
+	jobs, users := newTestJobs(t, Config{})
+	body := `{"email": "ada@example.com", "first_name": "Augusta", "age": 36}` + "\n" +
+		"\n" +
+		`{"email": "grace@example.com", "first_name": "Grace", "last_name": "Hopper"}` + "\n" +
+		`{"email": "bad"` + "\n"
+
+	job, err := jobs.Start("alice", FormatNDJSON, true, nil, strings.NewReader(body))
+	require.NoError(t, err)
+	job = waitDone(t, jobs, "alice", job.ID)
+
+	require.Equal(t, model.ImportCompleted, job.State)
+	require.Equal(t, []int{1, 1, 0, 1}, []int{job.Created, job.Updated, job.Skipped, job.Failed})
+	require.Zero(t, users.writes)
+	require.Equal(t, "Ada", users.users["ada@example.com"].FirstName)
+
+	failed := report(t, jobs, "alice", job.ID, model.RowFailed)
+	require.Len(t, failed, 1)
+	require.Equal(t, 4, failed[0].Row)
+}
+
+func TestDryRunMatchesRealRun(t *testing.T) {
+	body := "email,first_name,last_name,age\n" +
+		"Ada@Example.com,Augusta,,\n" +
+		"grace@example.com,Grace,Hopper,\n" +
+		"GRACE@example.com,Grace,Hopper,\n" +
+		"Grace@example.com,,,85\n"
+	results := func(dryRun bool) []string {
+		jobs, _ := newTestJobs(t, Config{})
+		job, err := jobs.Start("alice", FormatCSV, dryRun, nil, strings.NewReader(body))
+		require.NoError(t, err)
+		waitDone(t, jobs, "alice", job.ID)
+		var results []string
+		for _, row := range report(t, jobs, "alice", job.ID, "") {
+			results = append(results, row.Result)
+		}
+		return results
+	}
+
+	want := []string{model.RowUpdated, model.RowCreated, model.RowSkipped, model.RowUpdated}
+	require.Equal(t, want, results(false))
+	require.Equal(t, want, results(true))
+}
+
+func TestImportsBelongToTheirPrincipal(t *testing.T) {
+	jobs, _ := newTestJobs(t, Config{})
+	job, err := jobs.Start("alice", FormatCSV, false, nil, strings.NewReader("email\nbob@example.com\n"))
+	require.NoError(t, err)
+	waitDone(t, jobs, "alice", job.ID)
+
+	_, err = jobs.Get("mallory", "", job.ID)
+	require.ErrorIs(t, err, errs.ErrImportNotFound)
+	_, err = jobs.Cancel("mallory", "", job.ID)
+	require.ErrorIs(t, err, errs.ErrImportNotFound)
+}
+
+func TestAnonymousImportsNeedTheirToken(t *testing.T) {
+	jobs, _ := newTestJobs(t, Config{})
+	job, err := jobs.Start("ip:10.0.0.1", FormatCSV, false, nil, strings.NewReader("email\nbob@example.com\n"))
+	require.NoError(t, err)
+	require.NotEmpty(t, job.Token)
+
+	// Others behind the same address pass no principal
+	_, err = jobs.Get("", "", job.ID)
+	require.ErrorIs(t, err, errs.ErrImportNotFound)
+	_, err = jobs.Get("", "guess", job.ID)
+	require.ErrorIs(t, err, errs.ErrImportNotFound)
+
+	got, err := jobs.Get("", job.Token, job.ID)
+	require.NoError(t, err)
+	require.Equal(t, job.ID, got.ID)
+	require.Empty(t, got.Token, "the token is only handed out once")
+	_, err = jobs.Cancel("", job.Token, job.ID)
+	require.NoError(t, err)
+}
+
+func TestInvalidImportsAreRejected(t *testing.T) {
+	jobs, _ := newTestJobs(t, Config{MaxSize: 16})
+
+	_, err := jobs.Start("alice", FormatCSV, false, nil, strings.NewReader("email\nada@example.com\n"))
+	require.ErrorIs(t, err, errs.ErrImportTooLarge)
+	_, err = jobs.Start("alice", "xml", false, nil, strings.NewReader("<users/>"))
+	require.ErrorIs(t, err, errs.ErrInvalidInput)
+	_, err = jobs.Start("alice", FormatCSV, false, Mapping{"nickname": "Nick"}, strings.NewReader("email\n"))
+	require.ErrorIs(t, err, errs.ErrInvalidInput)
+}
+
+func TestCSVWithoutEmailColumnFails(t *testing.T) {
+	jobs, _ := newTestJobs(t, Config{})
+	job, err := jobs.Start("alice", FormatCSV, false, nil, strings.NewReader("name\nAda\n"))
+	require.NoError(t, err)
+	job = waitDone(t, jobs, "alice", job.ID)
+
+	require.Equal(t, model.ImportFailed, job.State)
+	require.Contains(t, job.Error, "email")
+}
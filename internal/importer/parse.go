@@ -0,0 +1,180 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Import formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// maxLineSize bounds one NDJSON line
+const maxLineSize = 1 << 20
+
+// Fields are the user fields a row can set.
+var Fields = []string{"first_name", "last_name", "email", "phone", "age", "status"}
+
+// Mapping maps user fields to the CSV column or NDJSON key they are read
+// from. Fields that aren't mapped are read from the column of the same name.
+type Mapping map[string]string
+
+// Validate rejects mappings for fields that don't exist.
+func (m Mapping) Validate() error {
+	for field := range m {
+		if !isField(field) {
+			return fmt.Errorf("unknown field %q, expected one of %s", field, strings.Join(Fields, ", "))
+		}
+	}
+	return nil
+}
+
+func (m Mapping) column(field string) string {
+	if column, ok := m[field]; ok {
+		return column
+	}
+	return field
+}
+
+func isField(name string) bool {
+	for _, field := range Fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// record is one row's values by user field. err is set for a row that
+// couldn't be parsed; the rows after it are still read.
+type record struct {
+	row    int
+	fields map[string]string
+	err    error
+}
+
+// rowReader reads one row at a time, returning io.EOF after the last.
+type rowReader interface {
+	next() (record, error)
+}
+
+func newRowReader(format string, r io.Reader, mapping Mapping) (rowReader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r, mapping)
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+		return &ndjsonReader{scanner: scanner, mapping: mapping}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+type csvReader struct {
+	r *csv.Reader
+	// columns maps user fields to their index in a row
+	columns map[string]int
+	row     int
+}
+
+// newCSVReader reads the header and finds the mapped columns in it,
+// ignoring case and surrounding spaces.
+func newCSVReader(r io.Reader, mapping Mapping) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	columns := make(map[string]int)
+	for _, field := range Fields {
+		want := strings.ToLower(mapping.column(field))
+		for i, name := range header {
+			// Spreadsheet exports often start with a byte order mark
+			if strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) == want {
+				columns[field] = i
+				break
+			}
+		}
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, fmt.Errorf("no %q column in the header", mapping.column("email"))
+	}
+	cr.ReuseRecord = true
+	return &csvReader{r: cr, columns: columns}, nil
+}
+
+func (c *csvReader) next() (record, error) {
+	values, err := c.r.Read()
+	if err == io.EOF {
+		return record{}, err
+	}
+	c.row++
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return record{row: c.row, err: parseErr.Err}, nil
+	}
+	if err != nil {
+		return record{}, err
+	}
+	fields := make(map[string]string, len(c.columns))
+	for field, i := range c.columns {
+		if i < len(values) {
+			fields[field] = strings.TrimSpace(values[i])
+		}
+	}
+	return record{row: c.row, fields: fields}, nil
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	mapping Mapping
+	line    int
+}
+
+// next reads the next non-blank line. Rows are numbered by line.
+func (n *ndjsonReader) next() (record, error) {
+	for n.scanner.Scan() {
+		n.line++
+		line := bytes.TrimSpace(n.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var values map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&values); err != nil {
+			return record{row: n.line, err: fmt.Errorf("invalid JSON: %w", err)}, nil
+		}
+		fields := make(map[string]string, len(Fields))
+		for _, field := range Fields {
+			switch v := values[n.mapping.column(field)].(type) {
+			case string:
+				fields[field] = strings.TrimSpace(v)
+			case json.Number:
+				fields[field] = v.String()
+			case nil:
+			default:
+				return record{row: n.line, err: fmt.Errorf("%s must be a string or number", field)}, nil
+			}
+		}
+		return record{row: n.line, fields: fields}, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		return record{}, err
+	}
+	return record{}, io.EOF
+}
@@ -0,0 +1,225 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"UserManagement/internal/command"
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+)
+
+// retryDelay is how long a row waits before retrying when the service is busy
+var retryDelay = time.Second
+
+// Validator checks rows before they are written, so dry runs report the
+// same failures as real imports.
+type Validator interface {
+	ValidateCreateUser(firstName, lastName, email string) error
+	ValidateUpdateUser(firstName, lastName, email *string) error
+}
+
+// upserter applies rows through the command bus: a row whose email matches
+// a user, ignoring case, updates it; any other row creates one.
+type upserter struct {
+	bus    *command.Bus
+	v      Validator
+	dryRun bool
+	// seen holds what a dry run would have written so far, by lower-case
+	// email, so a later row for the same address is reported as in a real run
+	seen map[string]model.User
+}
+
+// apply reports what happened to rec. The error is only set when the import
+// can't go on, because it was cancelled or the service is shutting down.
+func (u *upserter) apply(ctx context.Context, rec record) (model.ImportRow, error) {
+	row := model.ImportRow{Row: rec.row, Email: rec.fields["email"]}
+	if rec.err != nil {
+		return failed(row, rec.err), nil
+	}
+	if row.Email == "" {
+		return failed(row, errors.New("email is required")), nil
+	}
+	age, err := parseAge(rec.fields["age"])
+	if err != nil {
+		return failed(row, err), nil
+	}
+
+	existing, err := u.lookup(ctx, row.Email)
+	switch {
+	case errors.Is(err, errs.ErrUserNotFound):
+		return u.create(ctx, row, rec.fields, age)
+	case err != nil:
+		return rowError(row, err)
+	}
+
+	req, changed := changes(existing, rec.fields, age)
+	row.UserID = existing.ID
+	if !changed {
+		row.Result = model.RowSkipped
+		row.Reason = "unchanged"
+		return row, nil
+	}
+	if err := u.v.ValidateUpdateUser(req.FirstName, req.LastName, req.Email); err != nil {
+		return failed(row, err), nil
+	}
+	if u.dryRun {
+		u.remember(updated(existing, req))
+	} else if _, err := dispatch(ctx, u.bus, model.UpdateUser{UserID: existing.ID, Req: req}); err != nil {
+		return rowError(row, err)
+	}
+	row.Result = model.RowUpdated
+	return row, nil
+}
+
+func (u *upserter) create(ctx context.Context, row model.ImportRow, fields map[string]string, age *int32) (model.ImportRow, error) {
+	req := model.CreateUserRequest{
+		FirstName: fields["first_name"],
+		LastName:  fields["last_name"],
+		Email:     row.Email,
+		Phone:     fields["phone"],
+		Status:    fields["status"],
+	}
+	if age != nil {
+		req.Age = int(*age)
+	}
+	if err := u.v.ValidateCreateUser(req.FirstName, req.LastName, req.Email); err != nil {
+		return failed(row, err), nil
+	}
+	if u.dryRun {
+		u.remember(model.User{
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+			Email:     req.Email,
+			Phone:     nonEmpty(req.Phone),
+			Age:       age,
+			Status:    nonEmpty(req.Status),
+		})
+	} else {
+		user, err := dispatch(ctx, u.bus, model.CreateUser{Req: req})
+		if err != nil {
+			return rowError(row, err)
+		}
+		row.UserID = user.ID
+	}
+	row.Result = model.RowCreated
+	return row, nil
+}
+
+// lookup finds the user with email, ignoring case. A dry run looks at what
+// its earlier rows would have written first.
+func (u *upserter) lookup(ctx context.Context, email string) (model.User, error) {
+	if user, ok := u.seen[strings.ToLower(email)]; ok {
+		return user, nil
+	}
+	return dispatch(ctx, u.bus, model.GetUserByEmail{Email: email})
+}
+
+func (u *upserter) remember(user model.User) {
+	if u.seen == nil {
+		u.seen = make(map[string]model.User)
+	}
+	u.seen[strings.ToLower(user.Email)] = user
+}
+
+// updated is user after req is applied.
+func updated(user model.User, req model.UpdateUserRequest) model.User {
+	if req.FirstName != nil {
+		user.FirstName = *req.FirstName
+	}
+	if req.LastName != nil {
+		user.LastName = *req.LastName
+	}
+	if req.Phone != nil {
+		user.Phone = req.Phone
+	}
+	if req.Age != nil {
+		user.Age = req.Age
+	}
+	if req.Status != nil {
+		user.Status = req.Status
+	}
+	return user
+}
+
+// changes is the update that brings user in line with a row. Empty cells
+// leave a field as it is.
+func changes(user model.User, fields map[string]string, age *int32) (model.UpdateUserRequest, bool) {
+	var req model.UpdateUserRequest
+	changed := false
+	set := func(dst **string, value string, current string) {
+		if value != "" && value != current {
+			v := value
+			*dst = &v
+			changed = true
+		}
+	}
+	set(&req.FirstName, fields["first_name"], user.FirstName)
+	set(&req.LastName, fields["last_name"], user.LastName)
+	set(&req.Phone, fields["phone"], deref(user.Phone))
+	set(&req.Status, fields["status"], deref(user.Status))
+	if age != nil && (user.Age == nil || *user.Age != *age) {
+		req.Age = age
+		changed = true
+	}
+	return req, changed
+}
+
+func parseAge(value string) (*int32, error) {
+	if value == "" {
+		return nil, nil
+	}
+	age, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || age <= 0 {
+		return nil, fmt.Errorf("age %q is not a positive whole number", value)
+	}
+	a := int32(age)
+	return &a, nil
+}
+
+func nonEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func failed(row model.ImportRow, err error) model.ImportRow {
+	row.Result = model.RowFailed
+	row.Reason = err.Error()
+	return row
+}
+
+// rowError fails the row, or the import when the error isn't the row's own.
+func rowError(row model.ImportRow, err error) (model.ImportRow, error) {
+	if errors.Is(err, errs.ErrServiceClosed) || errors.Is(err, context.Canceled) {
+		return row, err
+	}
+	return failed(row, err), nil
+}
+
+// dispatch sends cmd, retrying while the service is too busy to take it.
+func dispatch[R any](ctx context.Context, bus *command.Bus, cmd command.Command[R]) (R, error) {
+	for {
+		result, err := command.Dispatch(ctx, bus, cmd)
+		if !errors.Is(err, errs.ErrQueueFull) {
+			return result, err
+		}
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(retryDelay):
+		}
+	}
+}
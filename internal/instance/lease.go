@@ -0,0 +1,108 @@
+// Package instance tells offline tools whether any server is up. Every
+// server holds a lease in Postgres and refreshes it on a heartbeat; a lease is
+// released when the server shuts down cleanly.
+package instance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"UserManagement/internal/model"
+)
+
+const (
+	// HeartbeatInterval is how often a server refreshes its lease
+	HeartbeatInterval = 10 * time.Second
+	// LeaseTTL is how long a lease lasts without a heartbeat
+	LeaseTTL = 3 * HeartbeatInterval
+)
+
+// Store persists leases; see repository.PostgresInstanceRepository.
+type Store interface {
+	Heartbeat(ctx context.Context, id string) error
+	Release(ctx context.Context, id string) error
+	DeleteStale(ctx context.Context, ttl time.Duration) (int64, error)
+	List(ctx context.Context, ttl time.Duration) ([]model.ServerInstance, error)
+}
+
+// Lease is the lease of this server.
+type Lease struct {
+	store Store
+	id    string
+}
+
+// Acquire takes a lease for a new instance, named after the host.
+func Acquire(ctx context.Context, store Store) (*Lease, error) {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	l := &Lease{store: store, id: host + "-" + hex.EncodeToString(suffix)}
+	if err := store.Heartbeat(ctx, l.id); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Lease) ID() string {
+	return l.id
+}
+
+// Run refreshes the lease every HeartbeatInterval until ctx is cancelled. It
+// also clears leases that lapsed without being released: while this server
+// is up, offline tools refuse to run anyway.
+func (l *Lease) Run(ctx context.Context) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.store.Heartbeat(ctx, l.id); err != nil {
+				log.Printf("instance: refreshing lease %s: %v", l.id, err)
+				continue
+			}
+			if n, err := l.store.DeleteStale(ctx, LeaseTTL); err != nil {
+				log.Printf("instance: deleting lapsed leases: %v", err)
+			} else if n > 0 {
+				log.Printf("instance: deleted %d lapsed leases", n)
+			}
+		}
+	}
+}
+
+// Release gives the lease up on shutdown.
+func (l *Lease) Release(ctx context.Context) error {
+	return l.store.Release(ctx, l.id)
+}
+
+// ServerRunning reports whether any server holds a live lease. A lease that
+// lapsed without being released belongs to a server that either crashed or
+// can't reach the database for now; since those can't be told apart, it is
+// an error until the lease is deleted by hand.
+func ServerRunning(ctx context.Context, store Store) (bool, error) {
+	instances, err := store.List(ctx, LeaseTTL)
+	if err != nil {
+		return false, err
+	}
+	var lapsed []string
+	for _, i := range instances {
+		if i.Live {
+			return true, nil
+		}
+		lapsed = append(lapsed, fmt.Sprintf("%s (last seen %s)", i.ID, i.HeartbeatAt.Format(time.RFC3339)))
+	}
+	if len(lapsed) > 0 {
+		return false, fmt.Errorf("server %s stopped without releasing its lease; once it is known to be down, delete it from server_instances",
+			strings.Join(lapsed, ", "))
+	}
+	return false, nil
+}
@@ -0,0 +1,107 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/model"
+)
+
+// fakeStore keeps leases in memory, with the age of each heartbeat set by the
+// test rather than the clock.
+type fakeStore struct {
+	mu     sync.Mutex
+	leases map[string]time.Duration
+	err    error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{leases: make(map[string]time.Duration)}
+}
+
+func (s *fakeStore) Heartbeat(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leases[id] = 0
+	return s.err
+}
+
+func (s *fakeStore) Release(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.leases, id)
+	return s.err
+}
+
+func (s *fakeStore) DeleteStale(ctx context.Context, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for id, age := range s.leases {
+		if age > ttl {
+			delete(s.leases, id)
+			n++
+		}
+	}
+	return n, s.err
+}
+
+func (s *fakeStore) List(ctx context.Context, ttl time.Duration) ([]model.ServerInstance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var instances []model.ServerInstance
+	for id, age := range s.leases {
+		instances = append(instances, model.ServerInstance{ID: id, HeartbeatAt: time.Now().Add(-age), Live: age <= ttl})
+	}
+	return instances, s.err
+}
+
+func TestServerRunning(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		leases  map[string]time.Duration
+		err     error
+		want    bool
+		wantErr string
+	}{
+		{name: "no leases"},
+		{name: "live lease", leases: map[string]time.Duration{"a": time.Second}, want: true},
+		{name: "live lease beside a lapsed one", leases: map[string]time.Duration{"a": time.Second, "b": time.Hour}, want: true},
+		{name: "lapsed lease", leases: map[string]time.Duration{"b": time.Hour}, wantErr: "server b (last seen"},
+		{name: "database unreachable", err: errors.New("connection refused"), wantErr: "connection refused"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.err = tc.err
+			for id, age := range tc.leases {
+				store.leases[id] = age
+			}
+			running, err := ServerRunning(context.Background(), store)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, running)
+		})
+	}
+}
+
+func TestLeaseIsReleased(t *testing.T) {
+	store := newFakeStore()
+	lease, err := Acquire(context.Background(), store)
+	require.NoError(t, err)
+	running, err := ServerRunning(context.Background(), store)
+	require.NoError(t, err)
+	require.True(t, running)
+
+	require.NoError(t, lease.Release(context.Background()))
+	running, err = ServerRunning(context.Background(), store)
+	require.NoError(t, err)
+	require.False(t, running)
+}
@@ -2,39 +2,101 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"io"
 	"log"
+	"sync"
 
 	kafka "github.com/segmentio/kafka-go"
 
+	"UserManagement/internal/model"
+	"UserManagement/internal/schema"
 	"UserManagement/internal/ws"
 )
 
-func StartConsumer(brokerAddr, topic string, manager *ws.Manager) {
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{brokerAddr},
-		Topic:   topic,
-		GroupID: "websocket-group",
-	})
+// StartConsumer broadcasts events from topic to WebSocket clients until ctx is
+// cancelled. The returned channel is closed once every partition reader has
+// closed.
+//
+// Every instance needs every event for its own clients, so there is no
+// consumer group: each partition is read directly from its end, and no
+// offsets are committed. Events published while an instance is down are not
+// pushed after it restarts; its clients have to resync anyway, since resume
+// cursors don't survive a restart. Partitions added later are picked up on
+// the next start.
+func StartConsumer(ctx context.Context, brokerAddr, topic string, manager *ws.Manager, deserializer *schema.Deserializer) (<-chan struct{}, error) {
+	conn, err := kafka.Dial("tcp", brokerAddr)
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := conn.ReadPartitions(topic)
+	_ = conn.Close()
+	if err != nil {
+		return nil, err
+	}
 
-	go func() {
-		defer func() {
-			if err := reader.Close(); err != nil {
-				log.Printf("Error closing Kafka reader: %v", err)
-			}
+	tracker := NewSequenceTracker()
+	var wg sync.WaitGroup
+	for _, part := range partitions {
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   []string{brokerAddr},
+			Topic:     topic,
+			Partition: part.ID,
+		})
+		if err := reader.SetOffset(kafka.LastOffset); err != nil {
+			_ = reader.Close()
+			return nil, err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if err := reader.Close(); err != nil {
+					log.Printf("Error closing Kafka reader: %v", err)
+				}
+			}()
+			broadcastEvents(ctx, reader, tracker, manager.Broadcast, deserializer)
 		}()
-		for {
-			m, err := reader.ReadMessage(context.Background())
-			if err != nil {
-				log.Println("Kafka consumer error:", err)
-				continue
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		log.Println("Kafka consumer stopped")
+		close(done)
+	}()
+	return done, nil
+}
+
+// broadcastEvents reads one partition and passes on every event that is newer
+// than the last one seen for its user, until ctx is cancelled.
+func broadcastEvents(ctx context.Context, reader messageReader, tracker *SequenceTracker, broadcast func(model.UserEvent), deserializer *schema.Deserializer) {
+	for {
+		m, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return
 			}
+			log.Println("Kafka consumer error:", err)
+			continue
+		}
 
-			// Log the consumed message
-			log.Printf("Consumed message: key=%s, value=%s", string(m.Key), string(m.Value))
+		// Log the consumed message
+		log.Printf("Consumed message: key=%s, partition=%d, offset=%d", string(m.Key), m.Partition, m.Offset)
 
-			// Notify all clients when a user is created
-			manager.Broadcast(string(m.Key), json.RawMessage(m.Value))
+		meta := readEventMeta(m)
+		if meta.ok && !tracker.Accept(meta.userID, meta.sequence) {
+			log.Printf("Dropping stale event: type=%s, user=%d, sequence=%d", meta.eventType, meta.userID, meta.sequence)
+			continue
 		}
-	}()
+
+		event, err := decodeEvent(m, meta, deserializer)
+		if err != nil {
+			log.Printf("Failed to decode event at offset %d: %v", m.Offset, err)
+			continue
+		}
+
+		// Notify subscribed clients about the user change
+		broadcast(event)
+	}
 }
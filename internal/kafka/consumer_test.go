@@ -0,0 +1,40 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/model"
+	"UserManagement/internal/schema"
+)
+
+func TestBroadcastEventsCommitsNothing(t *testing.T) {
+	reader := newFakeReader(
+		eventMessage(t, model.EventUserCreated, 1, 1),
+		eventMessage(t, model.EventUserUpdated, 1, 2),
+		eventMessage(t, model.EventUserUpdated, 1, 1), // stale
+	)
+	var mu sync.Mutex
+	var broadcast []model.UserEvent
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		broadcastEvents(ctx, reader, NewSequenceTracker(), func(e model.UserEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			broadcast = append(broadcast, e)
+		}, schema.NewDeserializer(nil))
+	}()
+
+	require.Eventually(t, func() bool { return len(reader.messages) == 0 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+	require.Len(t, broadcast, 2)
+	require.Equal(t, int64(2), broadcast[1].Sequence)
+	require.Empty(t, reader.committed())
+}
@@ -2,43 +2,338 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
 	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	kafka "github.com/segmentio/kafka-go"
 
+	"UserManagement/internal/model"
+	"UserManagement/internal/schema"
 	"UserManagement/internal/service"
 )
 
+// Message headers carried alongside every user event
+const (
+	HeaderEventType = "event_type"
+	HeaderSequence  = "sequence"
+)
+
+var (
+	ErrProducerClosed = errors.New("producer is closed")
+	ErrBufferFull     = errors.New("producer buffer is full")
+)
+
+// producerMetrics is published on /debug/vars, keyed by "<topic>.<counter>"
+var producerMetrics = expvar.NewMap("kafka_producer")
+
+// ProducerConfig controls batching and delivery of the async producer.
+type ProducerConfig struct {
+	Broker string
+	Topic  string
+	// SnapshotTopic, when set, receives the latest state of every user keyed
+	// by user ID, with a tombstone on delete. It should be log-compacted.
+	SnapshotTopic string
+	BatchSize     int
+	BatchTimeout  time.Duration
+	Compression   string // none, gzip, snappy, lz4 or zstd
+	RequiredAcks  string // none, one or all
+	BufferSize    int    // maximum messages accepted but not yet delivered
+
+	// OnDelivery is called from the writer's goroutines once a message has
+	// been acknowledged or has failed for good; LogDelivery when nil.
+	OnDelivery func(report DeliveryReport)
+}
+
+// DeliveryReport describes the outcome of a single message.
+type DeliveryReport struct {
+	Topic     string
+	Key       string
+	EventType string
+	Partition int
+	Offset    int64
+	Err       error
+}
+
+// ProducerStats is a point-in-time view of the producer counters.
+type ProducerStats struct {
+	InFlight  int64
+	Delivered int64
+	Failed    int64
+}
+
+// LogDelivery logs messages that could not be delivered.
+func LogDelivery(report DeliveryReport) {
+	if report.Err != nil {
+		log.Printf("failed to deliver message topic=%s key=%s type=%s: %v", report.Topic, report.Key, report.EventType, report.Err)
+	}
+}
+
+// messageWriter is the part of kafka.Writer the producer uses.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Producer publishes user events asynchronously. NotifyUserEvent returns as
+// soon as the message is buffered; batches are flushed by the writer in the
+// background and reported through OnDelivery.
 type Producer struct {
-	writer *kafka.Writer
+	writer        messageWriter
+	topic         string
+	snapshotTopic string
+	serializer    schema.Serializer
+	onDelivery    func(report DeliveryReport)
+
+	// slots bounds the number of messages held in memory
+	slots chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+
+	inFlight  atomic.Int64
+	delivered atomic.Int64
+	failed    atomic.Int64
 }
 
-func NewProducer(brokerAddr string, topic string) *Producer {
-	return &Producer{
-		writer: &kafka.Writer{
-			Addr:     kafka.TCP(brokerAddr),
-			Topic:    topic,
-			Balancer: &kafka.LeastBytes{},
-		},
+func NewProducer(cfg ProducerConfig, serializer schema.Serializer) (*Producer, error) {
+	compression, err := parseCompression(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+	acks, err := parseRequiredAcks(cfg.RequiredAcks)
+	if err != nil {
+		return nil, err
+	}
+	bufferSize := cfg.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = 10000
+	}
+	onDelivery := cfg.OnDelivery
+	if onDelivery == nil {
+		onDelivery = LogDelivery
+	}
+
+	p := &Producer{
+		topic:         cfg.Topic,
+		snapshotTopic: cfg.SnapshotTopic,
+		serializer:    serializer,
+		onDelivery:    onDelivery,
+		slots:         make(chan struct{}, bufferSize),
+	}
+	// The topic is set per message so one writer serves both topics
+	p.writer = &kafka.Writer{
+		Addr: kafka.TCP(cfg.Broker),
+		// Hashing the user ID key keeps every event for a user on one partition, in order
+		Balancer:     &kafka.Hash{},
+		BatchSize:    cfg.BatchSize,
+		BatchTimeout: cfg.BatchTimeout,
+		Compression:  compression,
+		RequiredAcks: acks,
+		Async:        true,
+		Completion:   p.complete,
 	}
+	name := cfg.Topic
+	if name == "" {
+		name = cfg.SnapshotTopic
+	}
+	producerMetrics.Set(name+".in_flight", expvar.Func(func() any {
+		return p.inFlight.Load()
+	}))
+	return p, nil
 }
 
-func (p *Producer) NotifyUserCreated(key string, value interface{}) error {
-	// Serialize the value to JSON
-	data, err := json.Marshal(value)
+func (p *Producer) NotifyUserEvent(event model.UserEvent) error {
+	// Serialize the event with the configured encoding
+	data, err := p.serializer.Serialize(event)
 	if err != nil {
 		log.Println("failed to serialize value:", err)
 		return err
 	}
-	err = p.writer.WriteMessages(context.Background(), kafka.Message{
-		Key:   []byte(key),
-		Value: data,
-	})
+	msgs := []kafka.Message{p.message(p.topic, event, data)}
+	if p.snapshotTopic != "" {
+		// A nil value is a tombstone, so compaction eventually removes deleted users
+		if event.Type == model.EventUserDeleted {
+			data = nil
+		}
+		msgs = append(msgs, p.message(p.snapshotTopic, event, data))
+	}
+	return p.enqueue(msgs...)
+}
+
+// PublishSnapshot writes the user's current state to the snapshot topic only.
+func (p *Producer) PublishSnapshot(user model.User) error {
+	if p.snapshotTopic == "" {
+		return errors.New("no snapshot topic configured")
+	}
+	event := model.UserEvent{
+		Type:     model.EventUserSnapshot,
+		UserID:   user.ID,
+		Sequence: user.Version,
+		User:     user,
+	}
+	data, err := p.serializer.Serialize(event)
 	if err != nil {
+		return err
+	}
+	return p.enqueue(p.message(p.snapshotTopic, event, data))
+}
+
+func (p *Producer) message(topic string, event model.UserEvent, value []byte) kafka.Message {
+	return kafka.Message{
+		Topic: topic,
+		Key:   []byte(strconv.FormatInt(event.UserID, 10)),
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: HeaderEventType, Value: []byte(event.Type)},
+			{Key: HeaderSequence, Value: []byte(strconv.FormatInt(event.Sequence, 10))},
+		},
+	}
+}
+
+// enqueue hands the messages to the writer without waiting for the broker.
+// The read lock keeps Close from tearing down the writer mid-enqueue.
+func (p *Producer) enqueue(msgs ...kafka.Message) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		p.fail(msgs...)
+		return ErrProducerClosed
+	}
+
+	for i := range msgs {
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			p.release(i)
+			p.fail(msgs...)
+			log.Printf("Kafka producer buffer full, dropping message key=%s", msgs[0].Key)
+			return ErrBufferFull
+		}
+	}
+	p.inFlight.Add(int64(len(msgs)))
+
+	// Async writes only fail here before the messages reach a batch, in which
+	// case no completion will follow
+	if err := p.writer.WriteMessages(context.Background(), msgs...); err != nil {
+		p.release(len(msgs))
+		p.inFlight.Add(-int64(len(msgs)))
+		p.fail(msgs...)
 		log.Println("failed to publish message:", err)
+		return err
+	}
+	return nil
+}
+
+func (p *Producer) release(n int) {
+	for i := 0; i < n; i++ {
+		<-p.slots
+	}
+}
+
+// complete is the writer's completion callback, called once per batch.
+func (p *Producer) complete(messages []kafka.Message, err error) {
+	for _, m := range messages {
+		<-p.slots
+		p.inFlight.Add(-1)
+		if err != nil {
+			p.fail(m)
+		} else {
+			p.delivered.Add(1)
+			producerMetrics.Add(m.Topic+".delivered", 1)
+		}
+
+		p.onDelivery(DeliveryReport{
+			Topic:     m.Topic,
+			Key:       string(m.Key),
+			EventType: headerValue(m, HeaderEventType),
+			Partition: m.Partition,
+			Offset:    m.Offset,
+			Err:       err,
+		})
+	}
+}
+
+// Close stops accepting messages and flushes everything buffered. It returns
+// ctx.Err() if the flush does not finish before ctx is done.
+func (p *Producer) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.writer.Close()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("flush %d in-flight messages: %w", p.inFlight.Load(), ctx.Err())
+	}
+}
+
+func (p *Producer) Stats() ProducerStats {
+	return ProducerStats{
+		InFlight:  p.inFlight.Load(),
+		Delivered: p.delivered.Load(),
+		Failed:    p.failed.Load(),
+	}
+}
+
+func (p *Producer) fail(msgs ...kafka.Message) {
+	for _, m := range msgs {
+		p.failed.Add(1)
+		producerMetrics.Add(m.Topic+".failed", 1)
+	}
+}
+
+func headerValue(m kafka.Message, key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func parseCompression(name string) (kafka.Compression, error) {
+	switch name {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return 0, fmt.Errorf("unsupported kafka compression %q", name)
+	}
+}
+
+func parseRequiredAcks(name string) (kafka.RequiredAcks, error) {
+	switch name {
+	case "", "all":
+		return kafka.RequireAll, nil
+	case "one":
+		return kafka.RequireOne, nil
+	case "none":
+		return kafka.RequireNone, nil
+	default:
+		return 0, fmt.Errorf("unsupported kafka required acks %q", name)
 	}
-	return err
 }
 
 var _ service.UserNotifier = (*Producer)(nil)
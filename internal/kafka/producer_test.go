@@ -0,0 +1,133 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/model"
+	"UserManagement/internal/schema"
+)
+
+// fakeWriter holds written messages until they are completed, as an async
+// kafka.Writer does until the broker answers.
+type fakeWriter struct {
+	p *Producer
+
+	mu      sync.Mutex
+	pending []kafka.Message
+	// closing, when set, holds Close until it is closed
+	closing chan struct{}
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, msgs...)
+	return nil
+}
+
+// complete finishes every pending message as one batch
+func (w *fakeWriter) complete(err error) {
+	w.mu.Lock()
+	msgs := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+	w.p.complete(msgs, err)
+}
+
+func (w *fakeWriter) Close() error {
+	if w.closing != nil {
+		<-w.closing
+	}
+	w.complete(nil)
+	return nil
+}
+
+func newTestProducer(t *testing.T, cfg ProducerConfig) (*Producer, *fakeWriter) {
+	serializer, err := schema.NewSerializer(schema.EncodingJSON, nil, "")
+	require.NoError(t, err)
+	p, err := NewProducer(cfg, serializer)
+	require.NoError(t, err)
+	w := &fakeWriter{p: p}
+	p.writer = w
+	return p, w
+}
+
+func userEvent(userID int64) model.UserEvent {
+	return model.UserEvent{Type: model.EventUserUpdated, UserID: userID, Sequence: 2, User: model.User{ID: userID}}
+}
+
+func TestProducerBufferIsBounded(t *testing.T) {
+	p, w := newTestProducer(t, ProducerConfig{Topic: "test_buffer", BufferSize: 2})
+
+	require.NoError(t, p.NotifyUserEvent(userEvent(1)))
+	require.NoError(t, p.NotifyUserEvent(userEvent(2)))
+	require.ErrorIs(t, p.NotifyUserEvent(userEvent(3)), ErrBufferFull)
+	require.Equal(t, ProducerStats{InFlight: 2, Failed: 1}, p.Stats())
+
+	// Delivered messages free their slots
+	w.complete(nil)
+	require.NoError(t, p.NotifyUserEvent(userEvent(3)))
+	require.Equal(t, ProducerStats{InFlight: 1, Delivered: 2, Failed: 1}, p.Stats())
+
+	// An event that also goes to the snapshot topic needs a slot for each
+	p, _ = newTestProducer(t, ProducerConfig{Topic: "test_buffer_events", SnapshotTopic: "test_buffer_snapshots", BufferSize: 3})
+	require.NoError(t, p.NotifyUserEvent(userEvent(1)))
+	require.ErrorIs(t, p.NotifyUserEvent(userEvent(2)), ErrBufferFull)
+	require.Equal(t, ProducerStats{InFlight: 2, Failed: 2}, p.Stats())
+}
+
+func TestProducerReportsCompletion(t *testing.T) {
+	var mu sync.Mutex
+	var reports []DeliveryReport
+	p, w := newTestProducer(t, ProducerConfig{Topic: "test_reports", OnDelivery: func(report DeliveryReport) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, report)
+	}})
+
+	require.NoError(t, p.NotifyUserEvent(userEvent(1)))
+	w.complete(nil)
+	require.NoError(t, p.NotifyUserEvent(userEvent(2)))
+	brokerDown := errors.New("broker down")
+	w.complete(brokerDown)
+
+	require.Equal(t, []DeliveryReport{
+		{Topic: "test_reports", Key: "1", EventType: model.EventUserUpdated},
+		{Topic: "test_reports", Key: "2", EventType: model.EventUserUpdated, Err: brokerDown},
+	}, reports)
+	require.Equal(t, ProducerStats{Delivered: 1, Failed: 1}, p.Stats())
+}
+
+func TestProducerCloseFlushes(t *testing.T) {
+	p, w := newTestProducer(t, ProducerConfig{Topic: "test_close"})
+	require.NoError(t, p.NotifyUserEvent(userEvent(1)))
+	require.NoError(t, p.NotifyUserEvent(userEvent(2)))
+
+	require.NoError(t, p.Close(context.Background()))
+	require.Empty(t, w.pending)
+	require.Equal(t, ProducerStats{Delivered: 2}, p.Stats())
+
+	require.ErrorIs(t, p.NotifyUserEvent(userEvent(3)), ErrProducerClosed)
+	require.Equal(t, int64(1), p.Stats().Failed)
+	require.NoError(t, p.Close(context.Background()), "closing twice is harmless")
+}
+
+func TestProducerCloseGivesUpAtDeadline(t *testing.T) {
+	p, w := newTestProducer(t, ProducerConfig{Topic: "test_close_deadline"})
+	w.closing = make(chan struct{})
+	defer close(w.closing)
+	require.NoError(t, p.NotifyUserEvent(userEvent(1)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := p.Close(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Contains(t, err.Error(), "flush 1 in-flight messages")
+}
@@ -0,0 +1,154 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	kafka "github.com/segmentio/kafka-go"
+
+	"UserManagement/internal/projection"
+	"UserManagement/internal/schema"
+)
+
+// ProjectionRunner feeds user events to a projection, reading every
+// partition directly from its checkpoint rather than through a consumer
+// group, so several projections can replay independently.
+type ProjectionRunner struct {
+	Broker       string
+	Topic        string
+	Deserializer *schema.Deserializer
+	Checkpoints  projection.CheckpointStore
+	// CheckpointEvery is how many events are applied between checkpoint saves
+	CheckpointEvery int
+}
+
+// Run applies events from the last checkpoint and keeps following the topic
+// until ctx is cancelled.
+func (r *ProjectionRunner) Run(ctx context.Context, p projection.Projection) error {
+	return r.run(ctx, p, false)
+}
+
+// Replay resets the projection and its checkpoints, then applies every event
+// from the beginning of the topic up to the current end.
+func (r *ProjectionRunner) Replay(ctx context.Context, p projection.Projection) error {
+	if err := r.Checkpoints.Reset(ctx, p.Name()); err != nil {
+		return fmt.Errorf("reset checkpoints: %w", err)
+	}
+	if err := p.Reset(ctx); err != nil {
+		return fmt.Errorf("reset projection: %w", err)
+	}
+	return r.run(ctx, p, true)
+}
+
+func (r *ProjectionRunner) run(ctx context.Context, p projection.Projection, stopAtEnd bool) error {
+	conn, err := kafka.Dial("tcp", r.Broker)
+	if err != nil {
+		return err
+	}
+	partitions, err := conn.ReadPartitions(r.Topic)
+	_ = conn.Close()
+	if err != nil {
+		return err
+	}
+	checkpoints, err := r.Checkpoints.Load(ctx, p.Name())
+	if err != nil {
+		return fmt.Errorf("load checkpoints: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, len(partitions))
+	for _, part := range partitions {
+		start, ok := checkpoints[part.ID]
+		if !ok {
+			start = kafka.FirstOffset
+		}
+		wg.Add(1)
+		go func(partition int, start int64) {
+			defer wg.Done()
+			if err := r.runPartition(ctx, p, partition, start, stopAtEnd); err != nil {
+				errs <- fmt.Errorf("partition %d: %w", partition, err)
+				cancel()
+			}
+		}(part.ID, start)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func (r *ProjectionRunner) runPartition(ctx context.Context, p projection.Projection, partition int, start int64, stopAtEnd bool) error {
+	end := int64(-1)
+	if stopAtEnd {
+		leader, err := kafka.DialLeader(ctx, "tcp", r.Broker, r.Topic, partition)
+		if err != nil {
+			return err
+		}
+		first, last, err := leader.ReadOffsets()
+		_ = leader.Close()
+		if err != nil {
+			return err
+		}
+		if start < first {
+			start = first
+		}
+		end = last
+		if start >= end {
+			return nil
+		}
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   []string{r.Broker},
+		Topic:     r.Topic,
+		Partition: partition,
+	})
+	defer reader.Close()
+	if err := reader.SetOffset(start); err != nil {
+		return err
+	}
+
+	every := r.CheckpointEvery
+	if every <= 0 {
+		every = 100
+	}
+	applied := 0
+	next := start
+	save := func() error {
+		if applied == 0 {
+			return nil
+		}
+		// A fresh context so the final checkpoint is saved even on cancellation
+		return r.Checkpoints.Save(context.Background(), p.Name(), partition, next)
+	}
+
+	for {
+		m, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return save()
+			}
+			return err
+		}
+		event, err := decodeEvent(m, readEventMeta(m), r.Deserializer)
+		if err != nil {
+			log.Printf("Projection %s: skipping undecodable event at %d/%d: %v", p.Name(), partition, m.Offset, err)
+		} else if err := p.Apply(ctx, event); err != nil {
+			return fmt.Errorf("apply offset %d: %w", m.Offset, err)
+		}
+		next = m.Offset + 1
+		applied++
+		if applied%every == 0 {
+			if err := save(); err != nil {
+				return fmt.Errorf("save checkpoint: %w", err)
+			}
+		}
+		if stopAtEnd && next >= end {
+			return save()
+		}
+	}
+}
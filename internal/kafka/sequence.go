@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"strconv"
+	"sync"
+
+	kafka "github.com/segmentio/kafka-go"
+
+	"UserManagement/internal/model"
+	"UserManagement/internal/schema"
+)
+
+// SequenceTracker remembers the last sequence seen for each user so that
+// stale or duplicate events can be dropped.
+type SequenceTracker struct {
+	mu   sync.Mutex
+	last map[int64]int64
+}
+
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{last: make(map[int64]int64)}
+}
+
+// Accept records the sequence for the user and reports whether it is newer
+// than anything seen before.
+func (t *SequenceTracker) Accept(userID, sequence int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, ok := t.last[userID]; ok && sequence <= last {
+		return false
+	}
+	t.last[userID] = sequence
+	return true
+}
+
+// eventMeta describes a consumed message. ok is false for messages without
+// ordering headers, which are passed through unchecked.
+type eventMeta struct {
+	eventType string
+	userID    int64
+	sequence  int64
+	ok        bool
+}
+
+func readEventMeta(m kafka.Message) eventMeta {
+	meta := eventMeta{eventType: string(m.Key)}
+	var hasType, hasSeq bool
+	for _, h := range m.Headers {
+		switch h.Key {
+		case HeaderEventType:
+			meta.eventType = string(h.Value)
+			hasType = true
+		case HeaderSequence:
+			seq, err := strconv.ParseInt(string(h.Value), 10, 64)
+			if err != nil {
+				return eventMeta{eventType: meta.eventType}
+			}
+			meta.sequence = seq
+			hasSeq = true
+		}
+	}
+	if !hasType || !hasSeq {
+		return meta
+	}
+	userID, err := strconv.ParseInt(string(m.Key), 10, 64)
+	if err != nil {
+		return meta
+	}
+	meta.userID = userID
+	meta.ok = true
+	return meta
+}
+
+// decodeEvent decodes the message value and fills in whatever the encoding
+// leaves out (plain JSON only carries the user) from the headers.
+func decodeEvent(m kafka.Message, meta eventMeta, deserializer *schema.Deserializer) (model.UserEvent, error) {
+	event, err := deserializer.Deserialize(m.Value)
+	if err != nil {
+		return model.UserEvent{}, err
+	}
+	if event.Type == "" {
+		event.Type = meta.eventType
+	}
+	if event.Sequence == 0 {
+		event.Sequence = meta.sequence
+	}
+	return event, nil
+}
@@ -0,0 +1,81 @@
+package kafka
+
+import (
+	"testing"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/model"
+)
+
+func TestSequenceTracker(t *testing.T) {
+	type event struct {
+		userID, sequence int64
+		accepted         bool
+	}
+	for _, tc := range []struct {
+		name   string
+		events []event
+	}{
+		{"in order", []event{{1, 1, true}, {1, 2, true}, {1, 3, true}}},
+		{"duplicate", []event{{1, 1, true}, {1, 1, false}, {1, 2, true}, {1, 2, false}}},
+		{"stale after a newer one", []event{{1, 5, true}, {1, 3, false}, {1, 4, false}, {1, 6, true}}},
+		{"gaps are accepted", []event{{1, 1, true}, {1, 4, true}, {1, 2, false}}},
+		{"out of order across users", []event{{1, 3, true}, {2, 1, true}, {2, 2, true}, {1, 2, false}, {2, 1, false}}},
+		{"first event may be any sequence", []event{{7, 42, true}, {8, 1, true}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := NewSequenceTracker()
+			for i, e := range tc.events {
+				require.Equal(t, e.accepted, tracker.Accept(e.userID, e.sequence), "event %d: user %d, sequence %d", i, e.userID, e.sequence)
+			}
+		})
+	}
+}
+
+func TestReadEventMeta(t *testing.T) {
+	header := func(key, value string) kafka.Header { return kafka.Header{Key: key, Value: []byte(value)} }
+	for _, tc := range []struct {
+		name    string
+		message kafka.Message
+		want    eventMeta
+	}{
+		{
+			name: "ordering headers",
+			message: kafka.Message{Key: []byte("12"), Headers: []kafka.Header{
+				header(HeaderEventType, model.EventUserUpdated), header(HeaderSequence, "3"),
+			}},
+			want: eventMeta{eventType: model.EventUserUpdated, userID: 12, sequence: 3, ok: true},
+		},
+		{
+			name:    "no sequence",
+			message: kafka.Message{Key: []byte("12"), Headers: []kafka.Header{header(HeaderEventType, model.EventUserUpdated)}},
+			want:    eventMeta{eventType: model.EventUserUpdated},
+		},
+		{
+			name: "bad sequence",
+			message: kafka.Message{Key: []byte("12"), Headers: []kafka.Header{
+				header(HeaderEventType, model.EventUserUpdated), header(HeaderSequence, "three"),
+			}},
+			want: eventMeta{eventType: model.EventUserUpdated},
+		},
+		{
+			name: "key is not a user ID",
+			message: kafka.Message{Key: []byte("user-12"), Headers: []kafka.Header{
+				header(HeaderEventType, model.EventUserUpdated), header(HeaderSequence, "3"),
+			}},
+			want: eventMeta{eventType: model.EventUserUpdated, sequence: 3},
+		},
+		{
+			// Messages from before the headers carried the event type as the key
+			name:    "legacy message",
+			message: kafka.Message{Key: []byte(model.EventUserCreated)},
+			want:    eventMeta{eventType: model.EventUserCreated},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, readEventMeta(tc.message))
+		})
+	}
+}
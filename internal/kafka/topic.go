@@ -0,0 +1,45 @@
+package kafka
+
+import (
+	"errors"
+	"net"
+	"strconv"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// EnsureCompactedTopic creates a log-compacted topic if it does not exist.
+// An existing topic is left untouched.
+func EnsureCompactedTopic(brokerAddr, topic string, partitions int) error {
+	conn, err := kafka.Dial("tcp", brokerAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Topics can only be created through the controller
+	controller, err := conn.Controller()
+	if err != nil {
+		return err
+	}
+	controllerConn, err := kafka.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		return err
+	}
+	defer controllerConn.Close()
+
+	err = controllerConn.CreateTopics(kafka.TopicConfig{
+		Topic:             topic,
+		NumPartitions:     partitions,
+		ReplicationFactor: 1,
+		ConfigEntries: []kafka.ConfigEntry{
+			{ConfigName: "cleanup.policy", ConfigValue: "compact"},
+			// Keep tombstones long enough for slow bootstrapping consumers to see them
+			{ConfigName: "delete.retention.ms", ConfigValue: "86400000"},
+		},
+	})
+	if errors.Is(err, kafka.TopicAlreadyExists) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+
+	"UserManagement/internal/model"
+	"UserManagement/internal/schema"
+	"UserManagement/internal/webhook"
+)
+
+// WebhookGroupID is shared by every instance: an event only has to be added
+// to the delivery log once.
+const WebhookGroupID = "webhook-group"
+
+// enqueueRetryDelay is the wait before queueing deliveries again after the
+// database failed
+var enqueueRetryDelay = time.Second
+
+// messageReader is the part of kafka.Reader the webhook consumer uses.
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// deliveryQueue is the part of webhook.Dispatcher the webhook consumer uses.
+type deliveryQueue interface {
+	Enqueue(ctx context.Context, event model.UserEvent) error
+}
+
+var _ deliveryQueue = (*webhook.Dispatcher)(nil)
+
+// StartWebhookConsumer queues webhook deliveries for events from topic until
+// ctx is cancelled. Offsets are only committed once the deliveries are
+// stored, so events aren't lost if the database is unavailable. The returned
+// channel is closed once the reader has closed.
+func StartWebhookConsumer(ctx context.Context, brokerAddr, topic string, dispatcher *webhook.Dispatcher, deserializer *schema.Deserializer) <-chan struct{} {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{brokerAddr},
+		Topic:       topic,
+		GroupID:     WebhookGroupID,
+		StartOffset: kafka.LastOffset,
+	})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer func() {
+			if err := reader.Close(); err != nil {
+				log.Printf("Error closing webhook Kafka reader: %v", err)
+			}
+		}()
+		consumeWebhookEvents(ctx, reader, dispatcher, deserializer)
+	}()
+	return done
+}
+
+// consumeWebhookEvents queues deliveries for each message until ctx is
+// cancelled. A message is retried until its deliveries are stored; the
+// dispatcher skips the ones an earlier try already stored.
+func consumeWebhookEvents(ctx context.Context, reader messageReader, queue deliveryQueue, deserializer *schema.Deserializer) {
+	tracker := NewSequenceTracker()
+	for {
+		m, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				log.Println("Webhook consumer stopped")
+				return
+			}
+			log.Println("Webhook consumer error:", err)
+			continue
+		}
+
+		meta := readEventMeta(m)
+		if meta.ok && !tracker.Accept(meta.userID, meta.sequence) {
+			log.Printf("Dropping stale event: type=%s, user=%d, sequence=%d", meta.eventType, meta.userID, meta.sequence)
+		} else if event, err := decodeEvent(m, meta, deserializer); err != nil {
+			log.Printf("Failed to decode event at offset %d: %v", m.Offset, err)
+		} else {
+			for {
+				err := queue.Enqueue(ctx, event)
+				if err == nil {
+					break
+				}
+				log.Printf("Failed to queue webhook deliveries for offset %d: %v", m.Offset, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(enqueueRetryDelay):
+				}
+			}
+		}
+
+		if err := reader.CommitMessages(ctx, m); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to commit offset %d: %v", m.Offset, err)
+		}
+	}
+}
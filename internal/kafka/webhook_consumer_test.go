@@ -0,0 +1,152 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/model"
+	"UserManagement/internal/schema"
+)
+
+// fakeReader hands out messages in order, then blocks until ctx is done.
+type fakeReader struct {
+	messages chan kafka.Message
+	mu       sync.Mutex
+	commits  []int64
+}
+
+func newFakeReader(messages ...kafka.Message) *fakeReader {
+	r := &fakeReader{messages: make(chan kafka.Message, len(messages))}
+	for i, m := range messages {
+		m.Offset = int64(i)
+		r.messages <- m
+	}
+	return r
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-r.messages:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range msgs {
+		r.commits = append(r.commits, m.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) committed() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64(nil), r.commits...)
+}
+
+// fakeQueue records the events it is given; fail can turn them away.
+type fakeQueue struct {
+	mu     sync.Mutex
+	events []model.UserEvent
+	fail   func(model.UserEvent) error
+}
+
+func (q *fakeQueue) Enqueue(ctx context.Context, event model.UserEvent) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.fail != nil {
+		if err := q.fail(event); err != nil {
+			return err
+		}
+	}
+	q.events = append(q.events, event)
+	return nil
+}
+
+func (q *fakeQueue) enqueued() []model.UserEvent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]model.UserEvent(nil), q.events...)
+}
+
+// eventMessage is a message as the producer writes it, with a JSON value.
+func eventMessage(t *testing.T, eventType string, userID, sequence int64) kafka.Message {
+	value, err := json.Marshal(model.User{ID: userID})
+	require.NoError(t, err)
+	return kafka.Message{
+		Key:   []byte(strconv.FormatInt(userID, 10)),
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: HeaderEventType, Value: []byte(eventType)},
+			{Key: HeaderSequence, Value: []byte(strconv.FormatInt(sequence, 10))},
+		},
+	}
+}
+
+func runWebhookConsumer(t *testing.T, reader messageReader, queue deliveryQueue) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumeWebhookEvents(ctx, reader, queue, schema.NewDeserializer(nil))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestWebhookConsumerQueuesEvents(t *testing.T) {
+	bad := eventMessage(t, model.EventUserUpdated, 3, 1)
+	bad.Value = []byte("not json")
+	reader := newFakeReader(
+		eventMessage(t, model.EventUserCreated, 1, 1),
+		eventMessage(t, model.EventUserCreated, 1, 1), // delivered twice by Kafka
+		bad,
+		eventMessage(t, model.EventUserUpdated, 2, 4),
+	)
+	queue := &fakeQueue{}
+	runWebhookConsumer(t, reader, queue)
+
+	// Stale and undecodable messages are committed too, so they aren't read again
+	require.Eventually(t, func() bool { return len(reader.committed()) == 4 }, time.Second, time.Millisecond)
+	require.Equal(t, []int64{0, 1, 2, 3}, reader.committed())
+	events := queue.enqueued()
+	require.Len(t, events, 2)
+	require.Equal(t, model.UserEvent{Type: model.EventUserCreated, UserID: 1, Sequence: 1, User: model.User{ID: 1}}, events[0])
+	require.Equal(t, model.UserEvent{Type: model.EventUserUpdated, UserID: 2, Sequence: 4, User: model.User{ID: 2}}, events[1])
+}
+
+func TestWebhookConsumerRetriesBeforeCommitting(t *testing.T) {
+	defer func(delay time.Duration) { enqueueRetryDelay = delay }(enqueueRetryDelay)
+	enqueueRetryDelay = time.Millisecond
+
+	reader := newFakeReader(eventMessage(t, model.EventUserCreated, 1, 1))
+	failures, committedEarly := 0, false
+	queue := &fakeQueue{}
+	queue.fail = func(model.UserEvent) error {
+		committedEarly = committedEarly || len(reader.committed()) > 0
+		if failures++; failures <= 2 {
+			return errors.New("database is down")
+		}
+		return nil
+	}
+	runWebhookConsumer(t, reader, queue)
+
+	require.Eventually(t, func() bool { return len(reader.committed()) == 1 }, time.Second, time.Millisecond)
+	require.Len(t, queue.enqueued(), 1)
+	require.Equal(t, 3, failures)
+	require.False(t, committedEarly, "committed before the deliveries were stored")
+}
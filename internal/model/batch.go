@@ -0,0 +1,127 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Batch operations
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// Batch modes: atomic batches apply every operation or none; best-effort
+// batches apply what they can and report each operation's outcome.
+const (
+	BatchAtomic     = "atomic"
+	BatchBestEffort = "best_effort"
+)
+
+// Outcomes of a single batch operation
+const (
+	BatchItemOK         = "ok"
+	BatchItemFailed     = "failed"
+	BatchItemRolledBack = "rolled_back"
+	BatchItemSkipped    = "skipped"
+)
+
+// BatchRequest is the body of POST /users:batch.
+type BatchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation is one create, update or delete. Its data is decoded into
+// Create or Update according to Op.
+type BatchOperation struct {
+	Op     string
+	UserID int64
+	Create *CreateUserRequest
+	Update *UpdateUserRequest
+}
+
+func (o *BatchOperation) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Op     string          `json:"op"`
+		UserID int64           `json:"user_id"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*o = BatchOperation{Op: raw.Op, UserID: raw.UserID}
+	switch raw.Op {
+	case BatchCreate:
+		o.Create = &CreateUserRequest{}
+		return decodeBatchData(raw.Op, raw.Data, o.Create)
+	case BatchUpdate:
+		o.Update = &UpdateUserRequest{}
+		return decodeBatchData(raw.Op, raw.Data, o.Update)
+	case BatchDelete:
+		return nil
+	default:
+		return fmt.Errorf("unknown batch op %q", raw.Op)
+	}
+}
+
+func decodeBatchData(op string, data json.RawMessage, out interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("%s needs data", op)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s data: %w", op, err)
+	}
+	return nil
+}
+
+// Check reports what is wrong with the operation's shape, before its fields
+// are validated.
+func (o BatchOperation) Check() error {
+	switch o.Op {
+	case BatchCreate:
+		if o.Create == nil {
+			return fmt.Errorf("%s needs data", o.Op)
+		}
+		return nil
+	case BatchUpdate:
+		if o.Update == nil {
+			return fmt.Errorf("%s needs data", o.Op)
+		}
+		return validUserID(o.UserID)
+	case BatchDelete:
+		return validUserID(o.UserID)
+	default:
+		return fmt.Errorf("unknown batch op %q", o.Op)
+	}
+}
+
+// BatchItemResult is the outcome of the operation at Index. Err is the
+// error behind a failure, for mapping onto a status.
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	Status string `json:"status"`
+	User   *User  `json:"user,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Err    error  `json:"-"`
+}
+
+// BatchResult reports every operation of a batch, in request order.
+type BatchResult struct {
+	Mode      string            `json:"mode"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+// FirstError is the error of the first failed operation, if any.
+func (r BatchResult) FirstError() error {
+	for _, item := range r.Results {
+		if item.Status == BatchItemFailed {
+			return item.Err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"UserManagement/internal/command"
+)
+
+var (
+	errInvalidUserID = errors.New("user_id must be positive")
+	errEmptyBatch    = errors.New("batch has no operations")
+	errEmptyEmail    = errors.New("email is required")
+)
+
+// Commands and queries on users, dispatched through a command.Bus. The
+// embedded command.Returns declares what each one returns.
+
+type CreateUser struct {
+	command.Returns[User]
+	Req CreateUserRequest
+}
+
+func (CreateUser) CommandName() string { return "create_user" }
+
+type UpdateUser struct {
+	command.Returns[User]
+	UserID int64
+	Req    UpdateUserRequest
+}
+
+func (UpdateUser) CommandName() string { return "update_user" }
+
+func (c UpdateUser) Validate() error { return validUserID(c.UserID) }
+
+type DeleteUser struct {
+	command.Returns[User]
+	UserID int64
+}
+
+func (DeleteUser) CommandName() string { return "delete_user" }
+
+func (c DeleteUser) Validate() error { return validUserID(c.UserID) }
+
+type GetUser struct {
+	command.Returns[User]
+	UserID int64
+}
+
+func (GetUser) CommandName() string { return "get_user" }
+
+func (c GetUser) Validate() error { return validUserID(c.UserID) }
+
+type GetUserByEmail struct {
+	command.Returns[User]
+	Email string
+}
+
+func (GetUserByEmail) CommandName() string { return "get_user_by_email" }
+
+func (c GetUserByEmail) Validate() error {
+	if c.Email == "" {
+		return errEmptyEmail
+	}
+	return nil
+}
+
+type ListUsers struct {
+	command.Returns[[]User]
+}
+
+func (ListUsers) CommandName() string { return "get_users" }
+
+// ExportUsers passes every user matching Filter to Each, in ID order,
+// without holding them all in memory. It returns how many were exported.
+type ExportUsers struct {
+	command.Returns[int]
+	Filter UserFilter
+	Each   func(user User) error
+}
+
+func (ExportUsers) CommandName() string { return "export_users" }
+
+func (c ExportUsers) Validate() error {
+	if c.Each == nil {
+		return errors.New("nothing to export to")
+	}
+	return c.Filter.Validate()
+}
+
+// BatchUsers applies a batch of operations.
+type BatchUsers struct {
+	command.Returns[BatchResult]
+	Mode       string
+	Operations []BatchOperation
+}
+
+func (BatchUsers) CommandName() string { return "batch_users" }
+
+func (c BatchUsers) Validate() error {
+	if c.Mode != BatchAtomic && c.Mode != BatchBestEffort {
+		return fmt.Errorf("mode must be %q or %q", BatchAtomic, BatchBestEffort)
+	}
+	if len(c.Operations) == 0 {
+		return errEmptyBatch
+	}
+	return nil
+}
+
+func validUserID(id int64) error {
+	if id <= 0 {
+		return errInvalidUserID
+	}
+	return nil
+}
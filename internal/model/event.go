@@ -0,0 +1,20 @@
+package model
+
+// Event types published for user changes
+const (
+	EventUserCreated = "user_created"
+	EventUserUpdated = "user_updated"
+	EventUserDeleted = "user_deleted"
+
+	// EventUserSnapshot marks a full-state record re-published from the database
+	EventUserSnapshot = "user_snapshot"
+)
+
+// UserEvent is a change to a single user. Sequence increases monotonically per
+// user so consumers can discard events that arrive out of order.
+type UserEvent struct {
+	Type     string `json:"type"`
+	UserID   int64  `json:"user_id"`
+	Sequence int64  `json:"sequence"`
+	User     User   `json:"user"`
+}
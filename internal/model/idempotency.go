@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// IdempotencyRecord is a claimed Idempotency-Key. Response is set once the
+// request it guards has completed.
+type IdempotencyRecord struct {
+	Scope       string
+	Key         string
+	Fingerprint string
+	Completed   bool
+	Response    StoredResponse
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// StoredResponse is what is replayed for a repeated request.
+type StoredResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
@@ -0,0 +1,56 @@
+package model
+
+import "time"
+
+// Import job states
+const (
+	ImportQueued    = "queued"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+	ImportCancelled = "cancelled"
+)
+
+// What happened to an imported row
+const (
+	RowCreated = "created"
+	RowUpdated = "updated"
+	RowSkipped = "skipped"
+	RowFailed  = "failed"
+)
+
+// ImportJob is a user import running in the background. In a dry run rows
+// are checked and reported as they would be, but nothing is written.
+type ImportJob struct {
+	ID         string     `json:"id"`
+	State      string     `json:"state"`
+	Format     string     `json:"format"`
+	DryRun     bool       `json:"dry_run"`
+	Rows       int        `json:"rows"`
+	Created    int        `json:"created"`
+	Updated    int        `json:"updated"`
+	Skipped    int        `json:"skipped"`
+	Failed     int        `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Token opens the job to its holder; only the response that starts the
+	// job has it
+	Token string `json:"token,omitempty"`
+}
+
+// Done reports whether the job has stopped.
+func (j ImportJob) Done() bool {
+	return j.State == ImportCompleted || j.State == ImportFailed || j.State == ImportCancelled
+}
+
+// ImportRow is one line of an import's report. Row is the CSV row after the
+// header, or the NDJSON line, counting from 1.
+type ImportRow struct {
+	Row    int    `json:"row"`
+	Email  string `json:"email,omitempty"`
+	Result string `json:"result"`
+	UserID int64  `json:"user_id,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
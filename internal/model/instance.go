@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// ServerInstance is the lease of a running server. Live is false once the
+// lease has gone without a heartbeat for longer than it lasts.
+type ServerInstance struct {
+	ID          string
+	StartedAt   time.Time
+	HeartbeatAt time.Time
+	Live        bool
+}
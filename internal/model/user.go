@@ -1,5 +1,10 @@
 package model
 
+import (
+	"errors"
+	"time"
+)
+
 type User struct {
 	ID        int64   `json:"id"`
 	FirstName string  `json:"first_name"`
@@ -8,6 +13,7 @@ type User struct {
 	Phone     *string `json:"phone,omitempty"`
 	Age       *int32  `json:"age,omitempty"`
 	Status    *string `json:"status,omitempty"`
+	Version   int64   `json:"version"`
 }
 
 type CreateUserRequest struct {
@@ -28,13 +34,18 @@ type UpdateUserRequest struct {
 	Status    *string `json:"status"`
 }
 
-type CUDRequest struct {
-	Type      string
-	CreateReq CreateUserRequest
-	UpdateReq struct {
-		UserID int64
-		Req    UpdateUserRequest
+// UserFilter narrows the users exported. Empty fields match every
+// user; Email matches part of an address, ignoring case.
+type UserFilter struct {
+	Status        string
+	Email         string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+func (f UserFilter) Validate() error {
+	if f.CreatedAfter != nil && f.CreatedBefore != nil && !f.CreatedAfter.Before(*f.CreatedBefore) {
+		return errors.New("created_after must be before created_before")
 	}
-	UserID          int64
-	ResponseChannel chan interface{}
+	return nil
 }
@@ -0,0 +1,87 @@
+package projection
+
+import (
+	"context"
+	"sync"
+
+	sqlc "UserManagement/internal/db/sqlc"
+)
+
+// CheckpointStore tracks, per projection, the next offset to read on each
+// partition.
+type CheckpointStore interface {
+	Load(ctx context.Context, projection string) (map[int]int64, error)
+	Save(ctx context.Context, projection string, partition int, offset int64) error
+	Reset(ctx context.Context, projection string) error
+}
+
+// MemoryCheckpointStore keeps checkpoints for the lifetime of the process,
+// which suits in-memory projections that are rebuilt on every start.
+type MemoryCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[string]map[int]int64
+}
+
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]map[int]int64)}
+}
+
+func (s *MemoryCheckpointStore) Load(_ context.Context, projection string) (map[int]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[int]int64, len(s.checkpoints[projection]))
+	for partition, offset := range s.checkpoints[projection] {
+		out[partition] = offset
+	}
+	return out, nil
+}
+
+func (s *MemoryCheckpointStore) Save(_ context.Context, projection string, partition int, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkpoints[projection] == nil {
+		s.checkpoints[projection] = make(map[int]int64)
+	}
+	s.checkpoints[projection][partition] = offset
+	return nil
+}
+
+func (s *MemoryCheckpointStore) Reset(_ context.Context, projection string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.checkpoints, projection)
+	return nil
+}
+
+// PostgresCheckpointStore persists checkpoints in projection_checkpoints.
+type PostgresCheckpointStore struct {
+	queries *sqlc.Queries
+}
+
+func NewPostgresCheckpointStore(queries *sqlc.Queries) *PostgresCheckpointStore {
+	return &PostgresCheckpointStore{queries: queries}
+}
+
+func (s *PostgresCheckpointStore) Load(ctx context.Context, projection string) (map[int]int64, error) {
+	rows, err := s.queries.GetCheckpoints(ctx, projection)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int]int64, len(rows))
+	for _, row := range rows {
+		out[int(row.KafkaPartition)] = row.KafkaOffset
+	}
+	return out, nil
+}
+
+func (s *PostgresCheckpointStore) Save(ctx context.Context, projection string, partition int, offset int64) error {
+	return s.queries.SaveCheckpoint(ctx, sqlc.SaveCheckpointParams{
+		Projection:     projection,
+		KafkaPartition: int32(partition),
+		KafkaOffset:    offset,
+	})
+}
+
+func (s *PostgresCheckpointStore) Reset(ctx context.Context, projection string) error {
+	return s.queries.DeleteCheckpoints(ctx, projection)
+}
@@ -0,0 +1,60 @@
+package projection
+
+import (
+	"context"
+	"errors"
+
+	sqlc "UserManagement/internal/db/sqlc"
+	"UserManagement/internal/model"
+)
+
+var ErrUnknownProjection = errors.New("unknown projection")
+
+// Projection is a read model built from the user event stream. Apply is
+// called from one goroutine per partition, so implementations must be safe
+// for concurrent use. Events for one user always arrive in order, but Apply
+// may see an event again after a restart from the last checkpoint.
+type Projection interface {
+	Name() string
+	Apply(ctx context.Context, event model.UserEvent) error
+	// Reset discards all state so the projection can be replayed from scratch
+	Reset(ctx context.Context) error
+	// State returns a JSON-serialisable view of the read model
+	State(ctx context.Context) (interface{}, error)
+}
+
+// Registry holds projections by name.
+type Registry map[string]Projection
+
+func NewRegistry(projections ...Projection) Registry {
+	r := make(Registry, len(projections))
+	for _, p := range projections {
+		r[p.Name()] = p
+	}
+	return r
+}
+
+func (r Registry) Get(name string) (Projection, error) {
+	if p, ok := r[name]; ok {
+		return p, nil
+	}
+	return nil, ErrUnknownProjection
+}
+
+// Setup pairs a projection with the checkpoint store that matches where it
+// keeps its state: in-memory projections restart from the beginning of the
+// topic, table-backed ones resume from Postgres.
+type Setup struct {
+	Projection  Projection
+	Checkpoints CheckpointStore
+	// Persistent is set for projections whose state outlives the process
+	Persistent bool
+}
+
+// Builtin returns the projections shipped with the service.
+func Builtin(queries *sqlc.Queries) []Setup {
+	return []Setup{
+		{Projection: NewUserCount(), Checkpoints: NewMemoryCheckpointStore()},
+		{Projection: NewStatusBreakdown(queries), Checkpoints: NewPostgresCheckpointStore(queries), Persistent: true},
+	}
+}
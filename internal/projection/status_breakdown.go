@@ -0,0 +1,55 @@
+package projection
+
+import (
+	"context"
+	"database/sql"
+
+	sqlc "UserManagement/internal/db/sqlc"
+	"UserManagement/internal/model"
+)
+
+// StatusBreakdown counts live users per status. Its state lives in the
+// projection_user_status table, so it survives restarts together with its
+// Postgres checkpoints.
+type StatusBreakdown struct {
+	queries *sqlc.Queries
+}
+
+func NewStatusBreakdown(queries *sqlc.Queries) *StatusBreakdown {
+	return &StatusBreakdown{queries: queries}
+}
+
+func (p *StatusBreakdown) Name() string {
+	return "status_breakdown"
+}
+
+// Apply upserts the user's status; the query ignores sequences it has
+// already seen, which makes replays idempotent.
+func (p *StatusBreakdown) Apply(ctx context.Context, event model.UserEvent) error {
+	status := sql.NullString{}
+	if event.User.Status != nil {
+		status = sql.NullString{String: *event.User.Status, Valid: true}
+	}
+	return p.queries.UpsertUserStatus(ctx, sqlc.UpsertUserStatusParams{
+		UserID:   event.UserID,
+		Status:   status,
+		Sequence: event.Sequence,
+		Deleted:  event.Type == model.EventUserDeleted,
+	})
+}
+
+func (p *StatusBreakdown) Reset(ctx context.Context) error {
+	return p.queries.DeleteAllUserStatus(ctx)
+}
+
+func (p *StatusBreakdown) State(ctx context.Context) (interface{}, error) {
+	rows, err := p.queries.CountUsersByStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	breakdown := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		breakdown[row.Status] = row.Count
+	}
+	return breakdown, nil
+}
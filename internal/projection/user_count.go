@@ -0,0 +1,64 @@
+package projection
+
+import (
+	"context"
+	"sync"
+
+	"UserManagement/internal/model"
+)
+
+// UserCount keeps the number of live users in memory. It remembers the last
+// sequence per user so replayed events are not counted twice.
+type UserCount struct {
+	mu    sync.RWMutex
+	users map[int64]int64 // user ID -> last sequence, negative once deleted
+}
+
+func NewUserCount() *UserCount {
+	return &UserCount{users: make(map[int64]int64)}
+}
+
+func (p *UserCount) Name() string {
+	return "user_count"
+}
+
+func (p *UserCount) Apply(_ context.Context, event model.UserEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	last, seen := p.users[event.UserID]
+	if seen && abs(last) >= event.Sequence {
+		return nil
+	}
+	if event.Type == model.EventUserDeleted {
+		p.users[event.UserID] = -event.Sequence
+	} else {
+		p.users[event.UserID] = event.Sequence
+	}
+	return nil
+}
+
+func (p *UserCount) Reset(_ context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.users = make(map[int64]int64)
+	return nil
+}
+
+func (p *UserCount) State(_ context.Context) (interface{}, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	count := 0
+	for _, seq := range p.users {
+		if seq > 0 {
+			count++
+		}
+	}
+	return map[string]int{"users": count}, nil
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,35 @@
+package projection
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/model"
+)
+
+func TestUserCountIgnoresReplayedEvents(t *testing.T) {
+	ctx := context.Background()
+	p := NewUserCount()
+	events := []model.UserEvent{
+		{Type: model.EventUserCreated, UserID: 1, Sequence: 1},
+		{Type: model.EventUserCreated, UserID: 2, Sequence: 1},
+		{Type: model.EventUserUpdated, UserID: 1, Sequence: 2},
+		{Type: model.EventUserDeleted, UserID: 2, Sequence: 2},
+	}
+	// Applying the stream twice, as after a restart from an old checkpoint, changes nothing
+	for i := 0; i < 2; i++ {
+		for _, e := range events {
+			require.NoError(t, p.Apply(ctx, e))
+		}
+		state, err := p.State(ctx)
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{"users": 1}, state)
+	}
+
+	require.NoError(t, p.Reset(ctx))
+	state, err := p.State(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"users": 0}, state)
+}
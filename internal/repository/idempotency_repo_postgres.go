@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	sqlc "UserManagement/internal/db/sqlc"
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+)
+
+// PostgresIdempotencyRepository is the PostgreSQL implementation of IdempotencyRepository
+type PostgresIdempotencyRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresIdempotencyRepository creates a new instance of PostgresIdempotencyRepository
+func NewPostgresIdempotencyRepository(queries *sqlc.Queries) *PostgresIdempotencyRepository {
+	return &PostgresIdempotencyRepository{queries: queries}
+}
+
+// ClaimKey reports whether the key was free, expired or abandoned (in
+// progress since before staleBefore) and is now held by the caller.
+func (r *PostgresIdempotencyRepository) ClaimKey(ctx context.Context, scope, key, fingerprint string, expiresAt, staleBefore time.Time) (bool, error) {
+	rows, err := r.queries.ClaimIdempotencyKey(ctx, sqlc.ClaimIdempotencyKeyParams{
+		Scope:          scope,
+		IdempotencyKey: key,
+		Fingerprint:    fingerprint,
+		ExpiresAt:      expiresAt,
+		StaleBefore:    staleBefore,
+	})
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (r *PostgresIdempotencyRepository) GetKey(ctx context.Context, scope, key string) (model.IdempotencyRecord, error) {
+	row, err := r.queries.GetIdempotencyKey(ctx, sqlc.GetIdempotencyKeyParams{
+		Scope:          scope,
+		IdempotencyKey: key,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.IdempotencyRecord{}, errs.ErrKeyNotFound
+	}
+	if err != nil {
+		return model.IdempotencyRecord{}, err
+	}
+	return model.IdempotencyRecord{
+		Scope:       row.Scope,
+		Key:         row.IdempotencyKey,
+		Fingerprint: row.Fingerprint,
+		Completed:   row.Completed,
+		Response: model.StoredResponse{
+			Status:      int(row.ResponseStatus.Int32),
+			ContentType: row.ContentType.String,
+			Body:        row.ResponseBody,
+		},
+		CreatedAt: row.CreatedAt,
+		ExpiresAt: row.ExpiresAt,
+	}, nil
+}
+
+func (r *PostgresIdempotencyRepository) CompleteKey(ctx context.Context, scope, key string, response model.StoredResponse) error {
+	return r.queries.CompleteIdempotencyKey(ctx, sqlc.CompleteIdempotencyKeyParams{
+		Scope:          scope,
+		IdempotencyKey: key,
+		ResponseStatus: sql.NullInt32{Int32: int32(response.Status), Valid: true},
+		ContentType:    sql.NullString{String: response.ContentType, Valid: response.ContentType != ""},
+		ResponseBody:   response.Body,
+	})
+}
+
+// ReleaseKey drops a claim so the request can be retried under the same key.
+func (r *PostgresIdempotencyRepository) ReleaseKey(ctx context.Context, scope, key string) error {
+	return r.queries.DeleteIdempotencyKey(ctx, sqlc.DeleteIdempotencyKeyParams{
+		Scope:          scope,
+		IdempotencyKey: key,
+	})
+}
+
+func (r *PostgresIdempotencyRepository) DeleteExpiredKeys(ctx context.Context) (int64, error) {
+	return r.queries.DeleteExpiredIdempotencyKeys(ctx)
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	sqlc "UserManagement/internal/db/sqlc"
+	"UserManagement/internal/model"
+)
+
+// PostgresInstanceRepository keeps server leases in PostgreSQL; see
+// instance.Store. Lease ages are worked out with the database's clock, so
+// the instances' clocks don't have to agree.
+type PostgresInstanceRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresInstanceRepository creates a new instance of PostgresInstanceRepository
+func NewPostgresInstanceRepository(queries *sqlc.Queries) *PostgresInstanceRepository {
+	return &PostgresInstanceRepository{queries: queries}
+}
+
+func (r *PostgresInstanceRepository) Heartbeat(ctx context.Context, id string) error {
+	return r.queries.HeartbeatServerInstance(ctx, id)
+}
+
+func (r *PostgresInstanceRepository) Release(ctx context.Context, id string) error {
+	return r.queries.DeleteServerInstance(ctx, id)
+}
+
+func (r *PostgresInstanceRepository) DeleteStale(ctx context.Context, ttl time.Duration) (int64, error) {
+	return r.queries.DeleteStaleServerInstances(ctx, ttl.Seconds())
+}
+
+func (r *PostgresInstanceRepository) List(ctx context.Context, ttl time.Duration) ([]model.ServerInstance, error) {
+	rows, err := r.queries.ListServerInstances(ctx, ttl.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]model.ServerInstance, 0, len(rows))
+	for _, row := range rows {
+		instances = append(instances, model.ServerInstance{
+			ID:          row.InstanceID,
+			StartedAt:   row.StartedAt,
+			HeartbeatAt: row.HeartbeatAt,
+			Live:        row.Live,
+		})
+	}
+	return instances, nil
+}
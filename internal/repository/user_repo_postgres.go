@@ -3,20 +3,47 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
 
 	sqlc "UserManagement/internal/db/sqlc"
+	"UserManagement/internal/errs"
 	"UserManagement/internal/model"
 	"UserManagement/internal/util"
 )
 
+// uniqueViolation is the Postgres error code for a duplicate key
+const uniqueViolation = "23505"
+
 // PostgresUserRepository is the PostgreSQL implementation of UserRepository
 type PostgresUserRepository struct {
+	// db is nil for a repository bound to a transaction
+	db      *sql.DB
 	queries *sqlc.Queries
 }
 
 // NewPostgresUserRepository creates a new instance of PostgresUserRepository
-func NewPostgresUserRepository(queries *sqlc.Queries) *PostgresUserRepository {
-	return &PostgresUserRepository{queries: queries}
+func NewPostgresUserRepository(db *sql.DB) *PostgresUserRepository {
+	return &PostgresUserRepository{db: db, queries: sqlc.New(db)}
+}
+
+// InTx runs fn with a repository bound to one transaction, committed if fn
+// succeeds and rolled back otherwise. Calls on a repository that is already
+// in a transaction join it.
+func (r *PostgresUserRepository) InTx(ctx context.Context, fn func(repo UserRepository) error) error {
+	if r.db == nil {
+		return fn(r)
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(&PostgresUserRepository{queries: r.queries.WithTx(tx)}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 func (r *PostgresUserRepository) CreateUserRepo(ctx context.Context, req model.CreateUserRequest) (model.User, error) {
@@ -38,6 +65,9 @@ func (r *PostgresUserRepository) CreateUserRepo(ctx context.Context, req model.C
 		},
 	}
 	user, err := r.queries.CreateUser(ctx, arg)
+	if isUniqueViolation(err) {
+		return model.User{}, errs.ErrDuplicateUser
+	}
 	if err != nil {
 		return model.User{}, err
 	}
@@ -46,6 +76,20 @@ func (r *PostgresUserRepository) CreateUserRepo(ctx context.Context, req model.C
 
 func (r *PostgresUserRepository) GetUserRepo(ctx context.Context, userID int64) (model.User, error) {
 	user, err := r.queries.GetUser(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.User{}, errs.ErrUserNotFound
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+	return mapToModelUser(user), nil
+}
+
+func (r *PostgresUserRepository) GetUserByEmailRepo(ctx context.Context, email string) (model.User, error) {
+	user, err := r.queries.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.User{}, errs.ErrUserNotFound
+	}
 	if err != nil {
 		return model.User{}, err
 	}
@@ -82,6 +126,12 @@ func (r *PostgresUserRepository) UpdateUserRepo(ctx context.Context, userID int6
 	}
 
 	user, err := r.queries.UpdateUser(ctx, arg)
+	if isUniqueViolation(err) {
+		return model.User{}, errs.ErrDuplicateUser
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.User{}, errs.ErrUserNotFound
+	}
 	if err != nil {
 		return model.User{}, err
 	}
@@ -90,6 +140,9 @@ func (r *PostgresUserRepository) UpdateUserRepo(ctx context.Context, userID int6
 
 func (r *PostgresUserRepository) DeleteUserRepo(ctx context.Context, userID int64) (model.User, error) {
 	user, err := r.queries.DeleteUser(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.User{}, errs.ErrUserNotFound
+	}
 	if err != nil {
 		return model.User{}, err
 	}
@@ -108,6 +161,102 @@ func (r *PostgresUserRepository) ListUsersRepo(ctx context.Context) ([]model.Use
 	return result, nil
 }
 
+// declareExport opens a cursor over the users matching the export filter.
+// sqlc can't generate cursor statements, so this query isn't in user.sql.
+const declareExport = `DECLARE user_export NO SCROLL CURSOR FOR
+SELECT user_id, first_name, last_name, email, phone, age, status, created_at, updated_at, version FROM users
+WHERE ($1::text IS NULL OR status = $1::text)
+  AND ($2::text IS NULL OR email ILIKE '%' || $2::text || '%')
+  AND ($3::timestamp IS NULL OR created_at >= $3::timestamp)
+  AND ($4::timestamp IS NULL OR created_at < $4::timestamp)
+ORDER BY user_id`
+
+// fetchExport reads the next batch from the cursor, empty once it's done
+const fetchExport = `FETCH FORWARD 1000 FROM user_export`
+
+// ExportUsersRepo reads the users through a cursor, a batch at a time, in a
+// read-only transaction so the export is one consistent snapshot.
+func (r *PostgresUserRepository) ExportUsersRepo(ctx context.Context, filter model.UserFilter, fn func(user model.User) error) error {
+	if r.db == nil {
+		return errors.New("exports can't run inside a transaction")
+	}
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	// Nothing was written, so rolling back just closes the cursor
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, declareExport, exportArgs(filter)...); err != nil {
+		return err
+	}
+	for {
+		n, err := fetchUsers(ctx, tx, fn)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+// fetchUsers passes the next batch from the export cursor to fn and returns
+// how many rows it held.
+func fetchUsers(ctx context.Context, tx *sql.Tx, fn func(user model.User) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fetchExport)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var u sqlc.User
+		if err := rows.Scan(
+			&u.UserID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.Phone,
+			&u.Age,
+			&u.Status,
+			&u.CreatedAt,
+			&u.UpdatedAt,
+			&u.Version,
+		); err != nil {
+			return n, err
+		}
+		n++
+		if err := fn(mapToModelUser(u)); err != nil {
+			return n, err
+		}
+	}
+	return n, rows.Err()
+}
+
+// exportArgs turns a filter into the arguments of declareExport. created_at
+// has no time zone and is written in UTC.
+func exportArgs(filter model.UserFilter) []any {
+	var createdAfter, createdBefore sql.NullTime
+	if filter.CreatedAfter != nil {
+		createdAfter = sql.NullTime{Time: filter.CreatedAfter.UTC(), Valid: true}
+	}
+	if filter.CreatedBefore != nil {
+		createdBefore = sql.NullTime{Time: filter.CreatedBefore.UTC(), Valid: true}
+	}
+	return []any{
+		sql.NullString{String: filter.Status, Valid: filter.Status != ""},
+		sql.NullString{String: filter.Email, Valid: filter.Email != ""},
+		createdAfter,
+		createdBefore,
+	}
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
 func mapToModelUser(u sqlc.User) model.User {
 	return model.User{
 		ID:        u.UserID,
@@ -117,5 +266,6 @@ func mapToModelUser(u sqlc.User) model.User {
 		Phone:     util.NullableStringPtr(u.Phone),
 		Age:       util.NullableInt32Ptr(u.Age),
 		Status:    util.NullableStringPtr(u.Status),
+		Version:   u.Version,
 	}
 }
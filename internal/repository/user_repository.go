@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"UserManagement/internal/model"
 )
@@ -10,7 +11,42 @@ import (
 type UserRepository interface {
 	CreateUserRepo(ctx context.Context, req model.CreateUserRequest) (model.User, error)
 	GetUserRepo(ctx context.Context, userID int64) (model.User, error)
+	GetUserByEmailRepo(ctx context.Context, email string) (model.User, error)
 	UpdateUserRepo(ctx context.Context, userID int64, req model.UpdateUserRequest) (model.User, error)
 	DeleteUserRepo(ctx context.Context, userID int64) (model.User, error)
 	ListUsersRepo(ctx context.Context) ([]model.User, error)
+	// ExportUsersRepo streams the users matching filter to fn in ID order
+	ExportUsersRepo(ctx context.Context, filter model.UserFilter, fn func(user model.User) error) error
+	// InTx runs fn against a repository bound to a single transaction
+	InTx(ctx context.Context, fn func(repo UserRepository) error) error
+}
+
+// WebhookRepository defines the interface for webhook subscriptions and their
+// delivery log
+type WebhookRepository interface {
+	CreateWebhook(ctx context.Context, req model.CreateWebhookRequest) (model.Webhook, error)
+	GetWebhook(ctx context.Context, webhookID int64) (model.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]model.Webhook, error)
+	ListActiveWebhooks(ctx context.Context) ([]model.Webhook, error)
+	UpdateWebhook(ctx context.Context, webhookID int64, req model.UpdateWebhookRequest) (model.Webhook, error)
+	DeleteWebhook(ctx context.Context, webhookID int64) error
+	RecordWebhookSuccess(ctx context.Context, webhookID int64) error
+	RecordWebhookFailure(ctx context.Context, webhookID int64, maxFailures int) (bool, error)
+
+	CreateDelivery(ctx context.Context, webhookID int64, event model.UserEvent, payload []byte) (bool, error)
+	GetDelivery(ctx context.Context, deliveryID int64) (model.WebhookDelivery, error)
+	ListDeliveries(ctx context.Context, webhookID int64, status string, limit int) ([]model.WebhookDelivery, error)
+	ClaimDueDeliveries(ctx context.Context, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error)
+	RecordAttempt(ctx context.Context, attempt model.DeliveryAttempt) error
+	Redeliver(ctx context.Context, deliveryID int64) (model.WebhookDelivery, error)
+}
+
+// IdempotencyRepository stores Idempotency-Key claims and the responses they
+// produced
+type IdempotencyRepository interface {
+	ClaimKey(ctx context.Context, scope, key, fingerprint string, expiresAt, staleBefore time.Time) (bool, error)
+	GetKey(ctx context.Context, scope, key string) (model.IdempotencyRecord, error)
+	CompleteKey(ctx context.Context, scope, key string, response model.StoredResponse) error
+	ReleaseKey(ctx context.Context, scope, key string) error
+	DeleteExpiredKeys(ctx context.Context) (int64, error)
 }
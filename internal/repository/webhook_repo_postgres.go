@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	sqlc "UserManagement/internal/db/sqlc"
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+	"UserManagement/internal/util"
+)
+
+// PostgresWebhookRepository is the PostgreSQL implementation of WebhookRepository
+type PostgresWebhookRepository struct {
+	queries *sqlc.Queries
+}
+
+// NewPostgresWebhookRepository creates a new instance of PostgresWebhookRepository
+func NewPostgresWebhookRepository(queries *sqlc.Queries) *PostgresWebhookRepository {
+	return &PostgresWebhookRepository{queries: queries}
+}
+
+func (r *PostgresWebhookRepository) CreateWebhook(ctx context.Context, req model.CreateWebhookRequest) (model.Webhook, error) {
+	webhook, err := r.queries.CreateWebhook(ctx, sqlc.CreateWebhookParams{
+		Url:        req.URL,
+		Secret:     req.Secret,
+		EventTypes: nonNil(req.EventTypes),
+	})
+	if err != nil {
+		return model.Webhook{}, err
+	}
+	return mapToModelWebhook(webhook), nil
+}
+
+func (r *PostgresWebhookRepository) GetWebhook(ctx context.Context, webhookID int64) (model.Webhook, error) {
+	webhook, err := r.queries.GetWebhook(ctx, webhookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Webhook{}, errs.ErrWebhookNotFound
+	}
+	if err != nil {
+		return model.Webhook{}, err
+	}
+	return mapToModelWebhook(webhook), nil
+}
+
+func (r *PostgresWebhookRepository) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	webhooks, err := r.queries.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mapToModelWebhooks(webhooks), nil
+}
+
+func (r *PostgresWebhookRepository) ListActiveWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	webhooks, err := r.queries.ListActiveWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mapToModelWebhooks(webhooks), nil
+}
+
+// UpdateWebhook applies the fields set in req. Re-activating a webhook resets
+// its failure count.
+func (r *PostgresWebhookRepository) UpdateWebhook(ctx context.Context, webhookID int64, req model.UpdateWebhookRequest) (model.Webhook, error) {
+	current, err := r.GetWebhook(ctx, webhookID)
+	if err != nil {
+		return model.Webhook{}, err
+	}
+	arg := sqlc.UpdateWebhookParams{
+		WebhookID:  webhookID,
+		Url:        current.URL,
+		Secret:     current.Secret,
+		EventTypes: current.EventTypes,
+		Active:     current.Active,
+	}
+	if req.URL != nil {
+		arg.Url = *req.URL
+	}
+	if req.Secret != nil {
+		arg.Secret = *req.Secret
+	}
+	if req.EventTypes != nil {
+		arg.EventTypes = nonNil(*req.EventTypes)
+	}
+	if req.Active != nil {
+		arg.Active = *req.Active
+	}
+	webhook, err := r.queries.UpdateWebhook(ctx, arg)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Webhook{}, errs.ErrWebhookNotFound
+	}
+	if err != nil {
+		return model.Webhook{}, err
+	}
+	return mapToModelWebhook(webhook), nil
+}
+
+func (r *PostgresWebhookRepository) DeleteWebhook(ctx context.Context, webhookID int64) error {
+	rows, err := r.queries.DeleteWebhook(ctx, webhookID)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errs.ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (r *PostgresWebhookRepository) RecordWebhookSuccess(ctx context.Context, webhookID int64) error {
+	return r.queries.RecordWebhookSuccess(ctx, webhookID)
+}
+
+// RecordWebhookFailure counts a failed delivery and reports whether the
+// webhook is still active afterwards.
+func (r *PostgresWebhookRepository) RecordWebhookFailure(ctx context.Context, webhookID int64, maxFailures int) (bool, error) {
+	active, err := r.queries.RecordWebhookFailure(ctx, sqlc.RecordWebhookFailureParams{
+		MaxFailures: int32(maxFailures),
+		WebhookID:   webhookID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, errs.ErrWebhookNotFound
+	}
+	return active, err
+}
+
+// CreateDelivery queues event for a webhook, unless it already is; created
+// reports which.
+func (r *PostgresWebhookRepository) CreateDelivery(ctx context.Context, webhookID int64, event model.UserEvent, payload []byte) (created bool, err error) {
+	rows, err := r.queries.CreateWebhookDelivery(ctx, sqlc.CreateWebhookDeliveryParams{
+		WebhookID: webhookID,
+		EventType: event.Type,
+		UserID:    event.UserID,
+		Sequence:  event.Sequence,
+		Payload:   payload,
+	})
+	return rows > 0, err
+}
+
+func (r *PostgresWebhookRepository) GetDelivery(ctx context.Context, deliveryID int64) (model.WebhookDelivery, error) {
+	delivery, err := r.queries.GetWebhookDelivery(ctx, deliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.WebhookDelivery{}, errs.ErrDeliveryNotFound
+	}
+	if err != nil {
+		return model.WebhookDelivery{}, err
+	}
+	return mapToModelDelivery(delivery), nil
+}
+
+// ListDeliveries returns the latest deliveries of a webhook, newest first. An
+// empty status matches every status.
+func (r *PostgresWebhookRepository) ListDeliveries(ctx context.Context, webhookID int64, status string, limit int) ([]model.WebhookDelivery, error) {
+	deliveries, err := r.queries.ListWebhookDeliveries(ctx, sqlc.ListWebhookDeliveriesParams{
+		WebhookID: webhookID,
+		Status:    sql.NullString{String: status, Valid: status != ""},
+		RowLimit:  int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mapToModelDeliveries(deliveries), nil
+}
+
+// ClaimDueDeliveries leases up to limit pending deliveries until leaseUntil.
+func (r *PostgresWebhookRepository) ClaimDueDeliveries(ctx context.Context, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error) {
+	deliveries, err := r.queries.ClaimDueWebhookDeliveries(ctx, sqlc.ClaimDueWebhookDeliveriesParams{
+		LeaseUntil: sql.NullTime{Time: leaseUntil, Valid: true},
+		RowLimit:   int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mapToModelDeliveries(deliveries), nil
+}
+
+func (r *PostgresWebhookRepository) RecordAttempt(ctx context.Context, attempt model.DeliveryAttempt) error {
+	arg := sqlc.RecordWebhookDeliveryAttemptParams{
+		DeliveryID: attempt.DeliveryID,
+		Status:     attempt.Status,
+	}
+	if attempt.ResponseStatus != nil {
+		arg.ResponseStatus = sql.NullInt32{Int32: *attempt.ResponseStatus, Valid: true}
+	}
+	if attempt.LastError != nil {
+		arg.LastError = sql.NullString{String: *attempt.LastError, Valid: true}
+	}
+	if attempt.NextAttemptAt != nil {
+		arg.NextAttemptAt = sql.NullTime{Time: *attempt.NextAttemptAt, Valid: true}
+	}
+	return r.queries.RecordWebhookDeliveryAttempt(ctx, arg)
+}
+
+// Redeliver puts a delivery back in the queue with a fresh set of attempts.
+func (r *PostgresWebhookRepository) Redeliver(ctx context.Context, deliveryID int64) (model.WebhookDelivery, error) {
+	delivery, err := r.queries.RedeliverWebhookDelivery(ctx, deliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.WebhookDelivery{}, errs.ErrDeliveryNotFound
+	}
+	if err != nil {
+		return model.WebhookDelivery{}, err
+	}
+	return mapToModelDelivery(delivery), nil
+}
+
+// nonNil keeps event_types NOT NULL in the database
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func mapToModelWebhook(w sqlc.Webhook) model.Webhook {
+	webhook := model.Webhook{
+		ID:                  w.WebhookID,
+		URL:                 w.Url,
+		Secret:              w.Secret,
+		EventTypes:          nonNil(w.EventTypes),
+		Active:              w.Active,
+		ConsecutiveFailures: w.ConsecutiveFailures,
+		CreatedAt:           w.CreatedAt,
+		UpdatedAt:           w.UpdatedAt,
+	}
+	if w.DisabledAt.Valid {
+		webhook.DisabledAt = &w.DisabledAt.Time
+	}
+	return webhook
+}
+
+func mapToModelWebhooks(webhooks []sqlc.Webhook) []model.Webhook {
+	result := make([]model.Webhook, 0, len(webhooks))
+	for _, w := range webhooks {
+		result = append(result, mapToModelWebhook(w))
+	}
+	return result
+}
+
+func mapToModelDelivery(d sqlc.WebhookDelivery) model.WebhookDelivery {
+	delivery := model.WebhookDelivery{
+		ID:             d.DeliveryID,
+		WebhookID:      d.WebhookID,
+		EventType:      d.EventType,
+		UserID:         d.UserID,
+		Sequence:       d.Sequence,
+		Payload:        d.Payload,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: util.NullableInt32Ptr(d.ResponseStatus),
+		LastError:      util.NullableStringPtr(d.LastError),
+		CreatedAt:      d.CreatedAt,
+		UpdatedAt:      d.UpdatedAt,
+	}
+	if d.NextAttemptAt.Valid {
+		delivery.NextAttemptAt = &d.NextAttemptAt.Time
+	}
+	return delivery
+}
+
+func mapToModelDeliveries(deliveries []sqlc.WebhookDelivery) []model.WebhookDelivery {
+	result := make([]model.WebhookDelivery, 0, len(deliveries))
+	for _, d := range deliveries {
+		result = append(result, mapToModelDelivery(d))
+	}
+	return result
+}
@@ -1,6 +1,8 @@
 package router
 
 import (
+	"crypto/subtle"
+	"expvar"
 	"net/http"
 
 	chi "github.com/go-chi/chi/v5"
@@ -12,17 +14,138 @@ type UserHandler interface {
 	CreateUser(w http.ResponseWriter, r *http.Request)
 	DeleteUser(w http.ResponseWriter, r *http.Request)
 	UpdateUser(w http.ResponseWriter, r *http.Request)
+	BatchUsers(w http.ResponseWriter, r *http.Request)
+	ExportUsers(w http.ResponseWriter, r *http.Request)
 }
 
-func NewRouter(uh UserHandler) *chi.Mux {
+type ProjectionHandler interface {
+	GetProjection(w http.ResponseWriter, r *http.Request)
+}
+
+type AdminHandler interface {
+	ListConnections(w http.ResponseWriter, r *http.Request)
+	DisconnectConnection(w http.ResponseWriter, r *http.Request)
+}
+
+type WebhookHandler interface {
+	CreateWebhook(w http.ResponseWriter, r *http.Request)
+	ListWebhooks(w http.ResponseWriter, r *http.Request)
+	GetWebhook(w http.ResponseWriter, r *http.Request)
+	UpdateWebhook(w http.ResponseWriter, r *http.Request)
+	DeleteWebhook(w http.ResponseWriter, r *http.Request)
+	ListDeliveries(w http.ResponseWriter, r *http.Request)
+	Redeliver(w http.ResponseWriter, r *http.Request)
+}
+
+type ImportHandler interface {
+	ImportUsers(w http.ResponseWriter, r *http.Request)
+	GetImport(w http.ResponseWriter, r *http.Request)
+	GetImportReport(w http.ResponseWriter, r *http.Request)
+	CancelImport(w http.ResponseWriter, r *http.Request)
+}
+
+type WebSocketHandler interface {
+	ServeWS(w http.ResponseWriter, r *http.Request)
+}
+
+type EventStreamHandler interface {
+	ServeSSE(w http.ResponseWriter, r *http.Request)
+}
+
+// Handlers are the handlers mounted by NewRouter. WebSocket is optional;
+// when set, /ws_users is served by the router so REST and WebSocket share a
+// single port.
+type Handlers struct {
+	Users       UserHandler
+	Projections ProjectionHandler
+	Admin       AdminHandler
+	Events      EventStreamHandler
+	Webhooks    WebhookHandler
+	Imports     ImportHandler
+	WebSocket   WebSocketHandler
+	// AdminToken is the bearer token the /admin, /webhooks and /debug/vars
+	// routes require; they aren't mounted without one
+	AdminToken string
+	// Identity, when set, wraps every route to work out who the caller is
+	Identity func(http.Handler) http.Handler
+	// Idempotency, when set, wraps the user writes to honour Idempotency-Key
+	Idempotency func(http.Handler) http.Handler
+}
+
+func NewRouter(h Handlers) *chi.Mux {
 	r := chi.NewRouter()
+	if h.Identity != nil {
+		r.Use(h.Identity)
+	}
+	// Only the user writes replay responses; other routes send bodies too
+	// large to fingerprint, or secrets that must not be stored
+	writes := r.With()
+	if h.Idempotency != nil {
+		writes = r.With(h.Idempotency)
+	}
 
 	// User management routes
-	r.Get("/users", uh.GetUsers)
-	r.Get("/users/{id}", uh.GetUserById)
-	r.Post("/users", uh.CreateUser)
-	r.Delete("/users/{id}", uh.DeleteUser)
-	r.Patch("/users/{id}", uh.UpdateUser)
+	r.Get("/users", h.Users.GetUsers)
+	r.Get("/users/export", h.Users.ExportUsers)
+	r.Get("/users/{id}", h.Users.GetUserById)
+	writes.Post("/users", h.Users.CreateUser)
+	writes.Post("/users:batch", h.Users.BatchUsers)
+	r.Delete("/users/{id}", h.Users.DeleteUser)
+	writes.Patch("/users/{id}", h.Users.UpdateUser)
+
+	// Bulk imports running in the background
+	r.Post("/users/import", h.Imports.ImportUsers)
+	r.Get("/imports/{id}", h.Imports.GetImport)
+	r.Get("/imports/{id}/report", h.Imports.GetImportReport)
+	r.Delete("/imports/{id}", h.Imports.CancelImport)
+
+	// Read models built from the event stream
+	r.Get("/projections/{name}", h.Projections.GetProjection)
+
+	// User events as Server-Sent Events
+	r.Get("/events/users", h.Events.ServeSSE)
+
+	// For operators only
+	if h.AdminToken != "" {
+		r.Group(func(r chi.Router) {
+			r.Use(requireToken(h.AdminToken))
+			// WebSocket connections on this instance
+			r.Get("/admin/connections", h.Admin.ListConnections)
+			r.Delete("/admin/connections/{id}", h.Admin.DisconnectConnection)
+
+			// Outbound webhooks and their delivery log
+			r.Post("/webhooks", h.Webhooks.CreateWebhook)
+			r.Get("/webhooks", h.Webhooks.ListWebhooks)
+			r.Get("/webhooks/{id}", h.Webhooks.GetWebhook)
+			r.Patch("/webhooks/{id}", h.Webhooks.UpdateWebhook)
+			r.Delete("/webhooks/{id}", h.Webhooks.DeleteWebhook)
+			r.Get("/webhooks/{id}/deliveries", h.Webhooks.ListDeliveries)
+			r.Post("/webhooks/{id}/deliveries/{deliveryID}/redeliver", h.Webhooks.Redeliver)
+
+			// Runtime metrics
+			r.Handle("/debug/vars", expvar.Handler())
+		})
+	}
+
+	if h.WebSocket != nil {
+		r.Get("/ws_users", h.WebSocket.ServeWS)
+	}
 
 	return r
 }
+
+// requireToken rejects requests without "Authorization: Bearer <token>".
+func requireToken(token string) func(http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, want) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
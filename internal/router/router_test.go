@@ -0,0 +1,126 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stub answers every route with 200 OK.
+type stub struct{}
+
+func (stub) ok(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }
+
+func (s stub) GetUsers(w http.ResponseWriter, r *http.Request)             { s.ok(w, r) }
+func (s stub) GetUserById(w http.ResponseWriter, r *http.Request)          { s.ok(w, r) }
+func (s stub) CreateUser(w http.ResponseWriter, r *http.Request)           { s.ok(w, r) }
+func (s stub) DeleteUser(w http.ResponseWriter, r *http.Request)           { s.ok(w, r) }
+func (s stub) UpdateUser(w http.ResponseWriter, r *http.Request)           { s.ok(w, r) }
+func (s stub) BatchUsers(w http.ResponseWriter, r *http.Request)           { s.ok(w, r) }
+func (s stub) ExportUsers(w http.ResponseWriter, r *http.Request)          { s.ok(w, r) }
+func (s stub) GetProjection(w http.ResponseWriter, r *http.Request)        { s.ok(w, r) }
+func (s stub) ListConnections(w http.ResponseWriter, r *http.Request)      { s.ok(w, r) }
+func (s stub) DisconnectConnection(w http.ResponseWriter, r *http.Request) { s.ok(w, r) }
+func (s stub) CreateWebhook(w http.ResponseWriter, r *http.Request)        { s.ok(w, r) }
+func (s stub) ListWebhooks(w http.ResponseWriter, r *http.Request)         { s.ok(w, r) }
+func (s stub) GetWebhook(w http.ResponseWriter, r *http.Request)           { s.ok(w, r) }
+func (s stub) UpdateWebhook(w http.ResponseWriter, r *http.Request)        { s.ok(w, r) }
+func (s stub) DeleteWebhook(w http.ResponseWriter, r *http.Request)        { s.ok(w, r) }
+func (s stub) ListDeliveries(w http.ResponseWriter, r *http.Request)       { s.ok(w, r) }
+func (s stub) Redeliver(w http.ResponseWriter, r *http.Request)            { s.ok(w, r) }
+func (s stub) ImportUsers(w http.ResponseWriter, r *http.Request)          { s.ok(w, r) }
+func (s stub) GetImport(w http.ResponseWriter, r *http.Request)            { s.ok(w, r) }
+func (s stub) GetImportReport(w http.ResponseWriter, r *http.Request)      { s.ok(w, r) }
+func (s stub) CancelImport(w http.ResponseWriter, r *http.Request)         { s.ok(w, r) }
+func (s stub) ServeSSE(w http.ResponseWriter, r *http.Request)             { s.ok(w, r) }
+
+func testHandlers(adminToken string) Handlers {
+	return Handlers{
+		Users:       stub{},
+		Projections: stub{},
+		Admin:       stub{},
+		Events:      stub{},
+		Webhooks:    stub{},
+		Imports:     stub{},
+		AdminToken:  adminToken,
+	}
+}
+
+func newTestRouter(adminToken string) http.Handler {
+	return NewRouter(testHandlers(adminToken))
+}
+
+func TestAdminRoutesRequireToken(t *testing.T) {
+	r := newTestRouter("s3cret")
+	for _, tc := range []struct {
+		name, method, path, auth string
+		want                     int
+	}{
+		{"no token", http.MethodGet, "/admin/connections", "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/admin/connections", "Bearer nope", http.StatusUnauthorized},
+		{"not a bearer token", http.MethodGet, "/admin/connections", "s3cret", http.StatusUnauthorized},
+		{"disconnect without token", http.MethodDelete, "/admin/connections/c1", "", http.StatusUnauthorized},
+		{"list", http.MethodGet, "/admin/connections", "Bearer s3cret", http.StatusOK},
+		{"disconnect", http.MethodDelete, "/admin/connections/c1", "Bearer s3cret", http.StatusOK},
+		{"webhooks without token", http.MethodGet, "/webhooks", "", http.StatusUnauthorized},
+		{"create webhook without token", http.MethodPost, "/webhooks", "", http.StatusUnauthorized},
+		{"redeliver without token", http.MethodPost, "/webhooks/1/deliveries/2/redeliver", "", http.StatusUnauthorized},
+		{"webhooks", http.MethodGet, "/webhooks", "Bearer s3cret", http.StatusOK},
+		{"metrics without token", http.MethodGet, "/debug/vars", "", http.StatusUnauthorized},
+		{"metrics", http.MethodGet, "/debug/vars", "Bearer s3cret", http.StatusOK},
+		{"redeliver", http.MethodPost, "/webhooks/1/deliveries/2/redeliver", "Bearer s3cret", http.StatusOK},
+		{"other routes need no token", http.MethodGet, "/users", "", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			require.Equal(t, tc.want, rec.Code)
+		})
+	}
+}
+
+func TestAdminRoutesOffWithoutToken(t *testing.T) {
+	for _, path := range []string{"/admin/connections", "/webhooks", "/debug/vars"} {
+		rec := httptest.NewRecorder()
+		newTestRouter("").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusNotFound, rec.Code, path)
+	}
+}
+
+func TestIdempotencyOnlyOnUserWrites(t *testing.T) {
+	h := testHandlers("s3cret")
+	h.Idempotency = func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Idempotency", "checked")
+			next.ServeHTTP(w, r)
+		})
+	}
+	r := NewRouter(h)
+	for _, tc := range []struct {
+		method, path string
+		want         bool
+	}{
+		{http.MethodPost, "/users", true},
+		{http.MethodPatch, "/users/1", true},
+		{http.MethodPost, "/users:batch", true},
+		{http.MethodPost, "/users/import", false},
+		{http.MethodPost, "/webhooks", false},
+		{http.MethodPatch, "/webhooks/1", false},
+		{http.MethodGet, "/users", false},
+	} {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("Authorization", "Bearer s3cret")
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, tc.want, rec.Header().Get("X-Idempotency") != "")
+		})
+	}
+}
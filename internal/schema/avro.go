@@ -0,0 +1,119 @@
+package schema
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"errors"
+
+	"UserManagement/internal/model"
+)
+
+//go:embed user_event.avsc
+var userEventAvro string
+
+var errInvalidAvro = errors.New("invalid avro payload")
+
+// marshalAvro encodes the event with the Avro binary encoding of user_event.avsc.
+func marshalAvro(event model.UserEvent) []byte {
+	var out []byte
+	out = appendAvroString(out, event.Type)
+	out = binary.AppendVarint(out, event.UserID)
+	out = binary.AppendVarint(out, event.Sequence)
+
+	out = binary.AppendVarint(out, event.User.ID)
+	out = appendAvroString(out, event.User.FirstName)
+	out = appendAvroString(out, event.User.LastName)
+	out = appendAvroString(out, event.User.Email)
+	out = appendAvroOptionalString(out, event.User.Phone)
+	if event.User.Age == nil {
+		out = binary.AppendVarint(out, 0)
+	} else {
+		out = binary.AppendVarint(out, 1)
+		out = binary.AppendVarint(out, int64(*event.User.Age))
+	}
+	out = appendAvroOptionalString(out, event.User.Status)
+	out = binary.AppendVarint(out, event.User.Version)
+	return out
+}
+
+func unmarshalAvro(data []byte) (model.UserEvent, error) {
+	d := avroDecoder{data: data}
+	var event model.UserEvent
+	event.Type = d.string()
+	event.UserID = d.long()
+	event.Sequence = d.long()
+
+	event.User.ID = d.long()
+	event.User.FirstName = d.string()
+	event.User.LastName = d.string()
+	event.User.Email = d.string()
+	event.User.Phone = d.optionalString()
+	if d.long() == 1 {
+		age := int32(d.long())
+		event.User.Age = &age
+	}
+	event.User.Status = d.optionalString()
+	event.User.Version = d.long()
+
+	if d.err != nil {
+		return model.UserEvent{}, d.err
+	}
+	return event, nil
+}
+
+// Avro ints and longs are zig-zag varints, which is what encoding/binary's
+// Varint functions implement.
+func appendAvroString(b []byte, s string) []byte {
+	b = binary.AppendVarint(b, int64(len(s)))
+	return append(b, s...)
+}
+
+// appendAvroOptionalString encodes a ["null", "string"] union.
+func appendAvroOptionalString(b []byte, s *string) []byte {
+	if s == nil {
+		return binary.AppendVarint(b, 0)
+	}
+	b = binary.AppendVarint(b, 1)
+	return appendAvroString(b, *s)
+}
+
+// avroDecoder reads primitives in order and records the first error.
+type avroDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *avroDecoder) long() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errInvalidAvro
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *avroDecoder) string() string {
+	n := d.long()
+	if d.err != nil {
+		return ""
+	}
+	if n < 0 || int64(len(d.data)) < n {
+		d.err = errInvalidAvro
+		return ""
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+func (d *avroDecoder) optionalString() *string {
+	if d.long() != 1 {
+		return nil
+	}
+	s := d.string()
+	return &s
+}
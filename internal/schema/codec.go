@@ -0,0 +1,155 @@
+package schema
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"UserManagement/internal/model"
+)
+
+// Supported event encodings
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+	EncodingAvro     = "avro"
+)
+
+// Serializer turns a user event into a Kafka message value.
+type Serializer interface {
+	Serialize(event model.UserEvent) ([]byte, error)
+}
+
+// NewSerializer returns the serializer for the encoding. Protobuf and Avro
+// register the event schema under the subject and frame every message with
+// the resulting schema ID. JSON keeps the plain user body used before typed
+// schemas existed.
+func NewSerializer(encoding string, registry Registry, subject string) (Serializer, error) {
+	switch encoding {
+	case "", EncodingJSON:
+		return jsonSerializer{}, nil
+	case EncodingProtobuf:
+		id, err := registry.Register(subject, TypeProtobuf, userEventProto)
+		if err != nil {
+			return nil, fmt.Errorf("register protobuf schema: %w", err)
+		}
+		return protobufSerializer{schemaID: id}, nil
+	case EncodingAvro:
+		id, err := registry.Register(subject, TypeAvro, userEventAvro)
+		if err != nil {
+			return nil, fmt.Errorf("register avro schema: %w", err)
+		}
+		return avroSerializer{schemaID: id}, nil
+	default:
+		return nil, fmt.Errorf("unsupported event encoding %q", encoding)
+	}
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Serialize(event model.UserEvent) ([]byte, error) {
+	return json.Marshal(event.User)
+}
+
+type protobufSerializer struct {
+	schemaID int
+}
+
+func (s protobufSerializer) Serialize(event model.UserEvent) ([]byte, error) {
+	// A single zero byte is the message-index shorthand for the first message in the schema
+	payload := append([]byte{0}, marshalProtobuf(event)...)
+	return frame(s.schemaID, payload), nil
+}
+
+type avroSerializer struct {
+	schemaID int
+}
+
+func (s avroSerializer) Serialize(event model.UserEvent) ([]byte, error) {
+	return frame(s.schemaID, marshalAvro(event)), nil
+}
+
+// Deserializer decodes message values according to their framing: wire-format
+// messages are decoded with the schema their ID points to, anything else is
+// read as a plain JSON user.
+type Deserializer struct {
+	registry Registry
+	mu       sync.RWMutex
+	types    map[int]string
+}
+
+func NewDeserializer(registry Registry) *Deserializer {
+	return &Deserializer{registry: registry, types: make(map[int]string)}
+}
+
+// Deserialize decodes a message value. For plain JSON only the user is
+// known, so Type and Sequence are left for the caller to fill from headers.
+func (d *Deserializer) Deserialize(data []byte) (model.UserEvent, error) {
+	if !IsFramed(data) {
+		var user model.User
+		if err := json.Unmarshal(data, &user); err != nil {
+			return model.UserEvent{}, err
+		}
+		return model.UserEvent{UserID: user.ID, User: user}, nil
+	}
+
+	id, payload, err := unframe(data)
+	if err != nil {
+		return model.UserEvent{}, err
+	}
+	schemaType, err := d.schemaType(id)
+	if err != nil {
+		return model.UserEvent{}, err
+	}
+	switch schemaType {
+	case TypeProtobuf:
+		payload, err = skipMessageIndexes(payload)
+		if err != nil {
+			return model.UserEvent{}, err
+		}
+		return unmarshalProtobuf(payload)
+	case TypeAvro:
+		return unmarshalAvro(payload)
+	default:
+		return model.UserEvent{}, fmt.Errorf("unsupported schema type %q for schema %d", schemaType, id)
+	}
+}
+
+func (d *Deserializer) schemaType(id int) (string, error) {
+	d.mu.RLock()
+	t, ok := d.types[id]
+	d.mu.RUnlock()
+	if ok {
+		return t, nil
+	}
+	if d.registry == nil {
+		return "", fmt.Errorf("no schema registry to resolve schema %d", id)
+	}
+	s, err := d.registry.Lookup(id)
+	if err != nil {
+		return "", fmt.Errorf("lookup schema %d: %w", id, err)
+	}
+	d.mu.Lock()
+	d.types[id] = s.Type
+	d.mu.Unlock()
+	return s.Type, nil
+}
+
+// skipMessageIndexes drops the protobuf message-index array that follows the
+// wire-format header. Only UserEvent is ever produced, so the indexes are not
+// needed to pick the message type.
+func skipMessageIndexes(data []byte) ([]byte, error) {
+	count, n := binary.Varint(data)
+	if n <= 0 || count < 0 {
+		return nil, errInvalidProtobuf
+	}
+	data = data[n:]
+	for i := int64(0); i < count; i++ {
+		if _, n = binary.Varint(data); n <= 0 {
+			return nil, errInvalidProtobuf
+		}
+		data = data[n:]
+	}
+	return data, nil
+}
@@ -0,0 +1,112 @@
+package schema
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/model"
+	"UserManagement/internal/util"
+)
+
+func randomEvent() model.UserEvent {
+	phone := util.RandomPhone()
+	age := util.RandomAge()
+	status := util.RandomStatus()
+	user := model.User{
+		ID:        int64(util.RandomInt(1, 1000)),
+		FirstName: util.RandomName(),
+		LastName:  util.RandomName(),
+		Email:     util.RandomEmail(),
+		Phone:     &phone,
+		Age:       &age,
+		Status:    &status,
+		Version:   3,
+	}
+	return model.UserEvent{
+		Type:     model.EventUserUpdated,
+		UserID:   user.ID,
+		Sequence: user.Version,
+		User:     user,
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, encoding := range []string{EncodingProtobuf, EncodingAvro} {
+		t.Run(encoding, func(t *testing.T) {
+			registry, err := NewFileRegistry(filepath.Join(t.TempDir(), "registry.json"))
+			require.NoError(t, err)
+
+			serializer, err := NewSerializer(encoding, registry, SubjectFor("user_topic"))
+			require.NoError(t, err)
+
+			event := randomEvent()
+			data, err := serializer.Serialize(event)
+			require.NoError(t, err)
+			require.True(t, IsFramed(data))
+
+			decoded, err := NewDeserializer(registry).Deserialize(data)
+			require.NoError(t, err)
+			require.Equal(t, event, decoded)
+		})
+	}
+}
+
+func TestRoundTripOptionalFields(t *testing.T) {
+	registry, err := NewFileRegistry(filepath.Join(t.TempDir(), "registry.json"))
+	require.NoError(t, err)
+
+	event := randomEvent()
+	event.User.Phone, event.User.Age, event.User.Status = nil, nil, nil
+	for _, encoding := range []string{EncodingProtobuf, EncodingAvro} {
+		serializer, err := NewSerializer(encoding, registry, SubjectFor("user_topic"))
+		require.NoError(t, err)
+		data, err := serializer.Serialize(event)
+		require.NoError(t, err)
+
+		decoded, err := NewDeserializer(registry).Deserialize(data)
+		require.NoError(t, err)
+		require.Equal(t, event, decoded)
+	}
+}
+
+func TestDeserializePlainJSON(t *testing.T) {
+	event := randomEvent()
+	serializer, err := NewSerializer(EncodingJSON, nil, "")
+	require.NoError(t, err)
+	data, err := serializer.Serialize(event)
+	require.NoError(t, err)
+	require.False(t, IsFramed(data))
+
+	decoded, err := NewDeserializer(nil).Deserialize(data)
+	require.NoError(t, err)
+	require.Equal(t, event.User, decoded.User)
+	require.Empty(t, decoded.Type)
+}
+
+func TestFileRegistryReusesIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	registry, err := NewFileRegistry(path)
+	require.NoError(t, err)
+
+	protoID, err := registry.Register("user_topic-value", TypeProtobuf, userEventProto)
+	require.NoError(t, err)
+	avroID, err := registry.Register("user_topic-value", TypeAvro, userEventAvro)
+	require.NoError(t, err)
+	require.NotEqual(t, protoID, avroID)
+
+	// A fresh registry on the same file sees the persisted schemas
+	reopened, err := NewFileRegistry(path)
+	require.NoError(t, err)
+	id, err := reopened.Register("user_topic-value", TypeProtobuf, userEventProto)
+	require.NoError(t, err)
+	require.Equal(t, protoID, id)
+
+	s, err := reopened.Lookup(avroID)
+	require.NoError(t, err)
+	require.Equal(t, TypeAvro, s.Type)
+
+	_, err = reopened.Lookup(99)
+	require.ErrorIs(t, err, ErrSchemaNotFound)
+}
@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// FileRegistry is a Registry backed by a single JSON file, for local
+// development and tests.
+type FileRegistry struct {
+	mu      sync.Mutex
+	path    string
+	schemas []Schema
+}
+
+// NewFileRegistry loads the registry at path. A missing file is treated as
+// an empty registry and is created on the first Register.
+func NewFileRegistry(path string) (*FileRegistry, error) {
+	r := &FileRegistry{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &r.schemas); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *FileRegistry) Register(subject, schemaType, definition string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	nextID := 1
+	for _, s := range r.schemas {
+		if s.Subject == subject && s.Type == schemaType && s.Definition == definition {
+			return s.ID, nil
+		}
+		if s.ID >= nextID {
+			nextID = s.ID + 1
+		}
+	}
+	r.schemas = append(r.schemas, Schema{
+		ID:         nextID,
+		Subject:    subject,
+		Type:       schemaType,
+		Definition: definition,
+	})
+	if err := r.save(); err != nil {
+		r.schemas = r.schemas[:len(r.schemas)-1]
+		return 0, err
+	}
+	return nextID, nil
+}
+
+func (r *FileRegistry) Lookup(id int) (Schema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.schemas {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return Schema{}, ErrSchemaNotFound
+}
+
+func (r *FileRegistry) save() error {
+	data, err := json.MarshalIndent(r.schemas, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, data, 0o644)
+}
+
+var _ Registry = (*FileRegistry)(nil)
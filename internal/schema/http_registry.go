@@ -0,0 +1,99 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPRegistry talks to a Confluent-compatible schema registry over REST.
+// Lookups are cached since schema IDs are immutable.
+type HTTPRegistry struct {
+	baseURL string
+	client  *http.Client
+	mu      sync.RWMutex
+	cache   map[int]Schema
+}
+
+func NewHTTPRegistry(baseURL string) *HTTPRegistry {
+	return &HTTPRegistry{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 5 * time.Second},
+		cache:   make(map[int]Schema),
+	}
+}
+
+func (r *HTTPRegistry) Register(subject, schemaType, definition string) (int, error) {
+	body, err := json.Marshal(map[string]string{
+		"schema":     definition,
+		"schemaType": schemaType,
+	})
+	if err != nil {
+		return 0, err
+	}
+	endpoint := fmt.Sprintf("%s/subjects/%s/versions", r.baseURL, url.PathEscape(subject))
+	resp, err := r.client.Post(endpoint, "application/vnd.schemaregistry.v1+json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("schema registry: register %s: unexpected status %d", subject, resp.StatusCode)
+	}
+
+	var out struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	r.cache[out.ID] = Schema{ID: out.ID, Subject: subject, Type: schemaType, Definition: definition}
+	r.mu.Unlock()
+	return out.ID, nil
+}
+
+func (r *HTTPRegistry) Lookup(id int) (Schema, error) {
+	r.mu.RLock()
+	s, ok := r.cache[id]
+	r.mu.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	resp, err := r.client.Get(fmt.Sprintf("%s/schemas/ids/%d", r.baseURL, id))
+	if err != nil {
+		return Schema{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Schema{}, ErrSchemaNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Schema{}, fmt.Errorf("schema registry: lookup %d: unexpected status %d", id, resp.StatusCode)
+	}
+
+	var out struct {
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Schema{}, err
+	}
+	// The registry omits schemaType for Avro, its default
+	if out.SchemaType == "" {
+		out.SchemaType = TypeAvro
+	}
+	s = Schema{ID: id, Type: out.SchemaType, Definition: out.Schema}
+	r.mu.Lock()
+	r.cache[id] = s
+	r.mu.Unlock()
+	return s, nil
+}
+
+var _ Registry = (*HTTPRegistry)(nil)
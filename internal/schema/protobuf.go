@@ -0,0 +1,167 @@
+package schema
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"UserManagement/internal/model"
+)
+
+//go:embed user_event.proto
+var userEventProto string
+
+var errInvalidProtobuf = errors.New("invalid protobuf payload")
+
+// Field numbers from user_event.proto
+const (
+	pbEventType     = 1
+	pbEventUserID   = 2
+	pbEventSequence = 3
+	pbEventUser     = 4
+
+	pbUserID        = 1
+	pbUserFirstName = 2
+	pbUserLastName  = 3
+	pbUserEmail     = 4
+	pbUserPhone     = 5
+	pbUserAge       = 6
+	pbUserStatus    = 7
+	pbUserVersion   = 8
+)
+
+// marshalProtobuf encodes the event as the UserEvent message in user_event.proto.
+func marshalProtobuf(event model.UserEvent) []byte {
+	var user []byte
+	user = appendInt64(user, pbUserID, event.User.ID)
+	user = appendString(user, pbUserFirstName, event.User.FirstName)
+	user = appendString(user, pbUserLastName, event.User.LastName)
+	user = appendString(user, pbUserEmail, event.User.Email)
+	if event.User.Phone != nil {
+		user = protowire.AppendTag(user, pbUserPhone, protowire.BytesType)
+		user = protowire.AppendString(user, *event.User.Phone)
+	}
+	if event.User.Age != nil {
+		user = protowire.AppendTag(user, pbUserAge, protowire.VarintType)
+		user = protowire.AppendVarint(user, uint64(int64(*event.User.Age)))
+	}
+	if event.User.Status != nil {
+		user = protowire.AppendTag(user, pbUserStatus, protowire.BytesType)
+		user = protowire.AppendString(user, *event.User.Status)
+	}
+	user = appendInt64(user, pbUserVersion, event.User.Version)
+
+	var out []byte
+	out = appendString(out, pbEventType, event.Type)
+	out = appendInt64(out, pbEventUserID, event.UserID)
+	out = appendInt64(out, pbEventSequence, event.Sequence)
+	out = protowire.AppendTag(out, pbEventUser, protowire.BytesType)
+	out = protowire.AppendBytes(out, user)
+	return out
+}
+
+func unmarshalProtobuf(data []byte) (model.UserEvent, error) {
+	var event model.UserEvent
+	err := walkFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case pbEventType:
+			event.Type = string(b)
+		case pbEventUserID:
+			event.UserID = int64(v)
+		case pbEventSequence:
+			event.Sequence = int64(v)
+		case pbEventUser:
+			user, err := unmarshalProtobufUser(b)
+			if err != nil {
+				return err
+			}
+			event.User = user
+		}
+		return nil
+	})
+	return event, err
+}
+
+func unmarshalProtobufUser(data []byte) (model.User, error) {
+	var user model.User
+	err := walkFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case pbUserID:
+			user.ID = int64(v)
+		case pbUserFirstName:
+			user.FirstName = string(b)
+		case pbUserLastName:
+			user.LastName = string(b)
+		case pbUserEmail:
+			user.Email = string(b)
+		case pbUserPhone:
+			phone := string(b)
+			user.Phone = &phone
+		case pbUserAge:
+			age := int32(v)
+			user.Age = &age
+		case pbUserStatus:
+			status := string(b)
+			user.Status = &status
+		case pbUserVersion:
+			user.Version = int64(v)
+		}
+		return nil
+	})
+	return user, err
+}
+
+// walkFields calls fn for every varint and length-delimited field in data,
+// skipping any other wire types so newer schemas stay readable.
+func walkFields(data []byte, fn func(num protowire.Number, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return errInvalidProtobuf
+		}
+		data = data[n:]
+
+		var v uint64
+		var b []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return errInvalidProtobuf
+			}
+			data = data[n:]
+			continue
+		}
+		if n < 0 {
+			return errInvalidProtobuf
+		}
+		data = data[n:]
+		if err := fn(num, v, b); err != nil {
+			return fmt.Errorf("field %d: %w", num, err)
+		}
+	}
+	return nil
+}
+
+// appendInt64 and appendString skip proto3 default values, as generated code does.
+func appendInt64(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
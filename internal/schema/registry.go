@@ -0,0 +1,40 @@
+package schema
+
+import "errors"
+
+// Schema types as named by the Confluent schema registry
+const (
+	TypeProtobuf = "PROTOBUF"
+	TypeAvro     = "AVRO"
+)
+
+var ErrSchemaNotFound = errors.New("schema not found")
+
+// Schema is a registered schema definition
+type Schema struct {
+	ID         int    `json:"id"`
+	Subject    string `json:"subject"`
+	Type       string `json:"schemaType"`
+	Definition string `json:"schema"`
+}
+
+// Registry is a schema registry client. Register returns the existing ID when
+// an identical schema is already registered under the subject.
+type Registry interface {
+	Register(subject, schemaType, definition string) (int, error)
+	Lookup(id int) (Schema, error)
+}
+
+// SubjectFor returns the subject name for a topic's values (TopicNameStrategy).
+func SubjectFor(topic string) string {
+	return topic + "-value"
+}
+
+// NewRegistry returns an HTTP registry client when url is set, otherwise a
+// file-based registry at path.
+func NewRegistry(url, path string) (Registry, error) {
+	if url != "" {
+		return NewHTTPRegistry(url), nil
+	}
+	return NewFileRegistry(path)
+}
@@ -0,0 +1,36 @@
+package schema
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// magicByte prefixes every message in the Confluent wire format, followed by
+// a 4-byte big-endian schema ID.
+const magicByte = 0x0
+
+const headerSize = 5
+
+var ErrNotFramed = errors.New("message is not in Confluent wire format")
+
+// IsFramed reports whether data starts with a Confluent wire-format header.
+// JSON payloads always start with '{', so they never match.
+func IsFramed(data []byte) bool {
+	return len(data) >= headerSize && data[0] == magicByte
+}
+
+// frame prepends the wire-format header to the payload.
+func frame(schemaID int, payload []byte) []byte {
+	out := make([]byte, headerSize, headerSize+len(payload))
+	out[0] = magicByte
+	binary.BigEndian.PutUint32(out[1:], uint32(schemaID))
+	return append(out, payload...)
+}
+
+// unframe splits a wire-format message into its schema ID and payload.
+func unframe(data []byte) (int, []byte, error) {
+	if !IsFramed(data) {
+		return 0, nil, ErrNotFramed
+	}
+	return int(binary.BigEndian.Uint32(data[1:headerSize])), data[headerSize:], nil
+}
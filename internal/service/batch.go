@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+	"UserManagement/internal/repository"
+)
+
+// batchTimeout bounds the transaction of an atomic batch
+const batchTimeout = 30 * time.Second
+
+// Batch applies a batch of operations. Atomic batches run in one transaction
+// and publish their events once it commits; best-effort batches go through
+// the write workers like single writes, so operations on one user keep their
+// order while different users are written in parallel.
+func (s *UserService) Batch(ctx context.Context, cmd model.BatchUsers) (model.BatchResult, error) {
+	if cmd.Mode == model.BatchAtomic {
+		// The transaction runs while it holds the worker of every user it
+		// touches, so its events are published in order with their other
+		// writes
+		keys := make([]uint64, len(cmd.Operations))
+		for i, op := range cmd.Operations {
+			keys[i] = opKey(op)
+		}
+		return writeAll(ctx, s, keys, func(ctx context.Context) (model.BatchResult, error) {
+			return s.atomicBatch(ctx, cmd.Operations)
+		})
+	}
+	return s.bestEffortBatch(ctx, cmd.Operations), nil
+}
+
+func (s *UserService) atomicBatch(ctx context.Context, ops []model.BatchOperation) (model.BatchResult, error) {
+	result := newBatchResult(model.BatchAtomic, ops)
+	for i, op := range ops {
+		if err := s.validateOp(op); err != nil {
+			fail(&result.Results[i], err)
+		}
+	}
+	countResults(&result)
+	if result.Failed > 0 {
+		return result, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, batchTimeout)
+	defer cancel()
+	var events []model.UserEvent
+	err := s.repo.InTx(ctx, func(repo repository.UserRepository) error {
+		for i, op := range ops {
+			user, err := applyOp(ctx, repo, op)
+			if err != nil {
+				fail(&result.Results[i], err)
+				return err
+			}
+			succeed(&result.Results[i], user)
+			events = append(events, eventFor(op, user))
+		}
+		return nil
+	})
+	if err != nil {
+		for i := range result.Results {
+			if result.Results[i].Status == model.BatchItemOK {
+				result.Results[i].Status = model.BatchItemRolledBack
+				result.Results[i].User = nil
+			}
+		}
+		// A failed commit isn't down to any one operation
+		countResults(&result)
+		if result.Failed == 0 {
+			return model.BatchResult{}, err
+		}
+		return result, nil
+	}
+
+	// Publish a message to Kafka for each change, now that they are committed
+	for _, event := range events {
+		s.notifyEvent(event.Type, event.User, event.Sequence)
+	}
+	countResults(&result)
+	return result, nil
+}
+
+func (s *UserService) bestEffortBatch(ctx context.Context, ops []model.BatchOperation) model.BatchResult {
+	result := newBatchResult(model.BatchBestEffort, ops)
+
+	// One goroutine per worker, each sending its operations in order, so a
+	// batch never has more than one write queued per worker
+	byShard := make(map[uint64][]int)
+	for i, op := range ops {
+		shard := opKey(op) % uint64(len(s.shards))
+		byShard[shard] = append(byShard[shard], i)
+	}
+	var wg sync.WaitGroup
+	for _, indexes := range byShard {
+		wg.Add(1)
+		go func(indexes []int) {
+			defer wg.Done()
+			for _, i := range indexes {
+				user, err := s.runOp(ctx, ops[i])
+				if err != nil {
+					fail(&result.Results[i], err)
+				} else {
+					succeed(&result.Results[i], user)
+				}
+			}
+		}(indexes)
+	}
+	wg.Wait()
+
+	countResults(&result)
+	return result
+}
+
+// runOp applies a best-effort operation as the equivalent single command.
+func (s *UserService) runOp(ctx context.Context, op model.BatchOperation) (model.User, error) {
+	if err := op.Check(); err != nil {
+		return model.User{}, fmt.Errorf("%w: %v", errs.ErrInvalidInput, err)
+	}
+	return write(ctx, s, opKey(op), func(ctx context.Context) (model.User, error) {
+		switch op.Op {
+		case model.BatchCreate:
+			return s.CreateUser(ctx, *op.Create)
+		case model.BatchUpdate:
+			return s.UpdateUser(ctx, op.UserID, *op.Update)
+		default:
+			return s.DeleteUser(ctx, op.UserID)
+		}
+	})
+}
+
+func (s *UserService) validateOp(op model.BatchOperation) error {
+	err := op.Check()
+	if err == nil {
+		switch op.Op {
+		case model.BatchCreate:
+			err = s.v.ValidateCreateUser(op.Create.FirstName, op.Create.LastName, op.Create.Email)
+		case model.BatchUpdate:
+			err = s.v.ValidateUpdateUser(op.Update.FirstName, op.Update.LastName, op.Update.Email)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrInvalidInput, err)
+	}
+	return nil
+}
+
+// applyOp runs an operation against repo without publishing its event.
+func applyOp(ctx context.Context, repo repository.UserRepository, op model.BatchOperation) (model.User, error) {
+	switch op.Op {
+	case model.BatchCreate:
+		return repo.CreateUserRepo(ctx, *op.Create)
+	case model.BatchUpdate:
+		return repo.UpdateUserRepo(ctx, op.UserID, *op.Update)
+	default:
+		return repo.DeleteUserRepo(ctx, op.UserID)
+	}
+}
+
+// eventFor is the event announcing op's change to user. As in DeleteUser, a
+// deleted row carries its last version, so the delete is the next step.
+func eventFor(op model.BatchOperation, user model.User) model.UserEvent {
+	event := model.UserEvent{UserID: user.ID, Sequence: user.Version, User: user}
+	switch op.Op {
+	case model.BatchCreate:
+		event.Type = model.EventUserCreated
+	case model.BatchUpdate:
+		event.Type = model.EventUserUpdated
+	default:
+		event.Type = model.EventUserDeleted
+		event.Sequence++
+	}
+	return event
+}
+
+// opKey picks the worker for an operation, as the single commands do
+func opKey(op model.BatchOperation) uint64 {
+	if op.Create != nil {
+		return emailKey(op.Create.Email)
+	}
+	return uint64(op.UserID)
+}
+
+func newBatchResult(mode string, ops []model.BatchOperation) model.BatchResult {
+	result := model.BatchResult{Mode: mode, Results: make([]model.BatchItemResult, len(ops))}
+	for i, op := range ops {
+		result.Results[i] = model.BatchItemResult{Index: i, Op: op.Op, Status: model.BatchItemSkipped}
+	}
+	return result
+}
+
+func succeed(item *model.BatchItemResult, user model.User) {
+	item.Status = model.BatchItemOK
+	item.User = &user
+}
+
+func fail(item *model.BatchItemResult, err error) {
+	item.Status = model.BatchItemFailed
+	item.Error = err.Error()
+	item.Err = err
+}
+
+func countResults(result *model.BatchResult) {
+	result.Succeeded, result.Failed = 0, 0
+	for _, item := range result.Results {
+		switch item.Status {
+		case model.BatchItemOK:
+			result.Succeeded++
+		case model.BatchItemFailed:
+			result.Failed++
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/command"
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+)
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (n *recordingNotifier) NotifyUserEvent(event model.UserEvent) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, event.Type)
+	return nil
+}
+
+func newBatchService(t *testing.T, repo *fakeRepo) (*command.Bus, *recordingNotifier) {
+	notifier := &recordingNotifier{}
+	us := NewUserService(context.Background(), repo, noopValidator{}, notifier, 4, 0)
+	t.Cleanup(func() { _ = us.Shutdown(context.Background()) })
+	bus := command.NewBus(command.Validation())
+	us.Register(bus)
+	return bus, notifier
+}
+
+func batchUpdate(userID int64, name string) model.BatchOperation {
+	return model.BatchOperation{Op: model.BatchUpdate, UserID: userID, Update: &model.UpdateUserRequest{FirstName: &name}}
+}
+
+func statuses(result model.BatchResult) []string {
+	var out []string
+	for _, item := range result.Results {
+		out = append(out, item.Status)
+	}
+	return out
+}
+
+func TestBestEffortBatch(t *testing.T) {
+	repo := newFakeRepo(0)
+	repo.missing = map[int64]bool{2: true}
+	bus, notifier := newBatchService(t, repo)
+
+	result, err := command.Dispatch(context.Background(), bus, model.BatchUsers{
+		Mode: model.BatchBestEffort,
+		Operations: []model.BatchOperation{
+			{Op: model.BatchCreate, Create: &model.CreateUserRequest{Email: "new@example.com"}},
+			batchUpdate(1, "a"),
+			batchUpdate(2, "missing"),
+			{Op: model.BatchDelete},
+			batchUpdate(1, "b"),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ok", "ok", "failed", "failed", "ok"}, statuses(result))
+	require.ErrorIs(t, result.Results[2].Err, errs.ErrUserNotFound)
+	require.ErrorIs(t, result.Results[3].Err, errs.ErrInvalidInput)
+	require.Equal(t, 3, result.Succeeded)
+	require.Equal(t, 2, result.Failed)
+	require.Equal(t, []string{"a", "b"}, repo.updates[1])
+	require.Len(t, notifier.events, 3)
+}
+
+func TestAtomicBatch(t *testing.T) {
+	repo := newFakeRepo(0)
+	repo.missing = map[int64]bool{2: true}
+	bus, notifier := newBatchService(t, repo)
+
+	// A failure rolls back the operations before it and skips the rest
+	result, err := command.Dispatch(context.Background(), bus, model.BatchUsers{
+		Mode:       model.BatchAtomic,
+		Operations: []model.BatchOperation{batchUpdate(1, "a"), batchUpdate(2, "b"), batchUpdate(3, "c")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"rolled_back", "failed", "skipped"}, statuses(result))
+	require.ErrorIs(t, result.FirstError(), errs.ErrUserNotFound)
+	require.Empty(t, notifier.events)
+
+	// Invalid operations are caught before anything runs
+	repo.updates = make(map[int64][]string)
+	result, err = command.Dispatch(context.Background(), bus, model.BatchUsers{
+		Mode:       model.BatchAtomic,
+		Operations: []model.BatchOperation{batchUpdate(1, "a"), {Op: model.BatchDelete}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"skipped", "failed"}, statuses(result))
+	require.ErrorIs(t, result.FirstError(), errs.ErrInvalidInput)
+	require.Empty(t, repo.updates)
+
+	result, err = command.Dispatch(context.Background(), bus, model.BatchUsers{
+		Mode:       model.BatchAtomic,
+		Operations: []model.BatchOperation{batchUpdate(1, "a"), {Op: model.BatchDelete, UserID: 3}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Succeeded)
+	require.Equal(t, []string{model.EventUserUpdated, model.EventUserDeleted}, notifier.events)
+}
+
+func TestEmptyBatchIsRejected(t *testing.T) {
+	bus, _ := newBatchService(t, newFakeRepo(0))
+	_, err := command.Dispatch(context.Background(), bus, model.BatchUsers{Mode: model.BatchAtomic})
+	require.ErrorIs(t, err, errs.ErrInvalidInput)
+	_, err = command.Dispatch(context.Background(), bus, model.BatchUsers{Mode: "sometimes", Operations: []model.BatchOperation{batchUpdate(1, "a")}})
+	require.ErrorIs(t, err, errs.ErrInvalidInput)
+}
+
+func TestAtomicBatchWaitsForWritesToItsUsers(t *testing.T) {
+	repo := newFakeRepo(0)
+	repo.block = make(chan struct{})
+	bus, _ := newBatchService(t, repo)
+
+	single := make(chan error, 1)
+	go func() {
+		_, err := command.Dispatch(context.Background(), bus, update(1, "single"))
+		single <- err
+	}()
+	require.Eventually(t, func() bool { return repo.blocked() == 1 }, time.Second, time.Millisecond)
+
+	batch := make(chan error, 1)
+	go func() {
+		_, err := command.Dispatch(context.Background(), bus, model.BatchUsers{
+			Mode:       model.BatchAtomic,
+			Operations: []model.BatchOperation{batchUpdate(2, "batch"), batchUpdate(1, "batch")},
+		})
+		batch <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, 1, repo.blocked(), "the batch ran alongside a write to its user")
+
+	close(repo.block)
+	require.NoError(t, <-single)
+	require.NoError(t, <-batch)
+	require.Equal(t, []string{"single", "batch"}, repo.updates[1])
+}
+
+func TestOverlappingAtomicBatchesDontDeadlock(t *testing.T) {
+	bus, _ := newBatchService(t, newFakeRepo(time.Millisecond))
+
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		ops := []model.BatchOperation{batchUpdate(1, "a"), batchUpdate(2, "b"), batchUpdate(3, "c")}
+		if i%2 == 1 {
+			ops[0], ops[2] = ops[2], ops[0]
+		}
+		go func() {
+			_, err := command.Dispatch(context.Background(), bus, model.BatchUsers{Mode: model.BatchAtomic, Operations: ops})
+			errs <- err
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, <-errs)
+	}
+}
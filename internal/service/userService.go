@@ -2,117 +2,374 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"hash/fnv"
 	"log"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"UserManagement/internal/command"
+	"UserManagement/internal/errs"
 	"UserManagement/internal/model"
 	"UserManagement/internal/repository"
 )
 
 type UserNotifier interface {
-	NotifyUserCreated(key string, value interface{}) error
+	NotifyUserEvent(event model.UserEvent) error
 }
 
 type Validator interface {
 	ValidateCreateUser(firstName, lastName, email string) error
+	ValidateUpdateUser(firstName, lastName, email *string) error
 }
 
+// DefaultWorkers is the write pool size used when none is configured
+const DefaultWorkers = 8
+
+// DefaultReaders is how many reads run at once when no limit is configured
+const DefaultReaders = 32
+
+// maxExports is how many exports run at once, apart from the reads
+const maxExports = 4
+
+// shardQueueSize is the buffer in front of each write worker
+const shardQueueSize = 100
+
+// queueTimeout bounds how long a write waits for room in its shard
+var queueTimeout = 3 * time.Second
+
+// UserService handles the user commands. Reads run on the caller's goroutine,
+// up to a limit at once; writes are sharded across a pool of workers by user,
+// so writes to one user keep their order while different users are written
+// in parallel.
 type UserService struct {
+	ctx      context.Context
 	repo     repository.UserRepository
 	v        Validator
 	notifier UserNotifier
-	channel  chan model.CUDRequest
+	shards   []chan queuedRequest
+	// readers holds a slot for each read in progress
+	readers chan struct{}
+	// exporters holds a slot for each export in progress. Exports run for as
+	// long as they take, so they are cancelled through exportCtx on shutdown
+	// rather than waited for.
+	exporters     chan struct{}
+	exportCtx     context.Context
+	cancelExports context.CancelFunc
+
+	// closeMu guards closing the shards against a concurrent send
+	closeMu sync.RWMutex
+	closed  bool
+	// pending tracks the workers and reads in flight
+	pending sync.WaitGroup
+	// drained is closed once every queued request has been handled
+	drained chan struct{}
+}
+
+// queuedRequest is a write waiting for its worker. run is given the caller's
+// context and reports ctx's error instead of running once the caller has gone
+// away.
+type queuedRequest struct {
+	ctx context.Context
+	run func(ctx context.Context)
 }
 
-func NewUserService(ctx context.Context, repo repository.UserRepository, v Validator, notifier UserNotifier) *UserService {
+// NewUserService starts the given number of write workers and lets up to
+// readers reads run at once; DefaultWorkers and DefaultReaders stand in for
+// values that are not positive.
+func NewUserService(ctx context.Context, repo repository.UserRepository, v Validator, notifier UserNotifier, workers, readers int) *UserService {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if readers <= 0 {
+		readers = DefaultReaders
+	}
 	us := &UserService{
-		repo:     repo,
-		v:        v,
-		notifier: notifier,
-		channel:  make(chan model.CUDRequest, 100), // Buffered channel to handle multiple requests
+		ctx:       ctx,
+		repo:      repo,
+		v:         v,
+		notifier:  notifier,
+		shards:    make([]chan queuedRequest, workers),
+		readers:   make(chan struct{}, readers),
+		exporters: make(chan struct{}, maxExports),
+		drained:   make(chan struct{}),
 	}
+	us.exportCtx, us.cancelExports = context.WithCancel(ctx)
 
-	// Start a goroutine to listen for messages on the channel
-	go us.listenToChannel(ctx)
+	for i := range us.shards {
+		us.shards[i] = make(chan queuedRequest, shardQueueSize)
+		us.pending.Add(1)
+		go us.worker(us.shards[i])
+	}
 
 	return us
 }
 
-func (s *UserService) listenToChannel(ctx context.Context) {
+// Register sets the service as the handler of the user commands on bus.
+func (s *UserService) Register(bus *command.Bus) {
+	command.Register(bus, func(ctx context.Context, cmd model.CreateUser) (model.User, error) {
+		// Creates have no ID yet, so they are keyed by email, which keeps
+		// duplicate checks for one address in order
+		return write(ctx, s, emailKey(cmd.Req.Email), func(ctx context.Context) (model.User, error) {
+			return s.CreateUser(ctx, cmd.Req)
+		})
+	})
+	command.Register(bus, func(ctx context.Context, cmd model.UpdateUser) (model.User, error) {
+		return write(ctx, s, uint64(cmd.UserID), func(ctx context.Context) (model.User, error) {
+			return s.UpdateUser(ctx, cmd.UserID, cmd.Req)
+		})
+	})
+	command.Register(bus, func(ctx context.Context, cmd model.DeleteUser) (model.User, error) {
+		return write(ctx, s, uint64(cmd.UserID), func(ctx context.Context) (model.User, error) {
+			return s.DeleteUser(ctx, cmd.UserID)
+		})
+	})
+	command.Register(bus, s.Batch)
+	command.Register(bus, func(ctx context.Context, cmd model.GetUser) (model.User, error) {
+		return read(ctx, s, func(ctx context.Context) (model.User, error) {
+			return s.GetUserById(ctx, cmd.UserID)
+		})
+	})
+	command.Register(bus, func(ctx context.Context, cmd model.GetUserByEmail) (model.User, error) {
+		return read(ctx, s, func(ctx context.Context) (model.User, error) {
+			return s.GetUserByEmail(ctx, cmd.Email)
+		})
+	})
+	command.Register(bus, func(ctx context.Context, cmd model.ListUsers) ([]model.User, error) {
+		return read(ctx, s, s.GetUsers)
+	})
+	command.Register(bus, func(ctx context.Context, cmd model.ExportUsers) (int, error) {
+		return export(ctx, s, func(ctx context.Context) (int, error) {
+			return s.ExportUsers(ctx, cmd.Filter, cmd.Each)
+		})
+	})
+}
+
+func (s *UserService) worker(shard chan queuedRequest) {
+	defer s.pending.Done()
 	for {
 		select {
-		case req := <-s.channel:
-			switch req.Type {
-			case "create_user":
-				log.Printf("Processing user creation from channel: %+v\n", req.CreateReq)
-				if user, err := s.CreateUser(ctx, req.CreateReq); err != nil {
-					log.Printf("Error processing user creation from channel: %v\n", err)
-					req.ResponseChannel <- err
-				} else {
-					req.ResponseChannel <- user
-				}
-			case "update_user":
-				log.Printf("Processing user update from channel: %+v\n", req.UpdateReq)
-				if user, err := s.UpdateUser(ctx, req.UpdateReq.UserID, req.UpdateReq.Req); err != nil {
-					log.Printf("Error processing user update: %v\n", err)
-					req.ResponseChannel <- err
-				} else {
-					req.ResponseChannel <- user
-				}
-			case "delete_user":
-				log.Printf("Processing user deletion from channel: %+v\n", req.UserID)
-				if user, err := s.DeleteUser(ctx, req.UserID); err != nil {
-					log.Printf("Error processing user deletion: %v\n", err)
-					req.ResponseChannel <- err
-				} else {
-					req.ResponseChannel <- user
-				}
-			case "get_users":
-				log.Printf("Processing get users request from channel")
-				users, err := s.GetUsers(ctx)
-				if err != nil {
-					log.Printf("Error processing get users request: %v\n", err)
-					req.ResponseChannel <- err
-				} else {
-					req.ResponseChannel <- users
-				}
-			case "get_user":
-				log.Printf("Processing get user request from channel: %+v\n", req.UserID)
-				user, err := s.GetUserById(ctx, req.UserID)
-				if err != nil {
-					log.Printf("Error processing get user request: %v\n", err)
-					req.ResponseChannel <- err
-				} else {
-					req.ResponseChannel <- user
-				}
+		case q, ok := <-shard:
+			if !ok {
+				return
 			}
+			q.run(q.ctx)
 
-		case <-ctx.Done():
-			log.Println("Context canceled, stopping channel listener")
-			s.channel = nil // Set channel to nil to indicate no listener
+		case <-s.ctx.Done():
 			return
 		}
 	}
 }
 
-// QueueCUDRequest SendToChannel Add a method to send messages to the channel
-func (s *UserService) QueueCUDRequest(req model.CUDRequest) {
-	if s.channel == nil {
-		log.Println("No active listener on the channel, dropping request")
-		return
+func emailKey(email string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(email)))
+	return h.Sum64()
+}
+
+// Shutdown stops accepting requests and waits until the ones already queued
+// have been handled, or until ctx is done. Exports in progress are cancelled.
+func (s *UserService) Shutdown(ctx context.Context) error {
+	s.CancelExports()
+	s.closeMu.Lock()
+	if !s.closed {
+		s.closed = true
+		for _, shard := range s.shards {
+			close(shard)
+		}
+		go func() {
+			s.pending.Wait()
+			log.Println("Request queue drained, stopping workers")
+			close(s.drained)
+		}()
+	}
+	s.closeMu.Unlock()
+
+	select {
+	case <-s.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// result is what a queued write hands back to its caller
+type result[R any] struct {
+	value R
+	err   error
+}
+
+// write queues fn on the worker for key and waits for its result. It fails
+// with errs.ErrServiceClosed during shutdown, errs.ErrQueueFull when the
+// worker stays busy for too long, or ctx's error; fn is skipped if ctx ends
+// before it runs.
+func write[R any](ctx context.Context, s *UserService, key uint64, fn func(ctx context.Context) (R, error)) (R, error) {
+	var zero R
+	// Buffered so the worker never blocks on a caller that stopped waiting
+	done := make(chan result[R], 1)
+	q := queuedRequest{ctx: ctx, run: func(ctx context.Context) {
+		if err := ctx.Err(); err != nil {
+			log.Printf("Skipping request: %v", err)
+			done <- result[R]{err: err}
+			return
+		}
+		value, err := fn(ctx)
+		done <- result[R]{value: value, err: err}
+	}}
+	if err := s.enqueue(ctx, key, q); err != nil {
+		return zero, err
+	}
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// writeAll runs fn on the caller's goroutine while it holds the worker of
+// every key, so fn is ordered with the writes to each of them. Workers are
+// taken one at a time in shard order, which keeps two callers from each
+// holding a worker the other waits for. It fails like write.
+func writeAll[R any](ctx context.Context, s *UserService, keys []uint64, fn func(ctx context.Context) (R, error)) (R, error) {
+	var zero R
+	held := make(map[uint64]bool, len(keys))
+	shards := make([]uint64, 0, len(keys))
+	for _, key := range keys {
+		shard := key % uint64(len(s.shards))
+		if !held[shard] {
+			held[shard] = true
+			shards = append(shards, shard)
+		}
+	}
+	slices.Sort(shards)
+
+	var releases []chan struct{}
+	defer func() {
+		for _, release := range releases {
+			close(release)
+		}
+	}()
+	for _, shard := range shards {
+		started, release := make(chan struct{}), make(chan struct{})
+		q := queuedRequest{ctx: ctx, run: func(ctx context.Context) {
+			if ctx.Err() != nil {
+				return
+			}
+			close(started)
+			<-release
+		}}
+		if err := s.enqueue(ctx, shard, q); err != nil {
+			return zero, err
+		}
+		// From here the worker may start at any time, so it is released
+		// whatever happens
+		releases = append(releases, release)
+		select {
+		case <-started:
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+	return fn(ctx)
+}
+
+func (s *UserService) enqueue(ctx context.Context, key uint64, q queuedRequest) error {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed || s.ctx.Err() != nil {
+		return errs.ErrServiceClosed
 	}
+	timer := time.NewTimer(queueTimeout)
+	defer timer.Stop()
 	select {
-	case s.channel <- req:
-		log.Println("Request queued successfully")
-	case <-time.After(3 * time.Second):
+	case s.shards[key%uint64(len(s.shards))] <- q:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
 		log.Println("Timeout: failed to queue request")
+		return errs.ErrQueueFull
+	}
+}
+
+// read runs fn on the caller's goroutine; reads don't need ordering, so they
+// don't wait behind writes. Once every read slot is taken it waits for one
+// like a write waits for its worker, and fails the same way.
+func read[R any](ctx context.Context, s *UserService, fn func(ctx context.Context) (R, error)) (R, error) {
+	var zero R
+	s.closeMu.RLock()
+	if s.closed || s.ctx.Err() != nil {
+		s.closeMu.RUnlock()
+		return zero, errs.ErrServiceClosed
+	}
+	s.pending.Add(1)
+	s.closeMu.RUnlock()
+	defer s.pending.Done()
+
+	release, err := acquire(ctx, s.readers, "read")
+	if err != nil {
+		return zero, err
+	}
+	defer release()
+	return fn(ctx)
+}
+
+// export runs fn on the caller's goroutine in one of the export slots, which
+// are kept apart from the read slots so that slow exports can't starve reads.
+// Exports aren't waited for on shutdown: CancelExports cancels their context
+// and they fail with errs.ErrServiceClosed.
+func export[R any](ctx context.Context, s *UserService, fn func(ctx context.Context) (R, error)) (R, error) {
+	var zero R
+	if s.exportCtx.Err() != nil {
+		return zero, errs.ErrServiceClosed
+	}
+	release, err := acquire(ctx, s.exporters, "export")
+	if err != nil {
+		return zero, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(s.exportCtx, cancel)
+	defer stop()
+	value, err := fn(ctx)
+	if err != nil && s.exportCtx.Err() != nil {
+		return zero, errs.ErrServiceClosed
+	}
+	return value, err
+}
+
+// CancelExports stops the exports in progress and refuses new ones.
+func (s *UserService) CancelExports() {
+	s.cancelExports()
+}
+
+// acquire takes one of slots, waiting up to queueTimeout for one to free up.
+func acquire(ctx context.Context, slots chan struct{}, what string) (release func(), err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(queueTimeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		log.Printf("Timeout: no room for %s", what)
+		return nil, errs.ErrQueueFull
 	}
 }
 
 func (s *UserService) CreateUser(ctx context.Context, req model.CreateUserRequest) (model.User, error) {
 	if err := s.v.ValidateCreateUser(req.FirstName, req.LastName, req.Email); err != nil {
 		log.Println("Validation failed:", err)
-		return model.User{}, err
+		return model.User{}, fmt.Errorf("%w: %v", errs.ErrInvalidInput, err)
 	}
 
 	// Create a new context with a deadline
@@ -126,7 +383,7 @@ func (s *UserService) CreateUser(ctx context.Context, req model.CreateUserReques
 	}
 
 	// Publish a message to Kafka
-	s.notifyEvent("user_created", user)
+	s.notifyEvent(model.EventUserCreated, user, user.Version)
 
 	return user, nil
 }
@@ -139,6 +396,17 @@ func (s *UserService) GetUsers(ctx context.Context) ([]model.User, error) {
 	return users, err
 }
 
+// ExportUsers passes the matching users to each as they are read. It has no
+// deadline, since large exports take a while; it stops when ctx is cancelled.
+func (s *UserService) ExportUsers(ctx context.Context, filter model.UserFilter, each func(user model.User) error) (int, error) {
+	n := 0
+	err := s.repo.ExportUsersRepo(ctx, filter, func(user model.User) error {
+		n++
+		return each(user)
+	})
+	return n, err
+}
+
 func (s *UserService) GetUserById(ctx context.Context, userId int64) (model.User, error) {
 	// Create a new context with a deadline
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -147,19 +415,30 @@ func (s *UserService) GetUserById(ctx context.Context, userId int64) (model.User
 	return user, err
 }
 
+func (s *UserService) GetUserByEmail(ctx context.Context, email string) (model.User, error) {
+	// Create a new context with a deadline
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return s.repo.GetUserByEmailRepo(ctx, email)
+}
+
 func (s *UserService) DeleteUser(ctx context.Context, userId int64) (model.User, error) {
 	// Create a new context with a deadline
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	user, err := s.repo.DeleteUserRepo(ctx, userId)
-	// Publish a message to Kafka
+	// Publish a message to Kafka; the deleted row still carries its last version, so the delete is the next step
 	if err == nil {
-		s.notifyEvent("user_deleted", user)
+		s.notifyEvent(model.EventUserDeleted, user, user.Version+1)
 	}
 	return user, err
 }
 
 func (s *UserService) UpdateUser(ctx context.Context, userId int64, req model.UpdateUserRequest) (model.User, error) {
+	if err := s.v.ValidateUpdateUser(req.FirstName, req.LastName, req.Email); err != nil {
+		log.Println("Validation failed:", err)
+		return model.User{}, fmt.Errorf("%w: %v", errs.ErrInvalidInput, err)
+	}
 
 	// Create a new context with a deadline
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -167,16 +446,22 @@ func (s *UserService) UpdateUser(ctx context.Context, userId int64, req model.Up
 	user, err := s.repo.UpdateUserRepo(ctx, userId, req)
 	// Publish a message to Kafka
 	if err == nil {
-		s.notifyEvent("user_updated", user)
+		s.notifyEvent(model.EventUserUpdated, user, user.Version)
 	}
 	return user, err
 }
 
-func (s *UserService) notifyEvent(key string, value interface{}) {
+func (s *UserService) notifyEvent(eventType string, user model.User, sequence int64) {
 	if s.notifier == nil {
 		return
 	}
-	if err := s.notifier.NotifyUserCreated(key, value); err != nil {
-		log.Printf("Failed to publish Kafka message [%s]: %v\n", key, err)
+	event := model.UserEvent{
+		Type:     eventType,
+		UserID:   user.ID,
+		Sequence: sequence,
+		User:     user,
+	}
+	if err := s.notifier.NotifyUserEvent(event); err != nil {
+		log.Printf("Failed to publish Kafka message [%s]: %v\n", eventType, err)
 	}
 }
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/command"
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+	"UserManagement/internal/repository"
+)
+
+// fakeRepo simulates a database where every query takes latency. Updates are
+// recorded per user so tests can check their order.
+type fakeRepo struct {
+	latency time.Duration
+	// block, when set, holds updates until it is closed
+	block chan struct{}
+
+	mu      sync.Mutex
+	updates map[int64][]string
+	waiting int
+	// inFlight, when set, tracks running updates per user
+	inFlight   map[int64]int
+	overlapped bool
+	// missing users fail to update
+	missing map[int64]bool
+	// holdExports keeps exports running until they are cancelled
+	holdExports bool
+}
+
+func newFakeRepo(latency time.Duration) *fakeRepo {
+	return &fakeRepo{latency: latency, updates: make(map[int64][]string)}
+}
+
+func (r *fakeRepo) wait() {
+	if r.latency > 0 {
+		time.Sleep(r.latency)
+	}
+}
+
+func (r *fakeRepo) CreateUserRepo(ctx context.Context, req model.CreateUserRequest) (model.User, error) {
+	r.wait()
+	return model.User{ID: 1, Email: req.Email, Version: 1}, nil
+}
+
+func (r *fakeRepo) GetUserRepo(ctx context.Context, userID int64) (model.User, error) {
+	r.wait()
+	return model.User{ID: userID}, nil
+}
+
+func (r *fakeRepo) GetUserByEmailRepo(ctx context.Context, email string) (model.User, error) {
+	r.wait()
+	return model.User{ID: 1, Email: email}, nil
+}
+
+func (r *fakeRepo) UpdateUserRepo(ctx context.Context, userID int64, req model.UpdateUserRequest) (model.User, error) {
+	r.mu.Lock()
+	if r.missing[userID] {
+		r.mu.Unlock()
+		return model.User{}, errs.ErrUserNotFound
+	}
+	if r.inFlight != nil {
+		r.inFlight[userID]++
+		r.overlapped = r.overlapped || r.inFlight[userID] > 1
+	}
+	r.waiting++
+	r.mu.Unlock()
+	if r.block != nil {
+		<-r.block
+	}
+	r.wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.waiting--
+	if r.inFlight != nil {
+		r.inFlight[userID]--
+	}
+	r.updates[userID] = append(r.updates[userID], *req.FirstName)
+	return model.User{ID: userID, FirstName: *req.FirstName}, nil
+}
+
+// blocked is how many updates are in progress
+func (r *fakeRepo) blocked() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.waiting
+}
+
+func (r *fakeRepo) DeleteUserRepo(ctx context.Context, userID int64) (model.User, error) {
+	r.wait()
+	return model.User{ID: userID}, nil
+}
+
+func (r *fakeRepo) ListUsersRepo(ctx context.Context) ([]model.User, error) {
+	r.wait()
+	return nil, nil
+}
+
+func (r *fakeRepo) ExportUsersRepo(ctx context.Context, filter model.UserFilter, fn func(user model.User) error) error {
+	if r.holdExports {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	r.wait()
+	return nil
+}
+
+func (r *fakeRepo) InTx(ctx context.Context, fn func(repo repository.UserRepository) error) error {
+	return fn(r)
+}
+
+type noopValidator struct{}
+
+func (noopValidator) ValidateCreateUser(firstName, lastName, email string) error { return nil }
+
+func (noopValidator) ValidateUpdateUser(firstName, lastName, email *string) error { return nil }
+
+func newTestService(repo *fakeRepo, workers int) (*UserService, *command.Bus) {
+	us := NewUserService(context.Background(), repo, noopValidator{}, nil, workers, 0)
+	bus := command.NewBus(command.Validation())
+	us.Register(bus)
+	return us, bus
+}
+
+func update(userID int64, name string) model.UpdateUser {
+	return model.UpdateUser{UserID: userID, Req: model.UpdateUserRequest{FirstName: &name}}
+}
+
+func TestWritesToOneUserKeepOrder(t *testing.T) {
+	repo := newFakeRepo(0)
+	us, bus := newTestService(repo, 4)
+
+	var wg sync.WaitGroup
+	for userID := int64(1); userID <= 8; userID++ {
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				user, err := command.Dispatch(context.Background(), bus, update(userID, fmt.Sprint(i)))
+				if err != nil || user.FirstName != fmt.Sprint(i) {
+					t.Errorf("update %d of user %d: %v %v", i, userID, user, err)
+				}
+			}
+		}(userID)
+	}
+	wg.Wait()
+	require.NoError(t, us.Shutdown(context.Background()))
+
+	for userID := int64(1); userID <= 8; userID++ {
+		require.Len(t, repo.updates[userID], 50)
+		for i, name := range repo.updates[userID] {
+			require.Equal(t, fmt.Sprint(i), name, "user %d", userID)
+		}
+	}
+}
+
+func TestWritesToOneUserDontOverlap(t *testing.T) {
+	repo := newFakeRepo(100 * time.Microsecond)
+	repo.inFlight = make(map[int64]int)
+	us, bus := newTestService(repo, 4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := command.Dispatch(context.Background(), bus, update(userID, "x")); err != nil {
+					t.Error(err)
+				}
+			}
+		}(int64(i%8) + 1)
+	}
+	wg.Wait()
+	require.NoError(t, us.Shutdown(context.Background()))
+	require.False(t, repo.overlapped, "two writes to one user ran at once")
+}
+
+func TestReadsDontWaitForWrites(t *testing.T) {
+	repo := newFakeRepo(0)
+	repo.block = make(chan struct{})
+	us, bus := newTestService(repo, 1)
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := command.Dispatch(context.Background(), bus, update(1, "blocked"))
+		written <- err
+	}()
+	require.Eventually(t, func() bool { return repo.blocked() == 1 }, time.Second, time.Millisecond)
+
+	read := make(chan model.User, 1)
+	go func() {
+		user, _ := command.Dispatch(context.Background(), bus, model.GetUser{UserID: 1})
+		read <- user
+	}()
+	select {
+	case user := <-read:
+		require.Equal(t, model.User{ID: 1}, user)
+	case <-time.After(time.Second):
+		t.Fatal("read was queued behind a write")
+	}
+
+	close(repo.block)
+	require.NoError(t, <-written)
+	require.NoError(t, us.Shutdown(context.Background()))
+}
+
+func TestReadsAreBounded(t *testing.T) {
+	defer func(timeout time.Duration) { queueTimeout = timeout }(queueTimeout)
+	queueTimeout = 10 * time.Millisecond
+
+	us := NewUserService(context.Background(), newFakeRepo(200*time.Millisecond), noopValidator{}, nil, 1, 1)
+	bus := command.NewBus(command.Validation())
+	us.Register(bus)
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := command.Dispatch(context.Background(), bus, model.GetUser{UserID: 1})
+		first <- err
+	}()
+	require.Eventually(t, func() bool { return len(us.readers) == 1 }, time.Second, time.Millisecond)
+
+	_, err := command.Dispatch(context.Background(), bus, model.GetUser{UserID: 2})
+	require.ErrorIs(t, err, errs.ErrQueueFull)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = command.Dispatch(ctx, bus, model.ListUsers{})
+	require.ErrorIs(t, err, context.Canceled)
+
+	require.NoError(t, <-first)
+	_, err = command.Dispatch(context.Background(), bus, model.GetUser{UserID: 2})
+	require.NoError(t, err)
+	require.NoError(t, us.Shutdown(context.Background()))
+}
+
+func TestExportsHaveTheirOwnSlots(t *testing.T) {
+	defer func(timeout time.Duration) { queueTimeout = timeout }(queueTimeout)
+	queueTimeout = 10 * time.Millisecond
+
+	repo := newFakeRepo(0)
+	repo.holdExports = true
+	us := NewUserService(context.Background(), repo, noopValidator{}, nil, 1, 1)
+	bus := command.NewBus(command.Validation())
+	us.Register(bus)
+
+	exported := make(chan error, maxExports)
+	for i := 0; i < maxExports; i++ {
+		go func() {
+			_, err := command.Dispatch(context.Background(), bus, model.ExportUsers{Each: func(model.User) error { return nil }})
+			exported <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return len(us.exporters) == maxExports }, time.Second, time.Millisecond)
+	_, err := command.Dispatch(context.Background(), bus, model.ExportUsers{Each: func(model.User) error { return nil }})
+	require.ErrorIs(t, err, errs.ErrQueueFull)
+
+	// Reads still have their slot
+	_, err = command.Dispatch(context.Background(), bus, model.GetUser{UserID: 1})
+	require.NoError(t, err)
+
+	// Shutdown cancels the exports instead of waiting for them
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, us.Shutdown(ctx))
+	for i := 0; i < maxExports; i++ {
+		require.ErrorIs(t, <-exported, errs.ErrServiceClosed)
+	}
+	_, err = command.Dispatch(context.Background(), bus, model.ExportUsers{Each: func(model.User) error { return nil }})
+	require.ErrorIs(t, err, errs.ErrServiceClosed)
+}
+
+func TestQueueFullAndClosed(t *testing.T) {
+	defer func(timeout time.Duration) { queueTimeout = timeout }(queueTimeout)
+	queueTimeout = 10 * time.Millisecond
+
+	repo := newFakeRepo(0)
+	repo.block = make(chan struct{})
+	us, bus := newTestService(repo, 1)
+
+	// One write in the worker, then a full shard
+	var wg sync.WaitGroup
+	for i := 0; i <= shardQueueSize; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := command.Dispatch(context.Background(), bus, update(1, "queued")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	require.Eventually(t, func() bool { return len(us.shards[0]) == shardQueueSize }, time.Second, time.Millisecond)
+	_, err := command.Dispatch(context.Background(), bus, update(1, "late"))
+	require.ErrorIs(t, err, errs.ErrQueueFull)
+
+	// A caller that gives up while waiting for room isn't held up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = command.Dispatch(ctx, bus, update(1, "late"))
+	require.ErrorIs(t, err, context.Canceled)
+
+	close(repo.block)
+	wg.Wait()
+	require.NoError(t, us.Shutdown(context.Background()))
+	_, err = command.Dispatch(context.Background(), bus, update(1, "late"))
+	require.ErrorIs(t, err, errs.ErrServiceClosed)
+	_, err = command.Dispatch(context.Background(), bus, model.ListUsers{})
+	require.ErrorIs(t, err, errs.ErrServiceClosed)
+}
+
+func TestCancelledWriteIsSkipped(t *testing.T) {
+	repo := newFakeRepo(0)
+	repo.block = make(chan struct{})
+	us, bus := newTestService(repo, 1)
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := command.Dispatch(context.Background(), bus, update(1, "first"))
+		first <- err
+	}()
+	require.Eventually(t, func() bool { return repo.blocked() == 1 }, time.Second, time.Millisecond)
+
+	// Queued behind the first write, then abandoned
+	ctx, cancel := context.WithCancel(context.Background())
+	second := make(chan error, 1)
+	go func() {
+		_, err := command.Dispatch(ctx, bus, update(1, "second"))
+		second <- err
+	}()
+	require.Eventually(t, func() bool { return len(us.shards[0]) == 1 }, time.Second, time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-second, context.Canceled)
+
+	close(repo.block)
+	require.NoError(t, <-first)
+	require.NoError(t, us.Shutdown(context.Background()))
+	require.Equal(t, []string{"first"}, repo.updates[1])
+}
+
+func TestInvalidCommandIsRejected(t *testing.T) {
+	us, bus := newTestService(newFakeRepo(0), 1)
+	_, err := command.Dispatch(context.Background(), bus, model.DeleteUser{})
+	require.ErrorIs(t, err, errs.ErrInvalidInput)
+	require.NoError(t, us.Shutdown(context.Background()))
+}
+
+// newSerialBus is the previous design: one goroutine handling every command.
+func newSerialBus(s *UserService) *command.Bus {
+	queue := make(chan func(), 100)
+	go func() {
+		for run := range queue {
+			run()
+		}
+	}()
+	bus := command.NewBus()
+	command.Register(bus, func(ctx context.Context, cmd model.UpdateUser) (model.User, error) {
+		return serial(queue, func() (model.User, error) { return s.UpdateUser(ctx, cmd.UserID, cmd.Req) })
+	})
+	command.Register(bus, func(ctx context.Context, cmd model.GetUser) (model.User, error) {
+		return serial(queue, func() (model.User, error) { return s.GetUserById(ctx, cmd.UserID) })
+	})
+	return bus
+}
+
+func serial[R any](queue chan func(), fn func() (R, error)) (R, error) {
+	done := make(chan result[R], 1)
+	queue <- func() {
+		value, err := fn()
+		done <- result[R]{value: value, err: err}
+	}
+	r := <-done
+	return r.value, r.err
+}
+
+// benchmarkRequests sends a mix of one read per three writes, spread over 64
+// users, from parallel callers as the HTTP handlers would.
+func benchmarkRequests(b *testing.B, bus *command.Bus) {
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			i++
+			userID := int64(i%64) + 1
+			var err error
+			if i%4 == 0 {
+				_, err = command.Dispatch(context.Background(), bus, model.GetUser{UserID: userID})
+			} else {
+				_, err = command.Dispatch(context.Background(), bus, update(userID, "bench"))
+			}
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// Run with: go test -run '^$' -bench UserService ./internal/service
+func BenchmarkUserService(b *testing.B) {
+	const latency = 200 * time.Microsecond
+
+	b.Run("single_queue", func(b *testing.B) {
+		us := NewUserService(context.Background(), newFakeRepo(latency), noopValidator{}, nil, 1, 0)
+		benchmarkRequests(b, newSerialBus(us))
+	})
+	for _, workers := range []int{1, 4, 8, 16} {
+		b.Run(fmt.Sprintf("sharded/workers=%d", workers), func(b *testing.B) {
+			us, bus := newTestService(newFakeRepo(latency), workers)
+			benchmarkRequests(b, bus)
+			b.StopTimer()
+			require.NoError(b, us.Shutdown(context.Background()))
+		})
+	}
+}
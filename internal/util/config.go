@@ -1,6 +1,8 @@
 package util
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
@@ -15,6 +17,81 @@ type Config struct {
 	KafkaTopic     string   `mapstructure:"KAFKA_Topic"`
 	RestPort       string   `mapstructure:"REST_PORT"`
 	WsPort         string   `mapstructure:"WS_PORT"`
+	// TrustedProxies may name the authenticated user in X-Forwarded-User;
+	// IP addresses or CIDR ranges
+	TrustedProxies []string `mapstructure:"TRUSTED_PROXIES"`
+	// AdminToken is the bearer token for the /admin, /webhooks and
+	// /debug/vars routes, which are off without one
+	AdminToken string `mapstructure:"ADMIN_TOKEN"`
+
+	// Number of workers handling user writes
+	WorkerPoolSize int `mapstructure:"WORKER_POOL_SIZE"`
+	// Most user reads, exports included, running at once
+	ReadConcurrency int `mapstructure:"READ_CONCURRENCY"`
+	// Most operations accepted by POST /users:batch
+	BatchMaxSize int `mapstructure:"BATCH_MAX_SIZE"`
+
+	// Where uploads and reports of user imports are kept; the temp dir if empty
+	ImportDir string `mapstructure:"IMPORT_DIR"`
+	// Largest upload accepted by POST /users/import, in bytes
+	ImportMaxSize int64 `mapstructure:"IMPORT_MAX_SIZE"`
+	// How long finished imports and their reports are kept
+	ImportRetention time.Duration `mapstructure:"IMPORT_RETENTION"`
+
+	// Event encoding: json, protobuf or avro
+	KafkaEncoding      string `mapstructure:"KAFKA_ENCODING"`
+	SchemaRegistryURL  string `mapstructure:"SCHEMA_REGISTRY_URL"`
+	SchemaRegistryPath string `mapstructure:"SCHEMA_REGISTRY_PATH"`
+
+	// Async producer batching and delivery
+	KafkaBatchSize    int           `mapstructure:"KAFKA_BATCH_SIZE"`
+	KafkaBatchTimeout time.Duration `mapstructure:"KAFKA_BATCH_TIMEOUT"`
+	KafkaCompression  string        `mapstructure:"KAFKA_COMPRESSION"`
+	KafkaRequiredAcks string        `mapstructure:"KAFKA_REQUIRED_ACKS"`
+	KafkaBufferSize   int           `mapstructure:"KAFKA_BUFFER_SIZE"`
+
+	// Log-compacted topic holding the latest state of every user
+	KafkaSnapshotTopic      string `mapstructure:"KAFKA_SNAPSHOT_TOPIC"`
+	KafkaSnapshotPartitions int    `mapstructure:"KAFKA_SNAPSHOT_PARTITIONS"`
+
+	// Per-client WebSocket send queue: drop_oldest, coalesce or disconnect
+	WsSendQueueSize  int    `mapstructure:"WS_SEND_QUEUE_SIZE"`
+	WsOverflowPolicy string `mapstructure:"WS_OVERFLOW_POLICY"`
+
+	// WebSocket connection limits
+	WsReadLimit       int64         `mapstructure:"WS_READ_LIMIT"`
+	WsReadBufferSize  int           `mapstructure:"WS_READ_BUFFER_SIZE"`
+	WsWriteBufferSize int           `mapstructure:"WS_WRITE_BUFFER_SIZE"`
+	WsPongWait        time.Duration `mapstructure:"WS_PONG_WAIT"`
+	WsPingInterval    time.Duration `mapstructure:"WS_PING_INTERVAL"`
+	WsWriteWait       time.Duration `mapstructure:"WS_WRITE_WAIT"`
+
+	// WebSocket request rate limits, per connection and per principal
+	WsRateLimit          float64 `mapstructure:"WS_RATE_LIMIT"`
+	WsRateBurst          int     `mapstructure:"WS_RATE_BURST"`
+	WsPrincipalRateLimit float64 `mapstructure:"WS_PRINCIPAL_RATE_LIMIT"`
+	WsPrincipalRateBurst int     `mapstructure:"WS_PRINCIPAL_RATE_BURST"`
+	WsMaxRateViolations  int     `mapstructure:"WS_MAX_RATE_VIOLATIONS"`
+
+	// Keep-alive comment interval on idle Server-Sent Events streams
+	SSEHeartbeat time.Duration `mapstructure:"SSE_HEARTBEAT"`
+
+	// Outbound webhook delivery
+	WebhookTimeout        time.Duration `mapstructure:"WEBHOOK_TIMEOUT"`
+	WebhookMaxAttempts    int           `mapstructure:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookInitialBackoff time.Duration `mapstructure:"WEBHOOK_INITIAL_BACKOFF"`
+	WebhookMaxBackoff     time.Duration `mapstructure:"WEBHOOK_MAX_BACKOFF"`
+	WebhookMaxFailures    int           `mapstructure:"WEBHOOK_MAX_FAILURES"`
+	WebhookPollInterval   time.Duration `mapstructure:"WEBHOOK_POLL_INTERVAL"`
+	WebhookBatchSize      int           `mapstructure:"WEBHOOK_BATCH_SIZE"`
+	// Lets webhooks reach private, loopback and link-local addresses
+	WebhookAllowPrivateTargets bool `mapstructure:"WEBHOOK_ALLOW_PRIVATE_TARGETS"`
+
+	// How long responses to requests with an Idempotency-Key are kept
+	IdempotencyTTL time.Duration `mapstructure:"IDEMPOTENCY_TTL"`
+
+	// How long a graceful shutdown may take before the process exits anyway
+	ShutdownTimeout time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
 }
 
 // LoadConfig reads configuration from file or environment variables.
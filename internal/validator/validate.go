@@ -26,3 +26,15 @@ func (v *Validator) ValidateCreateUser(firstName, lastName, email string) error
 	log.Println("User validated", firstName, email)
 	return nil
 }
+
+// ValidateUpdateUser checks the fields an update sets; nil fields are left
+// as they are.
+func (v *Validator) ValidateUpdateUser(firstName, lastName, email *string) error {
+	if (firstName != nil && strings.TrimSpace(*firstName) == "") || (lastName != nil && strings.TrimSpace(*lastName) == "") {
+		return errors.New("firstName or lastName is empty")
+	}
+	if email != nil && !emailRegex.MatchString(*email) {
+		return errors.New("email is invalid")
+	}
+	return nil
+}
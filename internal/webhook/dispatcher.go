@@ -0,0 +1,316 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderWebhookID = "X-Webhook-Id"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Store is the part of the webhook repository the dispatcher needs.
+type Store interface {
+	ListActiveWebhooks(ctx context.Context) ([]model.Webhook, error)
+	GetWebhook(ctx context.Context, webhookID int64) (model.Webhook, error)
+	RecordWebhookSuccess(ctx context.Context, webhookID int64) error
+	RecordWebhookFailure(ctx context.Context, webhookID int64, maxFailures int) (bool, error)
+	CreateDelivery(ctx context.Context, webhookID int64, event model.UserEvent, payload []byte) (bool, error)
+	ClaimDueDeliveries(ctx context.Context, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error)
+	RecordAttempt(ctx context.Context, attempt model.DeliveryAttempt) error
+}
+
+// Config tunes delivery. Zero values fall back to the defaults below.
+type Config struct {
+	// Timeout bounds a single POST
+	Timeout time.Duration
+	// MaxAttempts before a delivery is marked failed
+	MaxAttempts int
+	// Retries back off exponentially from InitialBackoff up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxFailures is how many deliveries in a row may fail before the
+	// webhook is disabled
+	MaxFailures int
+	// How often the delivery log is polled for due deliveries
+	PollInterval time.Duration
+	// BatchSize is how many deliveries are attempted at once
+	BatchSize int
+	// AllowPrivateTargets lets webhooks reach loopback, private and
+	// link-local addresses, e.g. for local development
+	AllowPrivateTargets bool
+}
+
+func (c Config) withDefaults() Config {
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 8
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 10 * time.Minute
+	}
+	if c.MaxFailures <= 0 {
+		c.MaxFailures = 5
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = time.Second
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 20
+	}
+	return c
+}
+
+// Dispatcher records user events in the delivery log and POSTs them to the
+// subscribed webhooks. Deliveries live in Postgres, so they survive restarts
+// and can be shared by several instances.
+type Dispatcher struct {
+	store  Store
+	config Config
+	client *http.Client
+	wake   chan struct{}
+}
+
+func NewDispatcher(store Store, config Config) *Dispatcher {
+	config = config.withDefaults()
+	return &Dispatcher{
+		store:  store,
+		config: config,
+		client: newClient(config),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Enqueue adds a delivery of event for every active webhook subscribed to it.
+// Webhooks that already have one are skipped, so an event can be enqueued
+// again after a partial failure without duplicating deliveries.
+func (d *Dispatcher) Enqueue(ctx context.Context, event model.UserEvent) error {
+	webhooks, err := d.store.ListActiveWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	queued := false
+	for _, w := range webhooks {
+		if !w.Wants(event.Type) {
+			continue
+		}
+		created, err := d.store.CreateDelivery(ctx, w.ID, event, payload)
+		if err != nil {
+			return fmt.Errorf("queue delivery for webhook %d: %w", w.ID, err)
+		}
+		queued = queued || created
+	}
+	if queued {
+		d.Notify()
+	}
+	return nil
+}
+
+// Notify wakes the worker, e.g. after a delivery was queued for redelivery.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run attempts due deliveries until ctx is cancelled, then waits for the
+// attempts in flight.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		// Keep going while there is a backlog
+		for ctx.Err() == nil {
+			if d.runBatch(ctx) < d.config.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			log.Println("Webhook dispatcher stopped")
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// runBatch attempts one batch of due deliveries and returns how many there were.
+func (d *Dispatcher) runBatch(ctx context.Context) int {
+	// Leased for longer than an attempt can take, so that no other instance
+	// picks them up in the meantime
+	leaseUntil := time.Now().UTC().Add(2 * d.config.Timeout)
+	deliveries, err := d.store.ClaimDueDeliveries(ctx, leaseUntil, d.config.BatchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to claim webhook deliveries: %v", err)
+		}
+		return 0
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery model.WebhookDelivery) {
+			defer wg.Done()
+			d.attempt(delivery)
+		}(delivery)
+	}
+	wg.Wait()
+	return len(deliveries)
+}
+
+// attempt POSTs one delivery and records the outcome. It isn't tied to the
+// Run context so that an attempt in flight at shutdown is still recorded.
+func (d *Dispatcher) attempt(delivery model.WebhookDelivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*d.config.Timeout)
+	defer cancel()
+
+	webhook, err := d.store.GetWebhook(ctx, delivery.WebhookID)
+	if errors.Is(err, errs.ErrWebhookNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load webhook %d: %v", delivery.WebhookID, err)
+		return
+	}
+	if !webhook.Active {
+		d.record(ctx, model.DeliveryAttempt{
+			DeliveryID: delivery.ID,
+			Status:     model.DeliveryFailed,
+			LastError:  strPtr("webhook is disabled"),
+		})
+		return
+	}
+
+	status, err := d.post(ctx, webhook, delivery)
+	attempt := model.DeliveryAttempt{DeliveryID: delivery.ID}
+	if status != 0 {
+		code := int32(status)
+		attempt.ResponseStatus = &code
+	}
+	if err == nil {
+		attempt.Status = model.DeliverySucceeded
+		d.record(ctx, attempt)
+		if webhook.ConsecutiveFailures > 0 {
+			if err := d.store.RecordWebhookSuccess(ctx, webhook.ID); err != nil {
+				log.Printf("Failed to reset failures of webhook %d: %v", webhook.ID, err)
+			}
+		}
+		return
+	}
+
+	attempt.LastError = strPtr(err.Error())
+	attempts := int(delivery.Attempts) + 1
+	if attempts < d.config.MaxAttempts {
+		attempt.Status = model.DeliveryPending
+		next := time.Now().UTC().Add(d.backoff(attempts))
+		attempt.NextAttemptAt = &next
+		d.record(ctx, attempt)
+		return
+	}
+
+	log.Printf("Webhook delivery %d failed after %d attempts: %v", delivery.ID, attempts, err)
+	attempt.Status = model.DeliveryFailed
+	d.record(ctx, attempt)
+	active, err := d.store.RecordWebhookFailure(ctx, webhook.ID, d.config.MaxFailures)
+	if err != nil {
+		log.Printf("Failed to record failure of webhook %d: %v", webhook.ID, err)
+		return
+	}
+	if !active {
+		log.Printf("Disabled webhook %d after %d failed deliveries in a row", webhook.ID, d.config.MaxFailures)
+	}
+}
+
+func (d *Dispatcher) record(ctx context.Context, attempt model.DeliveryAttempt) {
+	if err := d.store.RecordAttempt(ctx, attempt); err != nil {
+		log.Printf("Failed to record webhook delivery %d: %v", attempt.DeliveryID, err)
+	}
+}
+
+// post sends the delivery and returns the response status. Anything but a
+// 2xx is an error.
+func (d *Dispatcher) post(ctx context.Context, webhook model.Webhook, delivery model.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookID, strconv.FormatInt(webhook.ID, 10))
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff is the wait before the next attempt: InitialBackoff doubled for
+// every attempt so far, capped at MaxBackoff, with up to 20% jitter so that
+// retries of a broken endpoint don't all land at once.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.config.MaxBackoff
+	if shift := attempts - 1; shift < 32 {
+		if b := d.config.InitialBackoff << shift; b > 0 && b < wait {
+			wait = b
+		}
+	}
+	return wait - time.Duration(rand.Int63n(int64(wait)/5+1))
+}
+
+// Sign returns the X-Webhook-Signature of a delivery: the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the webhook's secret. Receivers should
+// recompute it and reject stale timestamps.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,370 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+)
+
+// fakeStore keeps webhooks and deliveries in memory
+type fakeStore struct {
+	mu         sync.Mutex
+	webhooks   map[int64]*model.Webhook
+	deliveries map[int64]*model.WebhookDelivery
+	nextID     int64
+	// failCreate, when set, can fail CreateDelivery for a webhook
+	failCreate func(webhookID int64) error
+}
+
+func newFakeStore(webhooks ...model.Webhook) *fakeStore {
+	s := &fakeStore{
+		webhooks:   make(map[int64]*model.Webhook),
+		deliveries: make(map[int64]*model.WebhookDelivery),
+	}
+	for i := range webhooks {
+		w := webhooks[i]
+		s.webhooks[w.ID] = &w
+	}
+	return s
+}
+
+func (s *fakeStore) ListActiveWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []model.Webhook
+	for _, w := range s.webhooks {
+		if w.Active {
+			out = append(out, *w)
+		}
+	}
+	return out, nil
+}
+
+func (s *fakeStore) GetWebhook(ctx context.Context, webhookID int64) (model.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.webhooks[webhookID]
+	if !ok {
+		return model.Webhook{}, errs.ErrWebhookNotFound
+	}
+	return *w, nil
+}
+
+func (s *fakeStore) RecordWebhookSuccess(ctx context.Context, webhookID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks[webhookID].ConsecutiveFailures = 0
+	return nil
+}
+
+func (s *fakeStore) RecordWebhookFailure(ctx context.Context, webhookID int64, maxFailures int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.webhooks[webhookID]
+	w.ConsecutiveFailures++
+	if int(w.ConsecutiveFailures) >= maxFailures {
+		w.Active = false
+	}
+	return w.Active, nil
+}
+
+func (s *fakeStore) CreateDelivery(ctx context.Context, webhookID int64, event model.UserEvent, payload []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failCreate != nil {
+		if err := s.failCreate(webhookID); err != nil {
+			return false, err
+		}
+	}
+	// Like the unique index on the table
+	for _, d := range s.deliveries {
+		if d.WebhookID == webhookID && d.UserID == event.UserID && d.Sequence == event.Sequence && d.EventType == event.Type && event.Sequence > 0 {
+			return false, nil
+		}
+	}
+	s.nextID++
+	now := time.Now().UTC()
+	d := &model.WebhookDelivery{
+		ID:            s.nextID,
+		WebhookID:     webhookID,
+		EventType:     event.Type,
+		UserID:        event.UserID,
+		Sequence:      event.Sequence,
+		Payload:       payload,
+		Status:        model.DeliveryPending,
+		NextAttemptAt: &now,
+	}
+	s.deliveries[d.ID] = d
+	return true, nil
+}
+
+func (s *fakeStore) ClaimDueDeliveries(ctx context.Context, leaseUntil time.Time, limit int) ([]model.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []model.WebhookDelivery
+	now := time.Now().UTC()
+	for _, d := range s.deliveries {
+		if len(out) == limit {
+			break
+		}
+		if d.Status == model.DeliveryPending && !d.NextAttemptAt.After(now) {
+			lease := leaseUntil
+			d.NextAttemptAt = &lease
+			out = append(out, *d)
+		}
+	}
+	return out, nil
+}
+
+func (s *fakeStore) RecordAttempt(ctx context.Context, attempt model.DeliveryAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.deliveries[attempt.DeliveryID]
+	d.Status = attempt.Status
+	d.Attempts++
+	d.ResponseStatus = attempt.ResponseStatus
+	d.LastError = attempt.LastError
+	d.NextAttemptAt = attempt.NextAttemptAt
+	return nil
+}
+
+func (s *fakeStore) delivery(id int64) model.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.deliveries[id]
+}
+
+func (s *fakeStore) webhook(id int64) model.Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.webhooks[id]
+}
+
+func (s *fakeStore) deliveryCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.deliveries)
+}
+
+func runDispatcher(t *testing.T, d *Dispatcher) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestDeliverySigned(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{header: r.Header, body: body}
+	}))
+	defer server.Close()
+
+	store := newFakeStore(
+		model.Webhook{ID: 1, URL: server.URL, Secret: "s3cret", Active: true, EventTypes: []string{model.EventUserCreated}},
+		model.Webhook{ID: 2, URL: server.URL, Secret: "other", Active: true, EventTypes: []string{model.EventUserDeleted}},
+	)
+	// The test server listens on loopback
+	d := NewDispatcher(store, Config{PollInterval: 10 * time.Millisecond, AllowPrivateTargets: true})
+	runDispatcher(t, d)
+
+	event := model.UserEvent{Type: model.EventUserCreated, UserID: 7, Sequence: 1, User: model.User{ID: 7, Email: "a@example.com"}}
+	require.NoError(t, d.Enqueue(context.Background(), event))
+	require.Equal(t, 1, store.deliveryCount(), "only the subscribed webhook gets a delivery")
+
+	select {
+	case got := <-requests:
+		require.Equal(t, "1", got.header.Get(HeaderWebhookID))
+		require.Equal(t, "1", got.header.Get(HeaderDelivery))
+		require.Equal(t, model.EventUserCreated, got.header.Get(HeaderEvent))
+		require.Equal(t, Sign("s3cret", got.header.Get(HeaderTimestamp), got.body), got.header.Get(HeaderSignature))
+		require.JSONEq(t, `{"type":"user_created","user_id":7,"sequence":1,"user":{"id":7,"first_name":"","last_name":"","email":"a@example.com","version":0}}`, string(got.body))
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+
+	require.Eventually(t, func() bool {
+		return store.delivery(1).Status == model.DeliverySucceeded
+	}, 2*time.Second, 10*time.Millisecond)
+	require.EqualValues(t, 200, *store.delivery(1).ResponseStatus)
+}
+
+func TestRetriesThenDisables(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	store := newFakeStore(model.Webhook{ID: 1, URL: server.URL, Secret: "s", Active: true})
+	d := NewDispatcher(store, Config{
+		MaxAttempts:         3,
+		InitialBackoff:      time.Millisecond,
+		MaxBackoff:          5 * time.Millisecond,
+		MaxFailures:         2,
+		PollInterval:        5 * time.Millisecond,
+		AllowPrivateTargets: true,
+	})
+	runDispatcher(t, d)
+
+	require.NoError(t, d.Enqueue(context.Background(), model.UserEvent{Type: model.EventUserUpdated, UserID: 1, Sequence: 1}))
+	require.Eventually(t, func() bool {
+		return store.delivery(1).Status == model.DeliveryFailed
+	}, 2*time.Second, 5*time.Millisecond)
+	first := store.delivery(1)
+	require.EqualValues(t, 3, first.Attempts)
+	require.EqualValues(t, 500, *first.ResponseStatus)
+	require.Equal(t, "unexpected status 500", *first.LastError)
+	require.True(t, store.webhook(1).Active, "one failed delivery doesn't disable the webhook")
+
+	require.NoError(t, d.Enqueue(context.Background(), model.UserEvent{Type: model.EventUserUpdated, UserID: 1, Sequence: 2}))
+	require.Eventually(t, func() bool {
+		return !store.webhook(1).Active
+	}, 2*time.Second, 5*time.Millisecond)
+	require.EqualValues(t, 6, calls.Load())
+
+	// Disabled webhooks get no new deliveries
+	require.NoError(t, d.Enqueue(context.Background(), model.UserEvent{Type: model.EventUserUpdated, UserID: 1, Sequence: 3}))
+	require.Equal(t, 2, store.deliveryCount())
+}
+
+func TestEnqueueAgainAfterPartialFailure(t *testing.T) {
+	store := newFakeStore(
+		model.Webhook{ID: 1, URL: "http://one.example.com", Active: true},
+		model.Webhook{ID: 2, URL: "http://two.example.com", Active: true},
+		model.Webhook{ID: 3, URL: "http://three.example.com", Active: true},
+	)
+	d := NewDispatcher(store, Config{})
+	event := model.UserEvent{Type: model.EventUserCreated, UserID: 7, Sequence: 1}
+
+	// Whichever webhook comes second fails, after the first was queued
+	var calls int
+	store.failCreate = func(int64) error {
+		if calls++; calls == 2 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+	require.Error(t, d.Enqueue(context.Background(), event))
+	require.Equal(t, 1, store.deliveryCount())
+
+	store.failCreate = nil
+	require.NoError(t, d.Enqueue(context.Background(), event))
+	require.NoError(t, d.Enqueue(context.Background(), event))
+	require.Equal(t, 3, store.deliveryCount(), "one delivery per webhook")
+
+	// A later event for the same user is a new delivery
+	require.NoError(t, d.Enqueue(context.Background(), model.UserEvent{Type: model.EventUserCreated, UserID: 7, Sequence: 2}))
+	require.Equal(t, 6, store.deliveryCount())
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(newFakeStore(), Config{InitialBackoff: time.Second, MaxBackoff: time.Minute})
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 10: time.Minute, 100: time.Minute} {
+		got := d.backoff(attempts)
+		require.LessOrEqual(t, got, want)
+		require.GreaterOrEqual(t, got, want-want/5)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	d := NewDispatcher(newFakeStore(), Config{})
+	for raw, want := range map[string]error{
+		"https://partner.example.com/hook": nil,
+		"http://203.0.113.10:8080/hook":    nil,
+		"http://localhost:8080/hook":       ErrForbiddenTarget,
+		"http://api.localhost./hook":       ErrForbiddenTarget,
+		"http://127.0.0.1/hook":            ErrForbiddenTarget,
+		"http://10.1.2.3/hook":             ErrForbiddenTarget,
+		"http://192.168.0.1/hook":          ErrForbiddenTarget,
+		"http://169.254.169.254/latest":    ErrForbiddenTarget,
+		"http://100.64.0.1/hook":           ErrForbiddenTarget,
+		"http://[::1]/hook":                ErrForbiddenTarget,
+		"http://[fd00::1]/hook":            ErrForbiddenTarget,
+		"http://[::ffff:10.0.0.1]/hook":    ErrForbiddenTarget,
+		"http://0.0.0.0/hook":              ErrForbiddenTarget,
+	} {
+		require.ErrorIs(t, d.CheckURL(raw), want, raw)
+	}
+	for _, raw := range []string{"ftp://example.com/hook", "/hook", "http://", "::"} {
+		require.Error(t, d.CheckURL(raw), raw)
+	}
+
+	allowed := NewDispatcher(newFakeStore(), Config{AllowPrivateTargets: true})
+	require.NoError(t, allowed.CheckURL("http://127.0.0.1/hook"))
+}
+
+// A name that resolves to a private address only shows itself when dialled
+func TestPrivateTargetsAreNotDialled(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	store := newFakeStore(model.Webhook{ID: 1, URL: strings.Replace(server.URL, "127.0.0.1", "localtest.invalid", 1), Active: true})
+	d := NewDispatcher(store, Config{MaxAttempts: 1})
+	// Resolve the name to the test server's loopback address
+	d.client.Transport.(*http.Transport).DialContext = dialVia(d.client.Transport.(*http.Transport).DialContext, server.Listener.Addr().String())
+
+	require.NoError(t, d.Enqueue(context.Background(), model.UserEvent{Type: model.EventUserCreated, UserID: 1, Sequence: 1}))
+	d.runBatch(context.Background())
+	delivery := store.delivery(1)
+	require.Equal(t, model.DeliveryFailed, delivery.Status)
+	require.Contains(t, *delivery.LastError, ErrForbiddenTarget.Error())
+	require.Zero(t, calls.Load())
+}
+
+// dialVia dials addr whatever address is asked for, like a DNS record would
+func dialVia(dial func(ctx context.Context, network, address string) (net.Conn, error), addr string) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return dial(ctx, network, addr)
+	}
+}
+
+func TestRedirectsAreNotFollowed(t *testing.T) {
+	var followed atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed.Add(1)
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	store := newFakeStore(model.Webhook{ID: 1, URL: server.URL, Active: true})
+	d := NewDispatcher(store, Config{MaxAttempts: 1, AllowPrivateTargets: true})
+	require.NoError(t, d.Enqueue(context.Background(), model.UserEvent{Type: model.EventUserCreated, UserID: 1, Sequence: 1}))
+	d.runBatch(context.Background())
+
+	delivery := store.delivery(1)
+	require.Equal(t, model.DeliveryFailed, delivery.Status)
+	require.EqualValues(t, http.StatusTemporaryRedirect, *delivery.ResponseStatus)
+	require.Zero(t, followed.Load())
+}
@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenTarget is returned for webhook URLs, and connections, aimed at
+// this network rather than the internet.
+var ErrForbiddenTarget = errors.New("webhook must not target a private, loopback or link-local address")
+
+// sharedAddressSpace is carrier-grade NAT (RFC 6598), which IsPrivate leaves out
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// forbiddenAddr reports whether addr is somewhere a webhook must not reach:
+// this host, the private network around it, or link-local services such as
+// cloud metadata endpoints.
+func forbiddenAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() ||
+		sharedAddressSpace.Contains(addr)
+}
+
+// CheckURL accepts absolute http(s) URLs. Unless private targets are
+// allowed, it also rejects hosts that are plainly internal; names are
+// checked again as they are dialled, since they may resolve differently
+// by then.
+func (d *Dispatcher) CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if d.config.AllowPrivateTargets {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrForbiddenTarget
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && forbiddenAddr(addr) {
+		return ErrForbiddenTarget
+	}
+	return nil
+}
+
+// newClient returns the client deliveries are POSTed with. Redirects aren't
+// followed, so a 3xx is a failed attempt rather than a way around the checks.
+func newClient(config Config) *http.Client {
+	dialer := &net.Dialer{Timeout: config.Timeout, KeepAlive: 30 * time.Second}
+	if !config.AllowPrivateTargets {
+		// Control sees the address actually dialled, after DNS resolution
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if forbiddenAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenTarget, addrPort.Addr())
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Through a proxy only the proxy's address would be checked
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   config.Timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -2,50 +2,123 @@ package ws
 
 import (
 	"encoding/json"
+	"fmt"
+	"hash/fnv"
 	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
 )
 
 var (
-	pongWait     = 10 * time.Second
-	pingInterval = (pongWait * 9) / 10
+	// closeGracePeriod is how long we wait for the peer to answer our close frame
+	closeGracePeriod = time.Second
+
+	// maxInFlight caps how many requests one client can have pipelined
+	maxInFlight = 32
 )
 
+// userLanes is how many lanes a client's writes are spread over by user
+const userLanes = 8
+
 // ClientList is a map of clients to their connection status
 type ClientList map[*Client]bool
 
-// Client represents a single client connection
+// Client represents a single client connection. Only writeMessages writes to
+// conn; everything else, including pongs and close frames, goes through queue.
 type Client struct {
 	conn    *websocket.Conn
 	manager *Manager
-	egress  chan Message //[]byte
+	// id, remoteAddr and connectedAt describe the connection for the admin API
+	id          string
+	remoteAddr  string
+	connectedAt time.Time
+	// protocol is the negotiated subprotocol, empty for the Message format
+	protocol string
+	// queue holds outbound frames (Message or Response) for the writer
+	queue *sendQueue
+	// done is closed when the client is removed
+	done chan struct{}
+	// readDone is closed when readMessages returns
+	readDone chan struct{}
+	inFlight chan struct{}
+	// lanes run the writes for one user in the order they arrived; a lane
+	// is started on first use and only the reader touches the slice
+	lanes [userLanes]chan Message
+
+	// principal identifies who opened the connection, for rate limiting
+	principal string
+	// user is the authenticated user, if any; idempotency keys are scoped to it
+	user             string
+	limiter          *rate.Limiter
+	principalLimiter *rate.Limiter
+	// violations counts rate-limited messages since violationsSince; only
+	// the reader touches them
+	violations      int
+	violationsSince time.Time
+
+	// Counters reported by the admin API
+	messagesReceived atomic.Int64
+	messagesSent     atomic.Int64
+	rateLimited      atomic.Int64
+
+	// subscriptions and resuming are guarded by the manager lock
+	subscriptions map[string]*subscriptionEntry
+	nextSubID     int
+	resuming      bool
 }
 
-func NewClient(conn *websocket.Conn, manager *Manager) *Client {
+func NewClient(conn *websocket.Conn, manager *Manager, principal, user string) *Client {
+	config := manager.config
 	return &Client{
-		conn:    conn,
-		manager: manager,
-		egress:  make(chan Message),
+		conn:             conn,
+		manager:          manager,
+		id:               fmt.Sprintf("conn-%d", manager.nextConnID.Add(1)),
+		remoteAddr:       conn.RemoteAddr().String(),
+		connectedAt:      time.Now(),
+		protocol:         conn.Subprotocol(),
+		principal:        principal,
+		user:             user,
+		limiter:          rate.NewLimiter(rate.Limit(config.RateLimit), config.RateBurst),
+		principalLimiter: manager.principals.acquire(principal),
+		queue:            newSendQueue(manager.config.SendQueueSize, manager.config.OverflowPolicy),
+		done:             make(chan struct{}),
+		readDone:         make(chan struct{}),
+		inFlight:         make(chan struct{}, maxInFlight),
+		subscriptions:    make(map[string]*subscriptionEntry),
 	}
 }
 
 func (c *Client) readMessages() {
 	defer func() {
-		// clean up connection
-		c.manager.removeClient(c)
+		close(c.readDone)
+		// Lanes finish the writes already handed to them
+		for _, lane := range c.lanes {
+			if lane != nil {
+				close(lane)
+			}
+		}
+		// No-op if a close frame is already on its way; otherwise the
+		// connection was lost and the writer just tears it down
+		c.queue.close(nil)
 	}()
-	if err := c.conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.manager.config.PongWait)); err != nil {
 		log.Println("Error setting read deadline:", err)
 		return
 	}
 
-	// sometimes they will send really big message
-	c.conn.SetReadLimit(512)
+	// Larger messages close the connection with 1009 (message too big)
+	c.conn.SetReadLimit(c.manager.config.ReadLimit)
 
 	// whenever we receive a pong message it will trigger the func that we assign
 	c.conn.SetPongHandler(c.pongHandler)
+	// The default ping and close handlers write from this goroutine
+	c.conn.SetPingHandler(c.pingHandler)
+	c.conn.SetCloseHandler(c.closeHandler)
 
 	for {
 		_, payload, err := c.conn.ReadMessage()
@@ -55,54 +128,169 @@ func (c *Client) readMessages() {
 			}
 			break
 		}
+		c.messagesReceived.Add(1)
+		// Once we've sent a close frame, only the peer's close frame matters
+		if c.queue.isClosed() {
+			continue
+		}
+
+		if c.protocol == SubprotocolJSONRPC {
+			c.handleRPCFrame(payload)
+			continue
+		}
 
 		var request Message
 		if err := json.Unmarshal(payload, &request); err != nil {
 			log.Printf("error marshaling event : %v", err)
-			break
+			c.close(websocket.CloseInvalidFramePayloadData, "invalid JSON")
+			continue
 		}
+		c.dispatch(request)
+	}
+}
 
-		if err := c.manager.routeEvent(request, c); err != nil {
-			log.Printf("error handling message : %v", err)
-		}
+// dispatch handles requests concurrently so one slow request doesn't hold up
+// the rest; responses are matched up by their ID. Writes to one user go
+// through the same lane instead, so they reach the service in the order they
+// were sent.
+func (c *Client) dispatch(request Message) {
+	if ok, retryAfter := c.allow(); !ok {
+		c.rejectRateLimited(request, retryAfter)
+		return
+	}
+	c.inFlight <- struct{}{}
+	if key, ok := laneKey(request); ok {
+		c.lane(key) <- request
+		return
+	}
+	go func() {
+		defer func() { <-c.inFlight }()
+		c.handle(request)
+	}()
+}
+
+func (c *Client) handle(request Message) {
+	if err := c.manager.routeEvent(request, c); err != nil {
+		log.Printf("error handling message : %v", err)
+	}
+}
+
+// lane returns the lane for key, starting it if need be. Lanes are buffered
+// for maxInFlight requests, so handing one over never blocks.
+func (c *Client) lane(key uint64) chan Message {
+	i := key % userLanes
+	if c.lanes[i] == nil {
+		lane := make(chan Message, maxInFlight)
+		c.lanes[i] = lane
+		go func() {
+			for request := range lane {
+				c.handle(request)
+				<-c.inFlight
+			}
+		}()
+	}
+	return c.lanes[i]
+}
+
+// laneKey picks the user a write is for, as the service shards them: by user
+// ID, or by email for creates. Other requests have no key.
+func laneKey(request Message) (uint64, bool) {
+	var target struct {
+		UserID int64  `json:"user_id"`
+		Email  string `json:"email"`
 	}
+	switch request.Type {
+	case "create_user", "update_user", "delete_user":
+	default:
+		return 0, false
+	}
+	data, err := json.Marshal(request.Payload)
+	if err != nil || json.Unmarshal(data, &target) != nil {
+		// Rejected by the handler anyway
+		return 0, false
+	}
+	h := fnv.New64a()
+	if request.Type == "create_user" {
+		h.Write([]byte("email:" + strings.ToLower(target.Email)))
+	} else {
+		h.Write([]byte("user:" + strconv.FormatInt(target.UserID, 10)))
+	}
+	return h.Sum64(), true
+}
+
+// send queues a frame for the writer without blocking.
+func (c *Client) send(frame interface{}) {
+	c.enqueue(outbound{frame: frame})
+}
+
+// sendEvent queues a pushed event, which may be coalesced with an older
+// event for the same user.
+func (c *Client) sendEvent(e sequencedEvent) {
+	c.enqueue(outbound{
+		frame:       eventMessage(c.manager.epoch, e),
+		event:       true,
+		coalesceKey: strconv.FormatInt(e.event.UserID, 10),
+	})
+}
+
+// notify queues any other pushed event, e.g. presence, which a full queue
+// may drop like a user event.
+func (c *Client) notify(message Message) {
+	c.enqueue(outbound{frame: message, event: true})
 }
 
+func (c *Client) enqueue(o outbound) {
+	if !c.queue.push(o) {
+		c.disconnectSlow()
+	}
+}
+
+// disconnectSlow drops a client that can't keep up.
+func (c *Client) disconnectSlow() {
+	log.Println("Disconnecting slow websocket client")
+	wsMetrics.Add("slow_disconnects", 1)
+	c.close(websocket.ClosePolicyViolation, "send queue full")
+}
+
+// close starts the closing handshake: the writer flushes what's queued, sends
+// a close frame with the code and reason, then waits briefly for the peer's.
+func (c *Client) close(code int, reason string) {
+	c.queue.close(websocket.FormatCloseMessage(code, reason))
+}
+
+// writeMessages is the only goroutine that writes to the connection. It owns
+// the teardown: when it returns the connection is closed and the client removed.
 func (c *Client) writeMessages() {
+	ticker := time.NewTicker(c.manager.config.PingInterval)
 	defer func() {
+		ticker.Stop()
+		c.queue.discard()
+		if err := c.conn.Close(); err != nil {
+			log.Printf("connection closed: %v", err)
+		}
 		c.manager.removeClient(c)
 	}()
 
-	ticker := time.NewTicker(pingInterval)
-	defer ticker.Stop() // Stop the ticker when the function exits
-
 	for {
 		select {
-		case message, ok := <-c.egress: // reading
-			if !ok {
-				if err := c.conn.WriteMessage(websocket.CloseMessage, nil); err != nil {
-					log.Printf("connection closed: %v", err)
+		case <-c.queue.ready: // frames queued or queue closed
+			items, closeMsg, closed := c.queue.drain()
+			for _, item := range items {
+				if err := c.write(item); err != nil {
+					log.Printf("Failed to send message: %v", err)
+					return
 				}
-				return
 			}
-
-			data, err := json.Marshal(message)
-			if err != nil {
-				log.Printf("error marshaling event : %v", err)
+			if closed {
+				c.finishClose(closeMsg)
 				return
 			}
 
-			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-				log.Printf("Failed to send message: %v", err)
-				return
-			}
-			log.Printf("message sent")
-
 		case <-ticker.C: // receive a value from the channel
 			log.Println("ping")
 
 			// send a Ping to the client
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.writeFrame(websocket.PingMessage, nil); err != nil {
 				log.Printf("Failed to send ping: %v", err)
 				return
 			}
@@ -110,7 +298,70 @@ func (c *Client) writeMessages() {
 	}
 }
 
+// write encodes and writes one queued frame.
+func (c *Client) write(item outbound) error {
+	if item.control != 0 {
+		return c.writeFrame(item.control, item.frame.([]byte))
+	}
+	data, err := c.encode(item.frame)
+	if err != nil {
+		return err
+	}
+	if err := c.writeFrame(websocket.TextMessage, data); err != nil {
+		return err
+	}
+	c.messagesSent.Add(1)
+	return nil
+}
+
+func (c *Client) writeFrame(messageType int, data []byte) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.manager.config.WriteWait)); err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// finishClose sends the close frame and waits for the peer to answer, which
+// ends the reader. A nil closeMsg means the connection is already gone.
+func (c *Client) finishClose(closeMsg []byte) {
+	if closeMsg == nil {
+		return
+	}
+	if err := c.writeFrame(websocket.CloseMessage, closeMsg); err != nil {
+		log.Printf("connection closed: %v", err)
+		return
+	}
+	select {
+	case <-c.readDone:
+	case <-time.After(closeGracePeriod):
+	}
+}
+
+// encode marshals an outbound frame in the client's wire format.
+func (c *Client) encode(frame interface{}) ([]byte, error) {
+	if event, ok := frame.(Message); ok && c.protocol == SubprotocolJSONRPC {
+		return json.Marshal(rpcEvent(event))
+	}
+	return json.Marshal(frame)
+}
+
 func (c *Client) pongHandler(_ string) error {
 	log.Println("pong")
-	return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	return c.conn.SetReadDeadline(time.Now().Add(c.manager.config.PongWait))
+}
+
+// pingHandler queues the pong for the writer instead of writing it here.
+func (c *Client) pingHandler(data string) error {
+	c.queue.push(outbound{frame: []byte(data), control: websocket.PongMessage})
+	return nil
+}
+
+// closeHandler echoes the peer's close code, completing the handshake.
+func (c *Client) closeHandler(code int, _ string) error {
+	msg := []byte{}
+	if code != websocket.CloseNoStatusReceived {
+		msg = websocket.FormatCloseMessage(code, "")
+	}
+	c.queue.close(msg)
+	return nil
 }
@@ -0,0 +1,681 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/command"
+	"UserManagement/internal/idempotency"
+	"UserManagement/internal/model"
+)
+
+// newFakeBus answers get_users with an empty user list
+func newFakeBus() *command.Bus {
+	bus := command.NewBus()
+	command.Register(bus, func(ctx context.Context, cmd model.ListUsers) ([]model.User, error) {
+		return []model.User{}, nil
+	})
+	return bus
+}
+
+func newTestManager(t *testing.T, config Config) (*Manager, *websocket.Conn) {
+	m, err := NewManager(newFakeBus(), config)
+	require.NoError(t, err)
+	return m, connect(t, m)
+}
+
+// connect serves m and dials it as its only client
+func connect(t *testing.T, m *Manager) *websocket.Conn {
+	return connectAs(t, m, "")
+}
+
+// connectAs dials m as user, vouched for by the test server's address as a
+// trusted proxy, offering subprotocols; an empty user connects anonymously.
+func connectAs(t *testing.T, m *Manager, user string, subprotocols ...string) *websocket.Conn {
+	identity, err := NewIdentity([]string{"127.0.0.1/32"})
+	require.NoError(t, err)
+	server := httptest.NewServer(identity.Middleware(http.HandlerFunc(m.ServeWS)))
+	t.Cleanup(server.Close)
+
+	header := http.Header{}
+	if user != "" {
+		header.Set(ForwardedUserHeader, user)
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	dialer := websocket.Dialer{Subprotocols: subprotocols}
+	conn, _, err := dialer.Dial(url, header)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	require.Eventually(t, func() bool { return clientCount(m) == 1 }, time.Second, 10*time.Millisecond)
+	return conn
+}
+
+func clientCount(m *Manager) int {
+	m.RLock()
+	defer m.RUnlock()
+	return len(m.clients)
+}
+
+func onlyClient(m *Manager) *Client {
+	m.RLock()
+	defer m.RUnlock()
+	for c := range m.clients {
+		return c
+	}
+	return nil
+}
+
+// readCloseError reads until the server's close frame arrives.
+func readCloseError(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		closeErr, ok := err.(*websocket.CloseError)
+		require.True(t, ok, "expected a close frame, got %v", err)
+		return closeErr
+	}
+}
+
+func TestConcurrentRepliesAndBroadcasts(t *testing.T) {
+	m, conn := newTestManager(t, Config{RateBurst: 100})
+	const n = 50
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			if err := conn.WriteJSON(Message{ID: fmt.Sprint(i), Type: "get_users"}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			m.Broadcast(model.UserEvent{Type: model.EventUserUpdated, UserID: int64(i)})
+		}
+	}()
+
+	replies := make(map[string]bool)
+	var lastSeq int64
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for len(replies) < n || lastSeq < n {
+		var frame struct {
+			ID     string `json:"id"`
+			Kind   string `json:"kind"`
+			Seq    int64  `json:"seq"`
+			Status string `json:"status"`
+		}
+		require.NoError(t, conn.ReadJSON(&frame))
+		switch frame.Kind {
+		case KindResponse:
+			require.Equal(t, "success", frame.Status)
+			replies[frame.ID] = true
+		case KindEvent:
+			require.Equal(t, lastSeq+1, frame.Seq)
+			lastSeq = frame.Seq
+		}
+	}
+	wg.Wait()
+}
+
+func TestPeerCloseIsEchoed(t *testing.T) {
+	m, conn := newTestManager(t, Config{})
+
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye")
+	require.NoError(t, conn.WriteMessage(websocket.CloseMessage, msg))
+
+	closeErr := readCloseError(t, conn)
+	require.Equal(t, websocket.CloseNormalClosure, closeErr.Code)
+	require.Eventually(t, func() bool { return clientCount(m) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestInvalidJSONClosesWithReason(t *testing.T) {
+	m, conn := newTestManager(t, Config{})
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("{not json")))
+
+	closeErr := readCloseError(t, conn)
+	require.Equal(t, websocket.CloseInvalidFramePayloadData, closeErr.Code)
+	require.Equal(t, "invalid JSON", closeErr.Text)
+	require.Eventually(t, func() bool { return clientCount(m) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestSlowConsumerIsDisconnected(t *testing.T) {
+	m, conn := newTestManager(t, Config{SendQueueSize: 1, OverflowPolicy: OverflowDisconnect})
+
+	onlyClient(m).disconnectSlow()
+
+	closeErr := readCloseError(t, conn)
+	require.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+	require.Equal(t, "send queue full", closeErr.Text)
+	require.Eventually(t, func() bool { return clientCount(m) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestPingIsAnswered(t *testing.T) {
+	_, conn := newTestManager(t, Config{})
+
+	pong := make(chan string, 1)
+	conn.SetPongHandler(func(data string) error {
+		pong <- data
+		return nil
+	})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	require.NoError(t, conn.WriteControl(websocket.PingMessage, []byte("hello"), time.Now().Add(time.Second)))
+	select {
+	case data := <-pong:
+		require.Equal(t, "hello", data)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no pong")
+	}
+}
+
+func TestSendQueueOverflowPolicies(t *testing.T) {
+	event := func(key string) outbound { return outbound{frame: key, event: true, coalesceKey: key} }
+	frames := func(items []outbound) []interface{} {
+		var out []interface{}
+		for _, item := range items {
+			out = append(out, item.frame)
+		}
+		return out
+	}
+
+	q := newSendQueue(2, OverflowDropOldest)
+	for _, key := range []string{"a", "b", "c"} {
+		require.True(t, q.push(event(key)))
+	}
+	items, _, _ := q.drain()
+	require.Equal(t, []interface{}{"b", "c"}, frames(items))
+
+	// The newer event for "a" moves to the back
+	q = newSendQueue(2, OverflowCoalesce)
+	for _, key := range []string{"a", "b", "a"} {
+		require.True(t, q.push(event(key)))
+	}
+	items, _, _ = q.drain()
+	require.Equal(t, []interface{}{"b", "a"}, frames(items))
+
+	q = newSendQueue(1, OverflowDisconnect)
+	require.True(t, q.push(event("a")))
+	require.False(t, q.push(event("b")))
+	// Control frames are never refused, and a queued pong takes the
+	// payload of a newer ping instead of piling up
+	for _, data := range []string{"1", "2", "3"} {
+		require.True(t, q.push(outbound{frame: []byte(data), control: websocket.PongMessage}))
+	}
+	items, _, _ = q.drain()
+	require.Equal(t, []interface{}{"a", []byte("3")}, frames(items))
+
+	q.close(nil)
+	items, closeMsg, closed := q.drain()
+	require.Empty(t, items)
+	require.Nil(t, closeMsg)
+	require.True(t, closed)
+}
+
+func TestFullSendQueueKeepsResponses(t *testing.T) {
+	event := func(key string) outbound { return outbound{frame: key, event: true, coalesceKey: key} }
+	response := func(id string) outbound { return outbound{frame: id} }
+	frames := func(q *sendQueue) []interface{} {
+		items, _, _ := q.drain()
+		var out []interface{}
+		for _, item := range items {
+			out = append(out, item.frame)
+		}
+		return out
+	}
+
+	for _, policy := range []string{OverflowDropOldest, OverflowCoalesce} {
+		t.Run(policy, func(t *testing.T) {
+			q := newSendQueue(3, policy)
+			require.True(t, q.push(response("r1")))
+			require.True(t, q.push(event("a")))
+			require.True(t, q.push(event("b")))
+			// The oldest event makes room, not the response ahead of it
+			require.True(t, q.push(event("c")))
+			require.True(t, q.push(response("r2")))
+			require.Equal(t, []interface{}{"r1", "c", "r2"}, frames(q))
+
+			// With nothing but responses queued, events are dropped and
+			// responses still get through
+			for _, id := range []string{"r3", "r4", "r5"} {
+				require.True(t, q.push(response(id)))
+			}
+			require.True(t, q.push(event("d")))
+			require.True(t, q.push(response("r6")))
+			require.Equal(t, []interface{}{"r3", "r4", "r5", "r6"}, frames(q))
+		})
+	}
+}
+
+func TestShutdownSendsGoingAway(t *testing.T) {
+	m, conn := newTestManager(t, Config{})
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		done <- m.Shutdown(ctx)
+	}()
+
+	closeErr := readCloseError(t, conn)
+	require.Equal(t, websocket.CloseGoingAway, closeErr.Code)
+	require.NoError(t, <-done)
+	require.Zero(t, clientCount(m))
+}
+
+func TestCheckOrigin(t *testing.T) {
+	request := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://api.example.com/ws_users", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	m, err := NewManager(newFakeBus(), Config{AllowedOrigins: []string{"https://app.example.com"}})
+	require.NoError(t, err)
+	require.True(t, m.checkOrigin(request("")))
+	require.True(t, m.checkOrigin(request("https://app.example.com")))
+	require.False(t, m.checkOrigin(request("https://evil.example.com")))
+
+	// Without an allowlist only the same host is accepted
+	m, err = NewManager(newFakeBus(), Config{})
+	require.NoError(t, err)
+	require.True(t, m.checkOrigin(request("https://api.example.com")))
+	require.False(t, m.checkOrigin(request("https://app.example.com")))
+}
+
+func TestRateLimitedThenDisconnected(t *testing.T) {
+	_, conn := newTestManager(t, Config{RateLimit: 0.1, RateBurst: 2, MaxRateViolations: 2})
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, conn.WriteJSON(Message{ID: fmt.Sprint(i), Type: "get_users"}))
+	}
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	limited := false
+	for !limited {
+		var resp Response
+		require.NoError(t, conn.ReadJSON(&resp))
+		if resp.Code == ErrCodeRateLimited {
+			require.Equal(t, "2", resp.ID)
+			require.Positive(t, resp.Data.(map[string]interface{})["retry_after_ms"])
+			limited = true
+		}
+	}
+
+	// The second violation disconnects
+	require.NoError(t, conn.WriteJSON(Message{ID: "3", Type: "get_users"}))
+	closeErr := readCloseError(t, conn)
+	require.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+	require.Equal(t, "rate limit exceeded", closeErr.Text)
+}
+
+func TestOversizedMessageIsRejected(t *testing.T) {
+	_, conn := newTestManager(t, Config{ReadLimit: 64})
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 65))))
+	closeErr := readCloseError(t, conn)
+	require.Equal(t, websocket.CloseMessageTooBig, closeErr.Code)
+}
+
+func TestPrincipalOf(t *testing.T) {
+	id, err := NewIdentity([]string{"10.1.0.0/16", "192.168.0.9"})
+	require.NoError(t, err)
+	principal := func(remoteAddr string, header func(r *http.Request)) string {
+		r := httptest.NewRequest(http.MethodGet, "/ws_users", nil)
+		r.RemoteAddr = remoteAddr
+		header(r)
+		var got string
+		id.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = PrincipalOf(r)
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		return got
+	}
+	forwarded := func(r *http.Request) { r.Header.Set(ForwardedUserHeader, "bob") }
+
+	require.Equal(t, "ip:10.0.0.7", principal("10.0.0.7:51234", func(*http.Request) {}))
+	// Only trusted proxies may name the user
+	require.Equal(t, "ip:10.0.0.7", principal("10.0.0.7:51234", forwarded))
+	require.Equal(t, "user:bob", principal("10.1.2.3:443", forwarded))
+	require.Equal(t, "user:bob", principal("192.168.0.9:443", forwarded))
+	// Basic auth passwords are never checked, so the username isn't trusted
+	require.Equal(t, "ip:10.0.0.7", principal("10.0.0.7:51234", func(r *http.Request) { r.SetBasicAuth("alice", "secret") }))
+
+	_, err = NewIdentity([]string{"not-an-ip"})
+	require.Error(t, err)
+}
+
+func TestPresenceAndAdminDisconnect(t *testing.T) {
+	m, first := newTestManager(t, Config{})
+	watcher := onlyClient(m)
+
+	server := httptest.NewServer(http.HandlerFunc(m.ServeWS))
+	t.Cleanup(server.Close)
+	second, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { second.Close() })
+	require.Eventually(t, func() bool { return clientCount(m) == 2 }, time.Second, 10*time.Millisecond)
+
+	var joined Message
+	require.NoError(t, first.SetReadDeadline(time.Now().Add(2*time.Second)))
+	require.NoError(t, first.ReadJSON(&joined))
+	require.Equal(t, EventPresenceJoined, joined.Type)
+
+	conns := m.Connections()
+	require.Len(t, conns, 2)
+	require.Equal(t, watcher.id, conns[0].ID)
+	newcomer := conns[1]
+	require.Equal(t, newcomer.ID, joined.Payload.(map[string]interface{})["id"])
+	require.NotContains(t, joined.Payload, "principal", "who is connected is for operators only")
+
+	require.NoError(t, m.Disconnect(newcomer.ID))
+	closeErr := readCloseError(t, second)
+	require.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+
+	var left Message
+	require.NoError(t, first.ReadJSON(&left))
+	require.Equal(t, EventPresenceLeft, left.Type)
+	require.Equal(t, newcomer.ID, left.Payload.(map[string]interface{})["id"])
+
+	require.ErrorIs(t, m.Disconnect(newcomer.ID), ErrConnectionNotFound)
+}
+
+// memKeys is a minimal idempotency.Store; keys never expire
+type memKeys struct {
+	mu      sync.Mutex
+	records map[string]model.IdempotencyRecord
+}
+
+func (s *memKeys) ClaimKey(ctx context.Context, scope, key, fingerprint string, expiresAt, staleBefore time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[scope+"|"+key]; ok {
+		return false, nil
+	}
+	s.records[scope+"|"+key] = model.IdempotencyRecord{Fingerprint: fingerprint}
+	return true, nil
+}
+
+func (s *memKeys) GetKey(ctx context.Context, scope, key string) (model.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[scope+"|"+key], nil
+}
+
+func (s *memKeys) CompleteKey(ctx context.Context, scope, key string, response model.StoredResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.records[scope+"|"+key]
+	record.Completed, record.Response = true, response
+	s.records[scope+"|"+key] = record
+	return nil
+}
+
+func (s *memKeys) ReleaseKey(ctx context.Context, scope, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, scope+"|"+key)
+	return nil
+}
+
+func (s *memKeys) DeleteExpiredKeys(ctx context.Context) (int64, error) { return 0, nil }
+
+func TestIdempotencyKeyIsReplayed(t *testing.T) {
+	bus := newFakeBus()
+	var deletes atomic.Int32
+	command.Register(bus, func(ctx context.Context, cmd model.DeleteUser) (model.User, error) {
+		deletes.Add(1)
+		return model.User{ID: cmd.UserID}, nil
+	})
+	m, err := NewManager(bus, Config{})
+	require.NoError(t, err)
+	m.Idempotency = idempotency.NewKeys(&memKeys{records: make(map[string]model.IdempotencyRecord)}, time.Hour)
+	conn := connectAs(t, m, "alice")
+
+	send := func(id string, userID int64) Response {
+		require.NoError(t, conn.WriteJSON(Message{
+			ID:             id,
+			Type:           "delete_user",
+			Payload:        map[string]int64{"user_id": userID},
+			IdempotencyKey: "k1",
+		}))
+		var resp Response
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		require.NoError(t, conn.ReadJSON(&resp))
+		return resp
+	}
+
+	first := send("1", 7)
+	require.Equal(t, "success", first.Status)
+	require.False(t, first.Replayed)
+
+	again := send("2", 7)
+	require.Equal(t, "2", again.ID)
+	require.Equal(t, "success", again.Status)
+	require.True(t, again.Replayed)
+	require.EqualValues(t, 1, deletes.Load())
+
+	other := send("3", 8)
+	require.Equal(t, ErrCodeKeyReused, other.Code)
+	require.EqualValues(t, 1, deletes.Load())
+}
+
+func TestRPCIdempotencyKeyIsReplayed(t *testing.T) {
+	bus := newFakeBus()
+	var deletes atomic.Int32
+	command.Register(bus, func(ctx context.Context, cmd model.DeleteUser) (model.User, error) {
+		deletes.Add(1)
+		return model.User{ID: cmd.UserID}, nil
+	})
+	m, err := NewManager(bus, Config{})
+	require.NoError(t, err)
+	m.Idempotency = idempotency.NewKeys(&memKeys{records: make(map[string]model.IdempotencyRecord)}, time.Hour)
+	conn := connectAs(t, m, "alice", SubprotocolJSONRPC)
+	require.Equal(t, SubprotocolJSONRPC, conn.Subprotocol())
+
+	send := func(id int, userID int64) rpcReply {
+		var reply rpcReply
+		frame := fmt.Sprintf(`{"jsonrpc": "2.0", "method": "delete_user", "params": {"user_id": %d, "idempotency_key": "k1"}, "id": %d}`, userID, id)
+		require.NoError(t, json.Unmarshal(call(t, conn, frame), &reply))
+		require.JSONEq(t, strconv.Itoa(id), string(reply.ID))
+		return reply
+	}
+
+	first := send(1, 7)
+	require.Nil(t, first.Error)
+	again := send(2, 7)
+	require.Nil(t, again.Error)
+	require.Equal(t, first.Result, again.Result)
+	require.EqualValues(t, 1, deletes.Load())
+
+	other := send(3, 8)
+	require.NotNil(t, other.Error)
+	require.Equal(t, ErrCodeKeyReused, other.Error.Data["code"])
+	require.EqualValues(t, 1, deletes.Load())
+}
+
+// A retry is recognised by its key and the caller's user, wherever it comes from
+func TestIdempotencyScopeIgnoresRemoteAddress(t *testing.T) {
+	identity, err := NewIdentity([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	var calls atomic.Int32
+	handler := identity.Middleware(idempotency.Middleware(
+		idempotency.NewKeys(&memKeys{records: make(map[string]model.IdempotencyRecord)}, time.Hour), UserOf,
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "call %d", calls.Add(1))
+	})))
+
+	post := func(remoteAddr, user, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"n":1}`))
+		req.RemoteAddr = remoteAddr
+		if user != "" {
+			req.Header.Set(ForwardedUserHeader, user)
+		}
+		req.Header.Set(idempotency.Header, key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The same user through two proxies
+	require.Equal(t, "call 1", post("10.0.0.1:5000", "alice", "k1").Body.String())
+	again := post("10.0.0.2:6000", "alice", "k1")
+	require.Equal(t, "call 1", again.Body.String())
+	require.Equal(t, "true", again.Header().Get(idempotency.ReplayedHeader))
+
+	// Anonymous clients' keys are ignored
+	require.Equal(t, "call 2", post("203.0.113.1:5000", "", "k2").Body.String())
+	require.Equal(t, "call 3", post("203.0.113.1:5000", "", "k2").Body.String())
+
+	// Another user's key is their own
+	require.Equal(t, "call 4", post("10.0.0.1:5000", "bob", "k1").Body.String())
+	require.EqualValues(t, 4, calls.Load())
+}
+
+func TestResume(t *testing.T) {
+	m, err := NewManager(newFakeBus(), Config{})
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		m.Broadcast(model.UserEvent{Type: model.EventUserUpdated, UserID: i, User: model.User{ID: i}})
+	}
+	conn := connect(t, m)
+
+	require.NoError(t, conn.WriteJSON(Message{ID: "r1", Type: "resume", Payload: ResumeRequest{LastSeq: 1, Epoch: m.epoch}}))
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	for _, seq := range []int64{2, 3} {
+		var event Message
+		require.NoError(t, conn.ReadJSON(&event))
+		require.Equal(t, KindEvent, event.Kind)
+		require.Equal(t, seq, event.Seq)
+		require.Equal(t, m.epoch, event.Epoch)
+	}
+	var resp Response
+	require.NoError(t, conn.ReadJSON(&resp))
+	require.Equal(t, "success", resp.Status)
+	require.Equal(t, map[string]interface{}{"replayed": 2.0, "last_seq": 3.0, "epoch": m.epoch}, resp.Data)
+}
+
+func TestResumeAfterRestartRequiresResync(t *testing.T) {
+	before, err := NewManager(newFakeBus(), Config{})
+	require.NoError(t, err)
+	before.Broadcast(model.UserEvent{Type: model.EventUserUpdated, UserID: 1})
+
+	// The restarted server is further along than the client's last_seq, so
+	// replaying from it would skip events the client never saw
+	m, err := NewManager(newFakeBus(), Config{})
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		m.Broadcast(model.UserEvent{Type: model.EventUserUpdated, UserID: i})
+	}
+	conn := connect(t, m)
+
+	for _, req := range []ResumeRequest{{LastSeq: 1, Epoch: before.epoch}, {LastSeq: 1}} {
+		require.NoError(t, conn.WriteJSON(Message{ID: "r1", Type: "resume", Payload: req}))
+		var resp Response
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		require.NoError(t, conn.ReadJSON(&resp))
+		require.Equal(t, KindResponse, resp.Kind)
+		require.Equal(t, ErrCodeResyncRequired, resp.Code)
+		require.Contains(t, resp.Error, m.epoch)
+	}
+}
+
+func TestWritesToOneUserKeepOrder(t *testing.T) {
+	var mu sync.Mutex
+	applied := make(map[int64][]string)
+	release := make(chan struct{})
+	bus := newFakeBus()
+	command.Register(bus, func(ctx context.Context, cmd model.UpdateUser) (model.User, error) {
+		// Earlier writes take longer, so running them concurrently would
+		// finish them in reverse
+		if *cmd.Req.FirstName == "0" {
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		applied[cmd.UserID] = append(applied[cmd.UserID], *cmd.Req.FirstName)
+		return model.User{ID: cmd.UserID}, nil
+	})
+	m, err := NewManager(bus, Config{RateBurst: 100})
+	require.NoError(t, err)
+	conn := connect(t, m)
+
+	// A user on another lane isn't held up
+	lane := func(userID int64) uint64 {
+		key, _ := laneKey(updateMessage(userID, ""))
+		return key % userLanes
+	}
+	other := int64(2)
+	for lane(other) == lane(1) {
+		other++
+	}
+	for i := 0; i < 5; i++ {
+		require.NoError(t, conn.WriteJSON(updateMessage(1, fmt.Sprint(i))))
+	}
+	require.NoError(t, conn.WriteJSON(updateMessage(other, "x")))
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var resp Response
+	require.NoError(t, conn.ReadJSON(&resp))
+	require.Equal(t, fmt.Sprint("u", other, "-x"), resp.ID)
+
+	close(release)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, conn.ReadJSON(&resp))
+		require.Equal(t, fmt.Sprint("u1-", i), resp.ID)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"0", "1", "2", "3", "4"}, applied[1])
+}
+
+func updateMessage(userID int64, name string) Message {
+	return Message{
+		ID:      fmt.Sprint("u", userID, "-", name),
+		Type:    "update_user",
+		Payload: map[string]interface{}{"user_id": userID, "first_name": name},
+	}
+}
+
+func TestLaneKey(t *testing.T) {
+	key := func(messageType string, payload interface{}) (uint64, bool) {
+		return laneKey(Message{Type: messageType, Payload: payload})
+	}
+	update, ok := key("update_user", map[string]interface{}{"user_id": 7, "first_name": "a"})
+	require.True(t, ok)
+	remove, _ := key("delete_user", map[string]interface{}{"user_id": 7.0})
+	require.Equal(t, update, remove, "writes to one user share a lane")
+	create, _ := key("create_user", map[string]interface{}{"email": "Ada@Example.com"})
+	again, _ := key("create_user", map[string]interface{}{"email": "ada@example.com"})
+	require.Equal(t, create, again, "creates are keyed by email, in any case")
+
+	_, ok = key("get_users", nil)
+	require.False(t, ok)
+	_, ok = key("update_user", "not an object")
+	require.False(t, ok)
+}
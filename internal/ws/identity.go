@@ -0,0 +1,89 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ForwardedUserHeader carries the user authenticated by the proxy in front of us
+const ForwardedUserHeader = "X-Forwarded-User"
+
+type userKey struct{}
+
+// Identity works out who is behind a request. Only the reverse proxies we
+// trust may say which user that is; anyone else is known by their IP.
+type Identity struct {
+	trusted []netip.Prefix
+}
+
+// NewIdentity trusts ForwardedUserHeader from the given proxies, each an IP
+// address or a CIDR range. With none, every caller is anonymous.
+func NewIdentity(trustedProxies []string) (*Identity, error) {
+	id := &Identity{}
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		id.trusted = append(id.trusted, prefix.Masked())
+	}
+	return id, nil
+}
+
+// Middleware records the user a trusted proxy authenticated, for UserOf and
+// PrincipalOf. The header is ignored on requests from anywhere else.
+func (id *Identity) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := r.Header.Get(ForwardedUserHeader); user != "" && id.trusts(r.RemoteAddr) {
+			r = r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (id *Identity) trusts(remoteAddr string) bool {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range id.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// UserOf returns the authenticated user behind a request, or "" when the
+// caller is anonymous or Identity.Middleware didn't run.
+func UserOf(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
+
+// PrincipalOf identifies who is behind a request, so that opening more
+// connections doesn't buy more requests: the authenticated user, else the
+// remote IP.
+func PrincipalOf(r *http.Request) string {
+	if user := UserOf(r); user != "" {
+		return "user:" + user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
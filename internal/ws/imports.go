@@ -0,0 +1,25 @@
+package ws
+
+import "UserManagement/internal/model"
+
+// EventImportProgress is pushed to the connections of the principal running
+// a user import, as it starts, every so many rows and when it ends
+const EventImportProgress = "import_progress"
+
+// NotifyImport sends an import's progress to every authenticated connection
+// of principal. Anonymous connections are left out, since everyone behind
+// the same IP address shares their principal.
+func (m *Manager) NotifyImport(principal string, job model.ImportJob) {
+	message := Message{
+		Kind:    KindEvent,
+		Type:    EventImportProgress,
+		Payload: job,
+	}
+	m.RLock()
+	defer m.RUnlock()
+	for c := range m.clients {
+		if c.user != "" && c.principal == principal {
+			c.notify(message)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// SubprotocolJSONRPC is negotiated through Sec-WebSocket-Protocol. Clients
+// that ask for it speak JSON-RPC 2.0; everyone else gets the Message format.
+const SubprotocolJSONRPC = "jsonrpc-2.0"
+
+// Standard JSON-RPC 2.0 error codes, plus the server-error range for
+// failures reported by the user service.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcServerError    = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+var rpcNullID = json.RawMessage("null")
+
+// handleRPCFrame decodes a single request or a batch and dispatches every
+// call through the same handler registry as the Message format.
+func (c *Client) handleRPCFrame(payload []byte) {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) > 0 && payload[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(payload, &batch); err != nil {
+			c.send(rpcErrorResponse(rpcNullID, rpcParseError, "parse error"))
+			return
+		}
+		if len(batch) == 0 {
+			c.send(rpcErrorResponse(rpcNullID, rpcInvalidRequest, "empty batch"))
+			return
+		}
+		collector := &rpcBatch{client: c}
+		for _, raw := range batch {
+			c.dispatchRPC(raw, collector)
+		}
+		collector.seal()
+		return
+	}
+	c.dispatchRPC(payload, nil)
+}
+
+// dispatchRPC handles one call. Replies go into the batch when there is one.
+func (c *Client) dispatchRPC(raw json.RawMessage, batch *rpcBatch) {
+	// reply registers a pending reply with the batch and returns how to deliver it
+	reply := func() func(rpcResponse) {
+		if batch == nil {
+			return func(resp rpcResponse) { c.send(resp) }
+		}
+		batch.expect()
+		return batch.add
+	}
+
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			reply()(rpcErrorResponse(rpcNullID, rpcParseError, "parse error"))
+			return
+		}
+		reply()(rpcErrorResponse(rpcNullID, rpcInvalidRequest, "invalid request"))
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		reply()(rpcErrorResponse(idOrNull(req.ID), rpcInvalidRequest, "invalid request"))
+		return
+	}
+
+	message := Message{ID: string(req.ID), Type: req.Method}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &message.Payload); err != nil {
+			reply()(rpcErrorResponse(idOrNull(req.ID), rpcInvalidParams, "invalid params"))
+			return
+		}
+		// An idempotency_key among the params is taken out, leaving the
+		// payload as the Message format would carry it
+		if params, ok := message.Payload.(map[string]interface{}); ok {
+			if key, ok := params["idempotency_key"].(string); ok {
+				message.IdempotencyKey = key
+				delete(params, "idempotency_key")
+			}
+		}
+	}
+
+	// Notifications have no id and get no reply
+	if len(req.ID) == 0 {
+		message.reply = func(Response) {}
+	} else {
+		respond := reply()
+		message.reply = func(resp Response) {
+			respond(toRPCResponse(req.ID, resp))
+		}
+	}
+	c.dispatch(message)
+}
+
+func toRPCResponse(id json.RawMessage, resp Response) rpcResponse {
+	if resp.Status != "error" {
+		result := resp.Data
+		if result == nil {
+			result = struct{}{}
+		}
+		return rpcResponse{JSONRPC: "2.0", Result: result, ID: id}
+	}
+	out := rpcErrorResponse(id, rpcCodeFor(resp.Code), resp.Error)
+	data := map[string]interface{}{"code": resp.Code}
+	if details, ok := resp.Data.(map[string]interface{}); ok {
+		for k, v := range details {
+			data[k] = v
+		}
+	}
+	out.Error.Data = data
+	return out
+}
+
+func rpcCodeFor(code string) int {
+	switch code {
+	case ErrCodeUnknownType:
+		return rpcMethodNotFound
+	case ErrCodeInvalidPayload:
+		return rpcInvalidParams
+	case ErrCodeInternal:
+		return rpcInternalError
+	default:
+		return rpcServerError
+	}
+}
+
+func rpcErrorResponse(id json.RawMessage, code int, message string) rpcResponse {
+	return rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: code, Message: message}, ID: id}
+}
+
+func idOrNull(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return rpcNullID
+	}
+	return id
+}
+
+// rpcBatch collects the replies to a batch and sends them as one array once
+// every call that expects a reply has produced one. A batch made up only of
+// notifications sends nothing.
+type rpcBatch struct {
+	client    *Client
+	mu        sync.Mutex
+	expected  int
+	responses []rpcResponse
+	sealed    bool
+}
+
+func (b *rpcBatch) expect() {
+	b.mu.Lock()
+	b.expected++
+	b.mu.Unlock()
+}
+
+func (b *rpcBatch) add(resp rpcResponse) {
+	b.mu.Lock()
+	b.responses = append(b.responses, resp)
+	responses := b.takeLocked()
+	b.mu.Unlock()
+	if responses != nil {
+		b.client.send(responses)
+	}
+}
+
+// seal marks the end of the batch; replies may already all be in.
+func (b *rpcBatch) seal() {
+	b.mu.Lock()
+	b.sealed = true
+	responses := b.takeLocked()
+	b.mu.Unlock()
+	if responses != nil {
+		b.client.send(responses)
+	}
+}
+
+// takeLocked returns the replies once the batch is complete, exactly once.
+func (b *rpcBatch) takeLocked() []rpcResponse {
+	if !b.sealed || b.expected == 0 || len(b.responses) < b.expected {
+		return nil
+	}
+	responses := b.responses
+	b.responses = nil
+	b.expected = 0
+	return responses
+}
+
+// rpcEvent turns a pushed event into a notification named after the event type.
+func rpcEvent(message Message) rpcNotification {
+	params := map[string]interface{}{"seq": message.Seq, "payload": message.Payload}
+	if message.Epoch != "" {
+		params["epoch"] = message.Epoch
+	}
+	return rpcNotification{
+		JSONRPC: "2.0",
+		Method:  message.Type,
+		Params:  params,
+	}
+}
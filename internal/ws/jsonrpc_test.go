@@ -0,0 +1,156 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/command"
+	"UserManagement/internal/errs"
+	"UserManagement/internal/model"
+)
+
+// newRPCBus answers get_users, and update_user with an error picked by user:
+// 2 finds the service busy and 3 doesn't exist.
+func newRPCBus(updates *atomic.Int64) *command.Bus {
+	bus := newFakeBus()
+	command.Register(bus, func(ctx context.Context, cmd model.UpdateUser) (model.User, error) {
+		updates.Add(1)
+		switch cmd.UserID {
+		case 2:
+			return model.User{}, errs.ErrQueueFull
+		case 3:
+			return model.User{}, errs.ErrUserNotFound
+		}
+		return model.User{ID: cmd.UserID}, nil
+	})
+	return bus
+}
+
+// connectRPC dials m speaking JSON-RPC
+func connectRPC(t *testing.T, m *Manager) *websocket.Conn {
+	conn := connectAs(t, m, "", SubprotocolJSONRPC)
+	require.Equal(t, SubprotocolJSONRPC, conn.Subprotocol())
+	return conn
+}
+
+type rpcReply struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  *struct {
+		Code int                    `json:"code"`
+		Data map[string]interface{} `json:"data"`
+	} `json:"error"`
+}
+
+func newRPCTest(t *testing.T) (*websocket.Conn, *atomic.Int64) {
+	updates := &atomic.Int64{}
+	m, err := NewManager(newRPCBus(updates), Config{RateBurst: 100})
+	require.NoError(t, err)
+	return connectRPC(t, m), updates
+}
+
+func call(t *testing.T, conn *websocket.Conn, frame string) []byte {
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	return data
+}
+
+// nextReplyIs sends a request and checks that the next frame is its reply,
+// so nothing was sent for anything before it.
+func nextReplyIs(t *testing.T, conn *websocket.Conn) {
+	var reply rpcReply
+	require.NoError(t, json.Unmarshal(call(t, conn, `{"jsonrpc": "2.0", "method": "get_users", "id": "last"}`), &reply))
+	require.Equal(t, `"last"`, string(reply.ID))
+}
+
+func TestRPCErrorCodes(t *testing.T) {
+	conn, _ := newRPCTest(t)
+	for _, tc := range []struct {
+		name  string
+		frame string
+		id    string
+		code  int
+		data  map[string]interface{}
+	}{
+		{"parse error", `{"jsonrpc": "2.0", "method": `, "null", rpcParseError, nil},
+		{"not a request", `"get_users"`, "null", rpcInvalidRequest, nil},
+		{"wrong version", `{"jsonrpc": "1.0", "method": "get_users", "id": 1}`, "1", rpcInvalidRequest, nil},
+		{"no method", `{"jsonrpc": "2.0", "id": "a"}`, `"a"`, rpcInvalidRequest, nil},
+		{"empty batch", `[]`, "null", rpcInvalidRequest, nil},
+		{"unknown method", `{"jsonrpc": "2.0", "method": "drop_users", "id": 2}`, "2", rpcMethodNotFound,
+			map[string]interface{}{"code": ErrCodeUnknownType}},
+		{"invalid params", `{"jsonrpc": "2.0", "method": "update_user", "params": {"user_id": "one"}, "id": 3}`, "3", rpcInvalidParams,
+			map[string]interface{}{"code": ErrCodeInvalidPayload}},
+		{"service busy", `{"jsonrpc": "2.0", "method": "update_user", "params": {"user_id": 2}, "id": 4}`, "4", rpcServerError,
+			map[string]interface{}{"code": ErrCodeUnavailable, "retry_after_ms": 1000.0}},
+		{"request failed", `{"jsonrpc": "2.0", "method": "update_user", "params": {"user_id": 3}, "id": 5}`, "5", rpcServerError,
+			map[string]interface{}{"code": ErrCodeRequestFailed}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var reply rpcReply
+			require.NoError(t, json.Unmarshal(call(t, conn, tc.frame), &reply))
+			require.JSONEq(t, tc.id, string(reply.ID))
+			require.Nil(t, reply.Result)
+			require.NotNil(t, reply.Error)
+			require.Equal(t, tc.code, reply.Error.Code)
+			if tc.data != nil {
+				require.Equal(t, tc.data, reply.Error.Data)
+			}
+		})
+	}
+}
+
+func TestRPCNotificationsGetNoReply(t *testing.T) {
+	conn, updates := newRPCTest(t)
+
+	for _, frame := range []string{
+		`{"jsonrpc": "2.0", "method": "update_user", "params": {"user_id": 1, "first_name": "a"}}`,
+		// Failing notifications are silent too
+		`{"jsonrpc": "2.0", "method": "update_user", "params": {"user_id": 3}}`,
+		// A batch of notifications gets no reply at all
+		`[{"jsonrpc": "2.0", "method": "update_user", "params": {"user_id": 2}}, {"jsonrpc": "2.0", "method": "get_users"}]`,
+	} {
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+	}
+	require.Eventually(t, func() bool { return updates.Load() == 3 }, time.Second, time.Millisecond)
+	nextReplyIs(t, conn)
+}
+
+func TestRPCBatch(t *testing.T) {
+	conn, updates := newRPCTest(t)
+
+	data := call(t, conn, `[
+		{"jsonrpc": "2.0", "method": "get_users", "id": 1},
+		{"jsonrpc": "2.0", "method": "update_user", "params": {"user_id": 1, "first_name": "a"}},
+		{"jsonrpc": "2.0", "method": "update_user", "params": {"user_id": 3}, "id": "missing"},
+		{"jsonrpc": "2.0", "method": "drop_users", "id": 2},
+		{"jsonrpc": "1.0", "method": "get_users", "id": 3},
+		42
+	]`)
+	var replies []rpcReply
+	require.NoError(t, json.Unmarshal(data, &replies), "a batch is answered with one array: %s", data)
+	byID := make(map[string]rpcReply)
+	for _, reply := range replies {
+		byID[string(reply.ID)] = reply
+	}
+	require.Len(t, replies, 5, "every call but the notification is answered")
+	require.Len(t, byID, 5)
+
+	require.Nil(t, byID["1"].Error)
+	require.Equal(t, []interface{}{}, byID["1"].Result)
+	require.Equal(t, rpcServerError, byID[`"missing"`].Error.Code)
+	require.Equal(t, rpcMethodNotFound, byID["2"].Error.Code)
+	require.Equal(t, rpcInvalidRequest, byID["3"].Error.Code)
+	require.Equal(t, rpcInvalidRequest, byID["null"].Error.Code)
+	// The batch reply doesn't wait for the notification
+	require.Eventually(t, func() bool { return updates.Load() == 2 }, time.Second, time.Millisecond)
+	nextReplyIs(t, conn)
+}
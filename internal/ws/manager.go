@@ -1,45 +1,162 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 
+	"UserManagement/internal/command"
+	"UserManagement/internal/errs"
+	"UserManagement/internal/idempotency"
 	"UserManagement/internal/model"
 )
 
-var (
-	websocketUpgrader = websocket.Upgrader{
-		CheckOrigin:     checkOrigin,
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-	}
-)
+// Config tunes the manager; zero values fall back to defaults.
+type Config struct {
+	// SendQueueSize bounds the frames waiting to be written to one client
+	SendQueueSize int
+	// OverflowPolicy is what happens when that queue is full
+	OverflowPolicy string
+	// ReadLimit is the largest message accepted from a client, in bytes
+	ReadLimit int64
+	// ReadBufferSize and WriteBufferSize size the connection's I/O buffers
+	ReadBufferSize  int
+	WriteBufferSize int
+	// PongWait is how long a connection may stay silent; pings go out every
+	// PingInterval, which must be shorter
+	PongWait     time.Duration
+	PingInterval time.Duration
+	// WriteWait bounds every write to a client
+	WriteWait time.Duration
+
+	// RateLimit and RateBurst size the token bucket of each connection, in
+	// messages per second; PrincipalRateLimit and PrincipalRateBurst the one
+	// shared by all connections of a principal
+	RateLimit          float64
+	RateBurst          int
+	PrincipalRateLimit float64
+	PrincipalRateBurst int
+	// MaxRateViolations rate-limited messages within a minute disconnect the client
+	MaxRateViolations int
 
-type UserService interface {
-	QueueCUDRequest(req model.CUDRequest)
+	// SSEHeartbeat is how often idle event streams get a keep-alive comment
+	SSEHeartbeat time.Duration
+
+	// AllowedOrigins lists the Origin headers accepted on upgrade; "*" allows
+	// any. When empty only same-host origins are accepted.
+	AllowedOrigins []string
+}
+
+func (c Config) withDefaults() Config {
+	if c.SendQueueSize <= 0 {
+		c.SendQueueSize = 256
+	}
+	if c.OverflowPolicy == "" {
+		c.OverflowPolicy = OverflowDropOldest
+	}
+	if c.ReadLimit <= 0 {
+		c.ReadLimit = 32 * 1024
+	}
+	if c.ReadBufferSize <= 0 {
+		c.ReadBufferSize = 1024
+	}
+	if c.WriteBufferSize <= 0 {
+		c.WriteBufferSize = 1024
+	}
+	if c.PongWait <= 0 {
+		c.PongWait = 10 * time.Second
+	}
+	if c.PingInterval <= 0 || c.PingInterval >= c.PongWait {
+		c.PingInterval = (c.PongWait * 9) / 10
+	}
+	if c.WriteWait <= 0 {
+		c.WriteWait = 10 * time.Second
+	}
+	if c.RateLimit <= 0 {
+		c.RateLimit = 20
+	}
+	if c.RateBurst <= 0 {
+		c.RateBurst = 40
+	}
+	if c.PrincipalRateLimit <= 0 {
+		c.PrincipalRateLimit = 50
+	}
+	if c.PrincipalRateBurst <= 0 {
+		c.PrincipalRateBurst = 100
+	}
+	if c.MaxRateViolations <= 0 {
+		c.MaxRateViolations = 20
+	}
+	if c.SSEHeartbeat <= 0 {
+		c.SSEHeartbeat = 15 * time.Second
+	}
+	return c
 }
 
 type Manager struct {
-	UserService UserService
-	clients     ClientList
+	// Bus runs the user commands clients send
+	Bus *command.Bus
+	// Idempotency, when set, stores responses to requests that carry an
+	// idempotency_key
+	Idempotency *idempotency.Keys
+
+	config   Config
+	upgrader websocket.Upgrader
+	clients  ClientList
 	sync.RWMutex
-	handlers map[string]MessageHandler
+	handlers      map[string]MessageHandler
+	subscriptions *subscriptionIndex
+	principals    *principalLimiters
+	// streams are the Server-Sent Events connections
+	streams map[*eventStream]struct{}
+
+	// seq numbers broadcast events; replay keeps the latest ones for resume.
+	// seq starts over whenever the server does, so epoch, random per
+	// Manager, tells a cursor from an earlier run apart from a current one.
+	seq    int64
+	epoch  string
+	replay *replayBuffer
+
+	// nextConnID numbers connections for the admin API
+	nextConnID atomic.Uint64
+
+	// shuttingDown turns away new connections once Shutdown has started
+	shuttingDown bool
 }
 
-func NewManager(us UserService) *Manager {
+func NewManager(bus *command.Bus, config Config) (*Manager, error) {
+	config = config.withDefaults()
+	if err := validOverflowPolicy(config.OverflowPolicy); err != nil {
+		return nil, err
+	}
 	m := &Manager{
-		UserService: us,
-		clients:     make(ClientList),
-		handlers:    make(map[string]MessageHandler),
+		Bus:           bus,
+		config:        config,
+		clients:       make(ClientList),
+		handlers:      make(map[string]MessageHandler),
+		subscriptions: newSubscriptionIndex(),
+		principals:    newPrincipalLimiters(config.PrincipalRateLimit, config.PrincipalRateBurst),
+		streams:       make(map[*eventStream]struct{}),
+		epoch:         newEpoch(),
+		replay:        newReplayBuffer(replayBufferSize),
+	}
+	m.upgrader = websocket.Upgrader{
+		CheckOrigin:     m.checkOrigin,
+		ReadBufferSize:  config.ReadBufferSize,
+		WriteBufferSize: config.WriteBufferSize,
+		Subprotocols:    []string{SubprotocolJSONRPC},
 	}
 	m.setupMessageHandlers()
-	return m
+	return m, nil
 }
 
 func (m *Manager) setupMessageHandlers() {
@@ -47,6 +164,9 @@ func (m *Manager) setupMessageHandlers() {
 	m.handlers["get_users"] = m.handleGetUsers
 	m.handlers["update_user"] = m.handleUpdateUser
 	m.handlers["delete_user"] = m.handleDeleteUser
+	m.handlers["subscribe"] = m.handleSubscribe
+	m.handlers["unsubscribe"] = m.handleUnsubscribe
+	m.handlers["resume"] = m.handleResume
 }
 
 func (m *Manager) routeEvent(message Message, c *Client) error {
@@ -57,19 +177,27 @@ func (m *Manager) routeEvent(message Message, c *Client) error {
 		}
 		return nil
 	} else {
+		m.sendError(c, message, ErrCodeUnknownType, "unknown message type")
 		return errors.New("event handler not found")
 	}
 }
 
 func (m *Manager) ServeWS(w http.ResponseWriter, r *http.Request) {
 	log.Println("new WS Connection")
+	m.RLock()
+	shuttingDown := m.shuttingDown
+	m.RUnlock()
+	if shuttingDown {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
 	// upgrade regular http connection into websocket
-	conn, err := websocketUpgrader.Upgrade(w, r, nil)
+	conn, err := m.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Couldn't able to upgrade", err)
 		return
 	}
-	client := NewClient(conn, m)
+	client := NewClient(conn, m, PrincipalOf(r), UserOf(r))
 	m.addClient(client)
 
 	go client.readMessages()
@@ -81,131 +209,298 @@ func (m *Manager) addClient(client *Client) {
 	defer m.Unlock()
 
 	m.clients[client] = true
+	m.announceLocked(EventPresenceJoined, client)
 }
 
+// Shutdown sends every client a going-away close frame, ends the event
+// streams and waits for the connections to close, or until ctx is done.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.Lock()
+	m.shuttingDown = true
+	for client := range m.clients {
+		client.close(websocket.CloseGoingAway, "server shutting down")
+	}
+	m.closeStreamsLocked()
+	m.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		m.RLock()
+		remaining := len(m.clients) + len(m.streams)
+		m.RUnlock()
+		if remaining == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// removeClient forgets a client once its writer has closed the connection.
 func (m *Manager) removeClient(client *Client) {
 	m.Lock()
 	defer m.Unlock()
 	if _, ok := m.clients[client]; ok {
-		err := client.conn.Close()
-		if err != nil {
-			return
+		close(client.done)
+		m.principals.release(client.principal)
+		for id, e := range client.subscriptions {
+			m.subscriptions.remove(e)
+			delete(client.subscriptions, id)
 		}
 		delete(m.clients, client)
+		m.announceLocked(EventPresenceLeft, client)
 	}
 }
 
-func checkOrigin(r *http.Request) bool {
+func (m *Manager) checkOrigin(r *http.Request) bool {
 	origin := r.Header.Get("Origin")
 
-	// Allow empty origin (e.g., Postman, curl) during development
+	// Allow empty origin (e.g., Postman, curl); browsers always send one
 	if origin == "" {
-		log.Println("No Origin header found, allowing connection (dev only).")
+		log.Println("No Origin header found, allowing connection.")
 		return true
 	}
 
-	switch origin {
-	case "ws://localhost:8082", "http://localhost:8080":
-		return true
-	default:
-		log.Println("Blocked connection with Origin:", origin)
-		return false
+	if len(m.config.AllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, allowed := range m.config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
 	}
+	log.Println("Blocked connection with Origin:", origin)
+	return false
 }
 
-func (m *Manager) Broadcast(msgType string, payload interface{}) {
-	m.RLock()
-	defer m.RUnlock()
+// Broadcast numbers the event, keeps it for replay and delivers it to every
+// client with a matching subscription. Clients that have not subscribed to
+// anything receive every event.
+func (m *Manager) Broadcast(event model.UserEvent) {
+	m.Lock()
+	defer m.Unlock()
+	m.seq++
+	e := sequencedEvent{seq: m.seq, event: event}
+	m.replay.append(e)
+
+	targets := m.subscriptions.match(event)
 	for client := range m.clients {
-		client.egress <- Message{
-			Type:    msgType,
-			Payload: payload,
+		if len(client.subscriptions) == 0 {
+			targets[client] = struct{}{}
+		}
+	}
+	for client := range targets {
+		// A resuming client picks this up from the replay buffer instead
+		if client.resuming {
+			continue
+		}
+		// Never blocks: a full queue is handled by the overflow policy
+		client.sendEvent(e)
+	}
+	for stream := range m.streams {
+		if stream.sub.Matches(event) {
+			stream.push(e)
 		}
 	}
 }
 
-func (m *Manager) handleWebSocketRequest(c *Client, cudReq model.CUDRequest, successMsgType string, successMsg interface{}) error {
-	responseChan := make(chan interface{})
-	cudReq.ResponseChannel = responseChan
-	m.UserService.QueueCUDRequest(cudReq)
+// requestTimeout bounds how long a request may wait for the service
+const requestTimeout = 5 * time.Second
 
-	select {
-	case response := <-responseChan:
-		if err, ok := response.(error); ok {
-			m.sendError(c.conn, successMsgType+"_response", err.Error())
+// runCommand dispatches a client's command and replies with successMsg, or
+// the command's result when successMsg is nil. A request with an
+// idempotency_key that was already handled gets the stored reply instead.
+// Keys from anonymous clients are ignored, as over HTTP.
+func runCommand[R any](m *Manager, c *Client, request Message, cmd command.Command[R], successMsg interface{}) error {
+	if request.IdempotencyKey == "" || m.Idempotency == nil || c.user == "" {
+		resp, err := dispatchCommand(m, c, request, cmd, successMsg)
+		m.respond(c, request, resp)
+		return err
+	}
+	if len(request.IdempotencyKey) > idempotency.MaxKeyLength {
+		m.sendError(c, request, ErrCodeInvalidPayload, "idempotency_key is too long")
+		return nil
+	}
+
+	payload, err := json.Marshal(request.Payload)
+	if err != nil {
+		m.sendError(c, request, ErrCodeInvalidPayload, err.Error())
+		return err
+	}
+	// Keys are scoped to the authenticated user. Scoping by address would
+	// let a client that reconnects from elsewhere run a request twice.
+	scope := c.user
+	fingerprint := idempotency.Fingerprint([]byte("ws"), []byte(request.Type), payload)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	stored, err := m.Idempotency.Begin(ctx, scope, request.IdempotencyKey, fingerprint)
+	cancel()
+	switch {
+	case errors.Is(err, errs.ErrKeyReused):
+		m.sendError(c, request, ErrCodeKeyReused, err.Error())
+		return nil
+	case errors.Is(err, errs.ErrKeyInProgress):
+		m.sendError(c, request, ErrCodeKeyInProgress, err.Error())
+		return nil
+	case err != nil:
+		m.sendError(c, request, ErrCodeInternal, "Could not check idempotency_key")
+		return err
+	case stored != nil:
+		var resp Response
+		if err := json.Unmarshal(stored.Body, &resp); err != nil {
+			m.sendError(c, request, ErrCodeInternal, "Could not replay stored response")
 			return err
 		}
-		m.sendSuccess(c.conn, successMsgType+"_response", successMsg)
-	case <-time.After(5 * time.Second): // Timeout after 5 seconds
-		m.sendError(c.conn, successMsgType+"_response", "Request timed out")
-		return errors.New("request timed out")
+		resp.ID = request.ID
+		resp.Replayed = true
+		m.respond(c, request, resp)
+		return nil
+	}
+
+	resp, cmdErr := dispatchCommand(m, c, request, cmd, successMsg)
+	// The command may have used up a whole requestTimeout, so the key gets
+	// a deadline of its own
+	ctx, cancel = context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	// Like 5xx responses over HTTP, these are worth retrying under the same key
+	if resp.Code == ErrCodeUnavailable || resp.Code == ErrCodeTimeout {
+		err = m.Idempotency.Abandon(ctx, scope, request.IdempotencyKey)
+	} else {
+		stored := resp
+		stored.ID = ""
+		var body []byte
+		if body, err = json.Marshal(stored); err == nil {
+			err = m.Idempotency.Finish(ctx, scope, request.IdempotencyKey, model.StoredResponse{
+				Status:      http.StatusOK,
+				ContentType: "application/json",
+				Body:        body,
+			})
+		}
 	}
-	return nil
+	if err != nil {
+		log.Printf("Error storing response for idempotency key from %s: %v", c.principal, err)
+	}
+	m.respond(c, request, resp)
+	return cmdErr
+}
+
+// dispatchCommand runs cmd and builds the reply to request.
+func dispatchCommand[R any](m *Manager, c *Client, request Message, cmd command.Command[R], successMsg interface{}) (Response, error) {
+	// The service skips the command if we've stopped waiting for it
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	result, err := command.Dispatch(command.WithPrincipal(ctx, c.principal), m.Bus, cmd)
+	resp := Response{
+		ID:     request.ID,
+		Kind:   KindResponse,
+		Type:   request.Type + "_response",
+		Status: "error",
+	}
+	switch {
+	case err == nil:
+		if successMsg == nil {
+			successMsg = result
+		}
+		resp.Status = "success"
+		resp.Data = successMsg
+	case errors.Is(err, errs.ErrQueueFull), errors.Is(err, errs.ErrServiceClosed):
+		resp.Error = err.Error()
+		resp.Code = ErrCodeUnavailable
+		resp.Data = map[string]interface{}{"retry_after_ms": time.Second.Milliseconds()}
+	case errors.Is(err, errs.ErrInvalidInput):
+		resp.Error = err.Error()
+		resp.Code = ErrCodeInvalidPayload
+	case errors.Is(err, context.DeadlineExceeded):
+		resp.Error = "Request timed out"
+		resp.Code = ErrCodeTimeout
+	default:
+		resp.Error = err.Error()
+		resp.Code = ErrCodeRequestFailed
+	}
+	return resp, err
+}
+
+// userIDPayload picks the target user out of update and delete payloads
+type userIDPayload struct {
+	UserID int64 `json:"user_id"`
 }
 
 func (m *Manager) handleCreateUser(message Message, c *Client) error {
 	var req model.CreateUserRequest
-	decodePayload(message.Payload, &req)
-	cudReq := model.CUDRequest{
-		Type:      "create_user",
-		CreateReq: req,
+	if err := m.decodePayload(c, message, &req); err != nil {
+		return err
 	}
-	return m.handleWebSocketRequest(c, cudReq, "create_user", "User created successfully")
+	return runCommand(m, c, message, model.CreateUser{Req: req}, "User created successfully")
 }
 
-func (m *Manager) handleGetUsers(_ Message, c *Client) error {
-	cudReq := model.CUDRequest{
-		Type: "get_users",
-	}
-	return m.handleWebSocketRequest(c, cudReq, "get_users", nil)
+func (m *Manager) handleGetUsers(message Message, c *Client) error {
+	return runCommand(m, c, message, model.ListUsers{}, nil)
 }
 
 func (m *Manager) handleUpdateUser(message Message, c *Client) error {
 	var req model.UpdateUserRequest
-	decodePayload(message.Payload, &req)
-	userID := message.Payload.(map[string]interface{})["user_id"].(int64)
-	cudReq := model.CUDRequest{
-		Type: "update_user",
-		UpdateReq: struct {
-			UserID int64
-			Req    model.UpdateUserRequest
-		}{
-			UserID: userID,
-			Req:    req,
-		},
+	var target userIDPayload
+	if err := m.decodePayload(c, message, &req); err != nil {
+		return err
+	}
+	if err := m.decodePayload(c, message, &target); err != nil {
+		return err
 	}
-	return m.handleWebSocketRequest(c, cudReq, "update_user", "User updated successfully")
+	return runCommand(m, c, message, model.UpdateUser{UserID: target.UserID, Req: req}, "User updated successfully")
 }
 
 func (m *Manager) handleDeleteUser(message Message, c *Client) error {
-	userID := message.Payload.(map[string]interface{})["user_id"].(int64)
-	cudReq := model.CUDRequest{
-		Type:   "delete_user",
-		UserID: userID,
+	var target userIDPayload
+	if err := m.decodePayload(c, message, &target); err != nil {
+		return err
 	}
-	return m.handleWebSocketRequest(c, cudReq, "delete_user", "User deleted successfully")
+	return runCommand(m, c, message, model.DeleteUser{UserID: target.UserID}, "User deleted successfully")
 }
 
-func (m *Manager) sendSuccess(conn *websocket.Conn, msgType string, data interface{}) {
-	resp := Response{Type: msgType, Status: "success", Data: data}
-	err := conn.WriteJSON(resp)
-	if err != nil {
-		return
-	}
+// sendSuccess and sendError reply to a request, echoing its ID. Replies go
+// through the client's writer since requests are handled concurrently.
+func (m *Manager) sendSuccess(c *Client, request Message, data interface{}) {
+	m.respond(c, request, Response{
+		ID:     request.ID,
+		Kind:   KindResponse,
+		Type:   request.Type + "_response",
+		Status: "success",
+		Data:   data,
+	})
 }
 
-func (m *Manager) sendError(conn *websocket.Conn, msgType string, errMsg string) {
-	resp := Response{Type: msgType, Status: "error", Error: errMsg}
-	err := conn.WriteJSON(resp)
-	if err != nil {
+func (m *Manager) sendError(c *Client, request Message, code string, errMsg string) {
+	m.respond(c, request, Response{
+		ID:     request.ID,
+		Kind:   KindResponse,
+		Type:   request.Type + "_response",
+		Status: "error",
+		Error:  errMsg,
+		Code:   code,
+	})
+}
+
+func (m *Manager) respond(c *Client, request Message, resp Response) {
+	if request.reply != nil {
+		request.reply(resp)
 		return
 	}
+	c.send(resp)
 }
 
-func decodePayload(input interface{}, out interface{}) {
-	temp, _ := json.Marshal(input)   // change to map[string]interface{} -> json
-	err := json.Unmarshal(temp, out) // change to json -> struct
+// decodePayload decodes the request payload into out, replying with an
+// invalid_payload error when it doesn't fit.
+func (m *Manager) decodePayload(c *Client, message Message, out interface{}) error {
+	temp, err := json.Marshal(message.Payload) // change to map[string]interface{} -> json
+	if err == nil {
+		err = json.Unmarshal(temp, out) // change to json -> struct
+	}
 	if err != nil {
-		return
+		m.sendError(c, message, ErrCodeInvalidPayload, err.Error())
 	}
+	return err
 }
@@ -1,17 +1,54 @@
 package ws
 
-// Message Client request message
+// Message kinds tell replies apart from unsolicited server events
+const (
+	KindResponse = "response"
+	KindEvent    = "event"
+)
+
+// Error codes carried on error responses
+const (
+	ErrCodeUnknownType    = "unknown_type"
+	ErrCodeInvalidPayload = "invalid_payload"
+	ErrCodeTimeout        = "timeout"
+	ErrCodeRequestFailed  = "request_failed"
+	ErrCodeInternal       = "internal_error"
+	ErrCodeResyncRequired = "resync_required"
+	ErrCodeRateLimited    = "rate_limited"
+	ErrCodeUnavailable    = "unavailable"
+	ErrCodeKeyReused      = "idempotency_key_reused"
+	ErrCodeKeyInProgress  = "idempotency_key_in_progress"
+)
+
+// Message Client request message, also used for server-pushed events.
+// ID is an optional client-chosen correlation ID echoed on the response.
 type Message struct {
-	Type    string      `json:"type"`
+	ID   string `json:"id,omitempty"`
+	Kind string `json:"kind,omitempty"`
+	Type string `json:"type"`
+	Seq  int64  `json:"seq,omitempty"` // event position, for resume
+	// Epoch names the run of the server that numbered Seq; see Manager.epoch
+	Epoch   string      `json:"epoch,omitempty"`
 	Payload interface{} `json:"payload"`
+	// IdempotencyKey makes a request safe to resend: a repeat gets the first
+	// response, as with the Idempotency-Key header
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// reply overrides how the response is delivered, e.g. for JSON-RPC
+	reply func(Response)
 }
 
 type MessageHandler func(message Message, c *Client) error
 
 // Response message
 type Response struct {
+	ID     string      `json:"id,omitempty"`
+	Kind   string      `json:"kind"`
 	Type   string      `json:"type"`
 	Status string      `json:"status"`
 	Data   interface{} `json:"data,omitempty"`
 	Error  string      `json:"error,omitempty"`
+	Code   string      `json:"code,omitempty"`
+	// Replayed marks a stored response sent again for a repeated request
+	Replayed bool `json:"replayed,omitempty"`
 }
@@ -0,0 +1,103 @@
+package ws
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Presence event types, pushed when a client connects or disconnects
+const (
+	EventPresenceJoined = "presence_joined"
+	EventPresenceLeft   = "presence_left"
+)
+
+var ErrConnectionNotFound = errors.New("connection not found")
+
+// ConnectionInfo describes a connected client for the admin API.
+type ConnectionInfo struct {
+	ID               string         `json:"id"`
+	RemoteAddr       string         `json:"remote_addr"`
+	Principal        string         `json:"principal"`
+	Protocol         string         `json:"protocol,omitempty"`
+	ConnectedAt      time.Time      `json:"connected_at"`
+	Subscriptions    []Subscription `json:"subscriptions"`
+	MessagesReceived int64          `json:"messages_received"`
+	MessagesSent     int64          `json:"messages_sent"`
+	RateLimited      int64          `json:"rate_limited"`
+}
+
+// presence is the payload of presence events. It leaves out the principal
+// and remote address, which would tell every client who else is connected
+// and from where; only operators see those.
+type presence struct {
+	ID          string    `json:"id"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Connections lists the connected clients, oldest first.
+func (m *Manager) Connections() []ConnectionInfo {
+	m.RLock()
+	defer m.RUnlock()
+	conns := make([]ConnectionInfo, 0, len(m.clients))
+	for c := range m.clients {
+		conns = append(conns, ConnectionInfo{
+			ID:               c.id,
+			RemoteAddr:       c.remoteAddr,
+			Principal:        c.principal,
+			Protocol:         c.protocol,
+			ConnectedAt:      c.connectedAt,
+			Subscriptions:    c.activeSubscriptions(),
+			MessagesReceived: c.messagesReceived.Load(),
+			MessagesSent:     c.messagesSent.Load(),
+			RateLimited:      c.rateLimited.Load(),
+		})
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ConnectedAt.Before(conns[j].ConnectedAt) })
+	return conns
+}
+
+// Disconnect closes the connection with the given ID.
+func (m *Manager) Disconnect(id string) error {
+	m.RLock()
+	defer m.RUnlock()
+	for c := range m.clients {
+		if c.id == id {
+			c.close(websocket.ClosePolicyViolation, "disconnected by an administrator")
+			return nil
+		}
+	}
+	return ErrConnectionNotFound
+}
+
+// announceLocked tells every other client that c joined or left. Clients with
+// subscriptions only hear about it if one of them lists the event type.
+func (m *Manager) announceLocked(eventType string, c *Client) {
+	message := Message{
+		Kind: KindEvent,
+		Type: eventType,
+		Payload: presence{
+			ID:          c.id,
+			ConnectedAt: c.connectedAt,
+		},
+	}
+	for other := range m.clients {
+		if other != c && other.wantsPresenceLocked(eventType) {
+			other.notify(message)
+		}
+	}
+}
+
+func (c *Client) wantsPresenceLocked(eventType string) bool {
+	if len(c.subscriptions) == 0 {
+		return true
+	}
+	for _, e := range c.subscriptions {
+		if containsFold(e.sub.EventTypes, eventType) {
+			return true
+		}
+	}
+	return false
+}
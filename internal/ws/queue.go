@@ -0,0 +1,208 @@
+package ws
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Overflow policies for a client whose send queue is full
+const (
+	// OverflowDropOldest discards the oldest queued event to make room
+	OverflowDropOldest = "drop_oldest"
+	// OverflowCoalesce drops a queued event for the same user in favour of
+	// the newer one, falling back to dropping the oldest event
+	OverflowCoalesce = "coalesce"
+	// OverflowDisconnect closes the connection with 1008 (policy violation)
+	OverflowDisconnect = "disconnect"
+)
+
+var (
+	wsMetrics = expvar.NewMap("ws")
+	// queuedFrames is the number of frames waiting across all clients
+	queuedFrames atomic.Int64
+)
+
+func init() {
+	wsMetrics.Set("queue_depth", expvar.Func(func() any {
+		return queuedFrames.Load()
+	}))
+}
+
+func validOverflowPolicy(policy string) error {
+	switch policy {
+	case OverflowDropOldest, OverflowCoalesce, OverflowDisconnect:
+		return nil
+	default:
+		return fmt.Errorf("unknown websocket overflow policy %q", policy)
+	}
+}
+
+// outbound is a frame waiting to be written. Only events may be dropped to
+// make room; a response is never lost, since the client is waiting for it.
+// User events carry a coalesce key so a newer event for the same user can
+// take the place of an older one. Control frames (pongs) carry their payload
+// in frame and skip the bound, but at most one of each kind is queued.
+type outbound struct {
+	frame       interface{}
+	event       bool
+	coalesceKey string
+	control     int
+}
+
+// sendQueue is a bounded FIFO of outbound frames. push never blocks, so one
+// slow client can't hold up a broadcast.
+type sendQueue struct {
+	mu     sync.Mutex
+	items  []outbound
+	max    int
+	policy string
+	closed bool
+	// closeMsg is the close frame to send once the queue is flushed; nil
+	// means the connection is gone and there is nothing left to send
+	closeMsg []byte
+	// ready is signalled whenever frames are pushed or the queue is closed
+	ready chan struct{}
+}
+
+func newSendQueue(max int, policy string) *sendQueue {
+	return &sendQueue{
+		max:    max,
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// push queues the frame. It returns false when the queue is full under the
+// disconnect policy, in which case the caller should drop the client.
+//
+// Otherwise an event makes way for the new frame. When only responses are
+// queued, a new event is dropped instead, and a new response goes over the
+// bound; there are at most maxInFlight of those per client.
+func (q *sendQueue) push(o outbound) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return true
+	}
+	// Only the latest ping needs an answer (RFC 6455 5.5.3), so a pong not
+	// yet written takes the newer payload rather than queueing another
+	if i := q.indexOfControl(o.control); i >= 0 {
+		q.items[i] = o
+		return true
+	}
+	if len(q.items) >= q.max && o.control == 0 {
+		if q.policy == OverflowDisconnect {
+			wsMetrics.Add("dropped", 1)
+			return false
+		}
+		// The newer event goes to the back so seq stays in order
+		if i := q.indexOf(o.coalesceKey); q.policy == OverflowCoalesce && o.event && i >= 0 {
+			q.removeLocked(i)
+			wsMetrics.Add("coalesced", 1)
+		} else if i := q.oldestEvent(); i >= 0 {
+			q.removeLocked(i)
+			wsMetrics.Add("dropped", 1)
+		} else if o.event {
+			wsMetrics.Add("dropped", 1)
+			return true
+		}
+	}
+	q.items = append(q.items, o)
+	queuedFrames.Add(1)
+	q.signal()
+	return true
+}
+
+func (q *sendQueue) indexOf(key string) int {
+	if key == "" {
+		return -1
+	}
+	for i := len(q.items) - 1; i >= 0; i-- {
+		if q.items[i].event && q.items[i].coalesceKey == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *sendQueue) indexOfControl(control int) int {
+	if control == 0 {
+		return -1
+	}
+	for i, item := range q.items {
+		if item.control == control {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *sendQueue) oldestEvent() int {
+	for i, item := range q.items {
+		if item.event {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *sendQueue) removeLocked(i int) {
+	q.items = append(q.items[:i], q.items[i+1:]...)
+	queuedFrames.Add(-1)
+}
+
+// drain takes every queued frame. closed reports whether the queue has been
+// closed, in which case the writer sends closeMsg (if any) and stops.
+func (q *sendQueue) drain() (items []outbound, closeMsg []byte, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items = q.items
+	q.items = nil
+	queuedFrames.Add(-int64(len(items)))
+	return items, q.closeMsg, q.closed
+}
+
+// close stops the queue taking new frames. Frames already queued are still
+// written before closeMsg; with a nil closeMsg they are dropped. Only the
+// first call has any effect.
+func (q *sendQueue) close(closeMsg []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.closeMsg = closeMsg
+	if closeMsg == nil {
+		q.dropLocked()
+	}
+	q.signal()
+}
+
+// discard drops whatever is left once the writer has stopped.
+func (q *sendQueue) discard() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.dropLocked()
+}
+
+func (q *sendQueue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+func (q *sendQueue) dropLocked() {
+	queuedFrames.Add(-int64(len(q.items)))
+	q.items = nil
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
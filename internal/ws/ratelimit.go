@@ -0,0 +1,109 @@
+package ws
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+// rateViolationWindow is how far back violations count towards a disconnect
+const rateViolationWindow = time.Minute
+
+// principalLimiters shares one token bucket between all connections of a
+// principal. Buckets are dropped when the last connection goes.
+type principalLimiters struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*principalLimiter
+}
+
+type principalLimiter struct {
+	*rate.Limiter
+	conns int
+}
+
+func newPrincipalLimiters(limit float64, burst int) *principalLimiters {
+	return &principalLimiters{
+		limit:    rate.Limit(limit),
+		burst:    burst,
+		limiters: make(map[string]*principalLimiter),
+	}
+}
+
+func (p *principalLimiters) acquire(principal string) *rate.Limiter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.limiters[principal]
+	if !ok {
+		l = &principalLimiter{Limiter: rate.NewLimiter(p.limit, p.burst)}
+		p.limiters[principal] = l
+	}
+	l.conns++
+	return l.Limiter
+}
+
+func (p *principalLimiters) release(principal string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if l, ok := p.limiters[principal]; ok {
+		l.conns--
+		if l.conns <= 0 {
+			delete(p.limiters, principal)
+		}
+	}
+}
+
+// allow takes a token from both the connection's and the principal's bucket.
+// When either is empty nothing is taken and it reports how long to wait.
+func (c *Client) allow() (bool, time.Duration) {
+	now := time.Now()
+	own := c.limiter.ReserveN(now, 1)
+	if delay := own.DelayFrom(now); delay > 0 {
+		own.CancelAt(now)
+		return false, delay
+	}
+	shared := c.principalLimiter.ReserveN(now, 1)
+	if delay := shared.DelayFrom(now); delay > 0 {
+		shared.CancelAt(now)
+		own.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// recordViolation counts a rate-limited message and reports whether the
+// client has now been over the limit too often to keep it connected.
+func (c *Client) recordViolation() bool {
+	wsMetrics.Add("rate_limited", 1)
+	c.rateLimited.Add(1)
+	now := time.Now()
+	if now.Sub(c.violationsSince) > rateViolationWindow {
+		c.violationsSince = now
+		c.violations = 0
+	}
+	c.violations++
+	return c.violations >= c.manager.config.MaxRateViolations
+}
+
+// rejectRateLimited replies with a rate_limited error, or disconnects the
+// client after repeated violations.
+func (c *Client) rejectRateLimited(request Message, retryAfter time.Duration) {
+	if c.recordViolation() {
+		log.Printf("Disconnecting websocket client %s after repeated rate limit violations", c.principal)
+		c.close(websocket.ClosePolicyViolation, "rate limit exceeded")
+		return
+	}
+	c.manager.respond(c, request, Response{
+		ID:     request.ID,
+		Kind:   KindResponse,
+		Type:   request.Type + "_response",
+		Status: "error",
+		Error:  "rate limit exceeded",
+		Code:   ErrCodeRateLimited,
+		Data:   map[string]interface{}{"retry_after_ms": retryAfter.Milliseconds()},
+	})
+}
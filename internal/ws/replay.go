@@ -0,0 +1,164 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"UserManagement/internal/model"
+)
+
+// replayBufferSize is how many recent events are kept for resuming clients
+var replayBufferSize = 1000
+
+// sequencedEvent is a broadcast event with its position in this instance's stream
+type sequencedEvent struct {
+	seq   int64
+	event model.UserEvent
+}
+
+// replayBuffer is a ring of the most recent events, guarded by the manager lock.
+type replayBuffer struct {
+	events []sequencedEvent
+	start  int
+	size   int
+}
+
+func newReplayBuffer(capacity int) *replayBuffer {
+	return &replayBuffer{events: make([]sequencedEvent, capacity)}
+}
+
+func (b *replayBuffer) append(e sequencedEvent) {
+	if len(b.events) == 0 {
+		return
+	}
+	if b.size < len(b.events) {
+		b.events[(b.start+b.size)%len(b.events)] = e
+		b.size++
+		return
+	}
+	b.events[b.start] = e
+	b.start = (b.start + 1) % len(b.events)
+}
+
+// since returns every buffered event after seq. ok is false when events
+// after seq have already been evicted, or when seq is ahead of current.
+func (b *replayBuffer) since(seq, current int64) (events []sequencedEvent, ok bool) {
+	if seq == current {
+		return nil, true
+	}
+	if seq > current {
+		return nil, false
+	}
+	if b.size == 0 || b.events[b.start].seq > seq+1 {
+		return nil, false
+	}
+	for i := 0; i < b.size; i++ {
+		e := b.events[(b.start+i)%len(b.events)]
+		if e.seq > seq {
+			events = append(events, e)
+		}
+	}
+	return events, true
+}
+
+// ResumeRequest carries the last sequence number the client saw, and the
+// epoch of the events it came from
+type ResumeRequest struct {
+	LastSeq int64  `json:"last_seq"`
+	Epoch   string `json:"epoch"`
+}
+
+func newEpoch() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// eventID is an event's SSE id: "<epoch>-<seq>".
+func eventID(epoch string, seq int64) string {
+	return epoch + "-" + strconv.FormatInt(seq, 10)
+}
+
+// parseEventID splits an SSE id into its epoch and sequence number. A bare
+// number, as sent before ids carried an epoch, has an empty epoch.
+func parseEventID(id string) (epoch string, seq int64, err error) {
+	if i := strings.LastIndexByte(id, '-'); i >= 0 {
+		epoch, id = id[:i], id[i+1:]
+	}
+	seq, err = strconv.ParseInt(id, 10, 64)
+	return epoch, seq, err
+}
+
+// handleResume replays the events a reconnecting client missed. Live events
+// are held back from the client until the replay has caught up, so it sees
+// every sequence number in order exactly once.
+func (m *Manager) handleResume(message Message, c *Client) error {
+	var req ResumeRequest
+	if err := m.decodePayload(c, message, &req); err != nil {
+		return err
+	}
+
+	last := req.LastSeq
+	replayed := 0
+	m.Lock()
+	if req.Epoch != m.epoch {
+		current := m.seq
+		m.Unlock()
+		// The sequence numbers came from an earlier run of the server, or
+		// another instance; they say nothing about what the client missed
+		m.sendError(c, message, ErrCodeResyncRequired,
+			fmt.Sprintf("epoch %q is not current, the current epoch is %q at sequence %d", req.Epoch, m.epoch, current))
+		return nil
+	}
+	c.resuming = true
+	for {
+		missed, ok := m.replay.since(last, m.seq)
+		if !ok {
+			c.resuming = false
+			current := m.seq
+			m.Unlock()
+			m.sendError(c, message, ErrCodeResyncRequired,
+				fmt.Sprintf("events after %d are no longer available, current sequence is %d", last, current))
+			return nil
+		}
+		if len(missed) == 0 {
+			c.resuming = false
+			m.Unlock()
+			break
+		}
+		var wanted []sequencedEvent
+		for _, e := range missed {
+			if c.wantsLocked(e.event) {
+				wanted = append(wanted, e)
+			}
+		}
+		last = missed[len(missed)-1].seq
+		m.Unlock()
+
+		for _, e := range wanted {
+			c.sendEvent(e)
+		}
+		replayed += len(wanted)
+		m.Lock()
+	}
+
+	m.sendSuccess(c, message, map[string]interface{}{
+		"replayed": replayed,
+		"last_seq": last,
+		"epoch":    m.epoch,
+	})
+	return nil
+}
+
+func eventMessage(epoch string, e sequencedEvent) Message {
+	return Message{
+		Kind:    KindEvent,
+		Type:    e.event.Type,
+		Seq:     e.seq,
+		Epoch:   epoch,
+		Payload: e.event.User,
+	}
+}
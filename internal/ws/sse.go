@@ -0,0 +1,227 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server-Sent Events share the broadcast pipeline, sequence numbers and
+// replay buffer with WebSocket clients, for consumers whose proxies don't
+// allow WebSocket upgrades.
+
+var errShuttingDown = errors.New("server is shutting down")
+
+// eventStream is one SSE connection. Its filters work like a single
+// WebSocket subscription.
+type eventStream struct {
+	sub   Subscription
+	queue *sendQueue
+}
+
+// ServeSSE streams user events as text/event-stream. Filters come from the
+// user_id, event_type and status query parameters; a Last-Event-ID header (or
+// last_event_id parameter) replays what the client missed.
+func (m *Manager) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	sub, err := subscriptionFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	epoch, lastSeq, resume, err := lastEventID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stream, resync, err := m.openStream(sub, epoch, lastSeq, resume)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer m.closeStream(stream)
+	log.Println("new SSE stream")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop nginx and similar proxies from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	write := func(frame string) error {
+		if err := rc.SetWriteDeadline(time.Now().Add(m.config.WriteWait)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		if _, err := io.WriteString(w, frame); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if resync {
+		m.RLock()
+		current := m.seq
+		m.RUnlock()
+		if err := write(fmt.Sprintf("event: %s\ndata: {\"epoch\":%q,\"last_seq\":%d}\n\n", ErrCodeResyncRequired, m.epoch, current)); err != nil {
+			return
+		}
+	} else if err := write(": connected\n\n"); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(m.config.SSEHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-stream.queue.ready:
+			items, _, closed := stream.queue.drain()
+			for _, item := range items {
+				frame, err := sseFrame(m.epoch, item.frame.(sequencedEvent))
+				if err != nil {
+					log.Printf("error marshaling event : %v", err)
+					return
+				}
+				if err := write(frame); err != nil {
+					log.Printf("Failed to send event: %v", err)
+					return
+				}
+			}
+			if closed {
+				return
+			}
+		case <-heartbeat.C:
+			if err := write(": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// openStream registers a stream, first queueing the events after lastSeq when
+// resuming. resync reports that those events are no longer available, or
+// that lastSeq came from another epoch.
+func (m *Manager) openStream(sub Subscription, epoch string, lastSeq int64, resume bool) (stream *eventStream, resync bool, err error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.shuttingDown {
+		return nil, false, errShuttingDown
+	}
+
+	var missed []sequencedEvent
+	if resume && epoch != m.epoch {
+		resync = true
+	} else if resume {
+		events, ok := m.replay.since(lastSeq, m.seq)
+		resync = !ok
+		for _, e := range events {
+			if sub.Matches(e.event) {
+				missed = append(missed, e)
+			}
+		}
+	}
+
+	// Room for the backlog on top of the usual bound
+	stream = &eventStream{
+		sub:   sub,
+		queue: newSendQueue(m.config.SendQueueSize+len(missed), m.config.OverflowPolicy),
+	}
+	for _, e := range missed {
+		stream.push(e)
+	}
+	m.streams[stream] = struct{}{}
+	return stream, resync, nil
+}
+
+func (m *Manager) closeStream(stream *eventStream) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.streams, stream)
+	stream.queue.discard()
+}
+
+// EndStreams ends every event stream. http.Server.Shutdown waits for
+// handlers to return, so it is registered with RegisterOnShutdown.
+func (m *Manager) EndStreams() {
+	m.Lock()
+	defer m.Unlock()
+	m.closeStreamsLocked()
+}
+
+// closeStreamsLocked ends every stream; their handlers return once they see it.
+func (m *Manager) closeStreamsLocked() {
+	for stream := range m.streams {
+		stream.queue.close(nil)
+	}
+}
+
+// push queues an event; under the disconnect policy a full queue ends the stream.
+func (s *eventStream) push(e sequencedEvent) {
+	o := outbound{frame: e, event: true, coalesceKey: strconv.FormatInt(e.event.UserID, 10)}
+	if !s.queue.push(o) {
+		wsMetrics.Add("slow_disconnects", 1)
+		s.queue.close(nil)
+	}
+}
+
+func sseFrame(epoch string, e sequencedEvent) (string, error) {
+	data, err := json.Marshal(e.event.User)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", eventID(epoch, e.seq), e.event.Type, data), nil
+}
+
+// subscriptionFromQuery reads filters given either as repeated parameters or
+// comma-separated lists.
+func subscriptionFromQuery(query url.Values) (Subscription, error) {
+	var sub Subscription
+	for _, v := range splitQuery(query["user_id"]) {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return sub, fmt.Errorf("invalid user_id %q", v)
+		}
+		sub.UserIDs = append(sub.UserIDs, id)
+	}
+	sub.EventTypes = splitQuery(query["event_type"])
+	sub.Statuses = splitQuery(query["status"])
+	return sub, nil
+}
+
+func splitQuery(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// lastEventID returns the epoch and sequence number of the event the client
+// last saw, if any.
+func lastEventID(r *http.Request) (epoch string, seq int64, ok bool, err error) {
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = r.URL.Query().Get("last_event_id")
+	}
+	if id == "" {
+		return "", 0, false, nil
+	}
+	epoch, seq, err = parseEventID(id)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("invalid Last-Event-ID %q", id)
+	}
+	return epoch, seq, true, nil
+}
@@ -0,0 +1,162 @@
+package ws
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/model"
+)
+
+type sseEvent struct {
+	id, event, data string
+}
+
+// openSSE connects to the stream and returns a channel of its events and
+// comments (as events named ":").
+func openSSE(t *testing.T, m *Manager, query string, lastEventID string) <-chan sseEvent {
+	server := httptest.NewServer(http.HandlerFunc(m.ServeSSE))
+	t.Cleanup(server.Close)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/events/users"+query, nil)
+	require.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := make(chan sseEvent, 100)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var e sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				events <- e
+				e = sseEvent{}
+			case strings.HasPrefix(line, ":"):
+				e.event = ":"
+			case strings.HasPrefix(line, "id: "):
+				e.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				e.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events
+}
+
+// nextEvent skips comments and returns the next event.
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			require.True(t, ok, "stream ended")
+			if e.event != ":" {
+				return e
+			}
+		case <-timeout:
+			t.Fatal("no event")
+		}
+	}
+}
+
+func waitForStreams(t *testing.T, m *Manager, n int) {
+	require.Eventually(t, func() bool {
+		m.RLock()
+		defer m.RUnlock()
+		return len(m.streams) == n
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSSEStreamsFilteredEvents(t *testing.T) {
+	m, err := NewManager(newFakeBus(), Config{})
+	require.NoError(t, err)
+	events := openSSE(t, m, "?user_id=2,3&event_type=user_updated", "")
+	waitForStreams(t, m, 1)
+
+	m.Broadcast(model.UserEvent{Type: model.EventUserUpdated, UserID: 1, User: model.User{ID: 1}})
+	m.Broadcast(model.UserEvent{Type: model.EventUserCreated, UserID: 2, User: model.User{ID: 2}})
+	m.Broadcast(model.UserEvent{Type: model.EventUserUpdated, UserID: 3, User: model.User{ID: 3}})
+
+	e := nextEvent(t, events)
+	require.Equal(t, m.epoch+"-3", e.id)
+	require.Equal(t, model.EventUserUpdated, e.event)
+	require.Contains(t, e.data, `"id":3`)
+}
+
+func TestSSEResumesFromLastEventID(t *testing.T) {
+	m, err := NewManager(newFakeBus(), Config{})
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		m.Broadcast(model.UserEvent{Type: model.EventUserUpdated, UserID: i, User: model.User{ID: i}})
+	}
+
+	events := openSSE(t, m, "", m.epoch+"-1")
+	require.Equal(t, m.epoch+"-2", nextEvent(t, events).id)
+	require.Equal(t, m.epoch+"-3", nextEvent(t, events).id)
+
+	// Live events follow the replayed ones
+	m.Broadcast(model.UserEvent{Type: model.EventUserDeleted, UserID: 1, User: model.User{ID: 1}})
+	require.Equal(t, m.epoch+"-4", nextEvent(t, events).id)
+
+	// A sequence number ahead of the stream can't be resumed
+	events = openSSE(t, m, "", m.epoch+"-99")
+	e := nextEvent(t, events)
+	require.Equal(t, ErrCodeResyncRequired, e.event)
+	require.Equal(t, `{"epoch":"`+m.epoch+`","last_seq":4}`, e.data)
+}
+
+func TestSSEResyncsAfterRestart(t *testing.T) {
+	before, err := NewManager(newFakeBus(), Config{})
+	require.NoError(t, err)
+	before.Broadcast(model.UserEvent{Type: model.EventUserUpdated, UserID: 1, User: model.User{ID: 1}})
+
+	// The restarted server has numbered more events than the client saw, so
+	// only the epoch shows that its sequence numbers mean something else
+	m, err := NewManager(newFakeBus(), Config{})
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		m.Broadcast(model.UserEvent{Type: model.EventUserUpdated, UserID: i, User: model.User{ID: i}})
+	}
+	require.NotEqual(t, before.epoch, m.epoch)
+
+	for _, id := range []string{before.epoch + "-1", "1"} {
+		e := nextEvent(t, openSSE(t, m, "", id))
+		require.Equal(t, ErrCodeResyncRequired, e.event, id)
+		require.Equal(t, `{"epoch":"`+m.epoch+`","last_seq":3}`, e.data)
+	}
+}
+
+func TestSSEHeartbeatAndShutdown(t *testing.T) {
+	m, err := NewManager(newFakeBus(), Config{SSEHeartbeat: 20 * time.Millisecond})
+	require.NoError(t, err)
+	events := openSSE(t, m, "", "")
+
+	heartbeats := 0
+	for heartbeats < 2 {
+		e := <-events
+		if e.event == ":" {
+			heartbeats++
+		}
+	}
+
+	m.EndStreams()
+	for range events {
+	}
+	waitForStreams(t, m, 0)
+}
@@ -0,0 +1,190 @@
+package ws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"UserManagement/internal/model"
+)
+
+// Subscription narrows the events a client receives. Empty filters match
+// everything. Group is not a filter, since users have no group attribute; it
+// names a set of subscriptions so they can be removed together.
+type Subscription struct {
+	ID         string   `json:"id"`
+	Group      string   `json:"group,omitempty"`
+	UserIDs    []int64  `json:"user_ids,omitempty"`
+	EventTypes []string `json:"event_types,omitempty"`
+	Statuses   []string `json:"statuses,omitempty"`
+}
+
+// UnsubscribeRequest removes subscriptions by ID or group; an empty request
+// removes all of them.
+type UnsubscribeRequest struct {
+	ID    string `json:"id,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+func (s *Subscription) Matches(event model.UserEvent) bool {
+	if len(s.UserIDs) > 0 && !containsID(s.UserIDs, event.UserID) {
+		return false
+	}
+	if len(s.EventTypes) > 0 && !containsFold(s.EventTypes, event.Type) {
+		return false
+	}
+	if len(s.Statuses) > 0 && (event.User.Status == nil || !containsFold(s.Statuses, *event.User.Status)) {
+		return false
+	}
+	return true
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptionIndex finds the subscriptions interested in an event without
+// scanning every client. Subscriptions naming users are indexed by user ID;
+// the rest are checked for every event.
+type subscriptionIndex struct {
+	byUser  map[int64]map[*subscriptionEntry]struct{}
+	anyUser map[*subscriptionEntry]struct{}
+}
+
+type subscriptionEntry struct {
+	client *Client
+	sub    Subscription
+}
+
+func newSubscriptionIndex() *subscriptionIndex {
+	return &subscriptionIndex{
+		byUser:  make(map[int64]map[*subscriptionEntry]struct{}),
+		anyUser: make(map[*subscriptionEntry]struct{}),
+	}
+}
+
+func (idx *subscriptionIndex) add(e *subscriptionEntry) {
+	if len(e.sub.UserIDs) == 0 {
+		idx.anyUser[e] = struct{}{}
+		return
+	}
+	for _, id := range e.sub.UserIDs {
+		if idx.byUser[id] == nil {
+			idx.byUser[id] = make(map[*subscriptionEntry]struct{})
+		}
+		idx.byUser[id][e] = struct{}{}
+	}
+}
+
+func (idx *subscriptionIndex) remove(e *subscriptionEntry) {
+	delete(idx.anyUser, e)
+	for _, id := range e.sub.UserIDs {
+		delete(idx.byUser[id], e)
+		if len(idx.byUser[id]) == 0 {
+			delete(idx.byUser, id)
+		}
+	}
+}
+
+// match returns every client with at least one subscription matching the event.
+func (idx *subscriptionIndex) match(event model.UserEvent) map[*Client]struct{} {
+	clients := make(map[*Client]struct{})
+	check := func(entries map[*subscriptionEntry]struct{}) {
+		for e := range entries {
+			if _, ok := clients[e.client]; !ok && e.sub.Matches(event) {
+				clients[e.client] = struct{}{}
+			}
+		}
+	}
+	check(idx.byUser[event.UserID])
+	check(idx.anyUser)
+	return clients
+}
+
+// subscribe registers the subscription for the client, replacing one with the
+// same ID, and returns the client's active subscriptions.
+func (m *Manager) subscribe(c *Client, sub Subscription) []Subscription {
+	m.Lock()
+	defer m.Unlock()
+	if sub.ID == "" {
+		c.nextSubID++
+		sub.ID = fmt.Sprintf("sub-%d", c.nextSubID)
+	}
+	if old, ok := c.subscriptions[sub.ID]; ok {
+		m.subscriptions.remove(old)
+	}
+	e := &subscriptionEntry{client: c, sub: sub}
+	c.subscriptions[sub.ID] = e
+	m.subscriptions.add(e)
+	return c.activeSubscriptions()
+}
+
+func (m *Manager) unsubscribe(c *Client, req UnsubscribeRequest) []Subscription {
+	m.Lock()
+	defer m.Unlock()
+	for id, e := range c.subscriptions {
+		if (req.ID == "" || req.ID == id) && (req.Group == "" || req.Group == e.sub.Group) {
+			m.subscriptions.remove(e)
+			delete(c.subscriptions, id)
+		}
+	}
+	return c.activeSubscriptions()
+}
+
+// wantsLocked reports whether the event should go to the client. Clients
+// without subscriptions receive everything.
+func (c *Client) wantsLocked(event model.UserEvent) bool {
+	if len(c.subscriptions) == 0 {
+		return true
+	}
+	for _, e := range c.subscriptions {
+		if e.sub.Matches(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeSubscriptions must be called with the manager lock held.
+func (c *Client) activeSubscriptions() []Subscription {
+	subs := make([]Subscription, 0, len(c.subscriptions))
+	for _, e := range c.subscriptions {
+		subs = append(subs, e.sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+	return subs
+}
+
+func (m *Manager) handleSubscribe(message Message, c *Client) error {
+	var sub Subscription
+	if err := m.decodePayload(c, message, &sub); err != nil {
+		return err
+	}
+	subs := m.subscribe(c, sub)
+	m.sendSuccess(c, message, map[string]interface{}{"subscriptions": subs})
+	return nil
+}
+
+func (m *Manager) handleUnsubscribe(message Message, c *Client) error {
+	var req UnsubscribeRequest
+	if err := m.decodePayload(c, message, &req); err != nil {
+		return err
+	}
+	subs := m.unsubscribe(c, req)
+	m.sendSuccess(c, message, map[string]interface{}{"subscriptions": subs})
+	return nil
+}
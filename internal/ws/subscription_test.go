@@ -0,0 +1,139 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"UserManagement/internal/model"
+)
+
+func TestSubscriptionMatches(t *testing.T) {
+	active := "Active"
+	event := model.UserEvent{Type: model.EventUserUpdated, UserID: 7, User: model.User{ID: 7, Status: &active}}
+	noStatus := model.UserEvent{Type: model.EventUserCreated, UserID: 7, User: model.User{ID: 7}}
+	for _, tc := range []struct {
+		name  string
+		sub   Subscription
+		event model.UserEvent
+		want  bool
+	}{
+		{"empty matches everything", Subscription{}, event, true},
+		{"user listed", Subscription{UserIDs: []int64{3, 7}}, event, true},
+		{"user not listed", Subscription{UserIDs: []int64{3}}, event, false},
+		{"event type, any case", Subscription{EventTypes: []string{"USER_UPDATED"}}, event, true},
+		{"other event type", Subscription{EventTypes: []string{model.EventUserDeleted}}, event, false},
+		{"status, any case", Subscription{Statuses: []string{"active"}}, event, true},
+		{"other status", Subscription{Statuses: []string{"Inactive"}}, event, false},
+		{"status filter skips users without one", Subscription{Statuses: []string{"Active"}}, noStatus, false},
+		{"every filter must match", Subscription{UserIDs: []int64{7}, EventTypes: []string{model.EventUserDeleted}}, event, false},
+		{"all filters match", Subscription{UserIDs: []int64{7}, EventTypes: []string{model.EventUserUpdated}, Statuses: []string{"Active"}}, event, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, tc.sub.Matches(tc.event))
+		})
+	}
+}
+
+// indexedUsers is the user IDs with subscriptions in the index, and how many
+// subscriptions are checked for every user
+func indexedUsers(m *Manager) ([]int64, int) {
+	m.RLock()
+	defer m.RUnlock()
+	var ids []int64
+	for id := range m.subscriptions.byUser {
+		ids = append(ids, id)
+	}
+	return ids, len(m.subscriptions.anyUser)
+}
+
+func matched(m *Manager, event model.UserEvent) map[*Client]struct{} {
+	m.RLock()
+	defer m.RUnlock()
+	return m.subscriptions.match(event)
+}
+
+func TestSubscriptionIndex(t *testing.T) {
+	m, err := NewManager(nil, Config{})
+	require.NoError(t, err)
+	a := &Client{subscriptions: make(map[string]*subscriptionEntry)}
+	b := &Client{subscriptions: make(map[string]*subscriptionEntry)}
+	updated := func(userID int64) model.UserEvent {
+		return model.UserEvent{Type: model.EventUserUpdated, UserID: userID, User: model.User{ID: userID}}
+	}
+
+	subs := m.subscribe(a, Subscription{UserIDs: []int64{1, 2}, Group: "g"})
+	require.Equal(t, "sub-1", subs[0].ID)
+	m.subscribe(a, Subscription{ID: "types", EventTypes: []string{model.EventUserDeleted}, Group: "g"})
+	m.subscribe(b, Subscription{ID: "mine", UserIDs: []int64{2}})
+	ids, anyUser := indexedUsers(m)
+	require.ElementsMatch(t, []int64{1, 2}, ids)
+	require.Equal(t, 1, anyUser)
+
+	require.Equal(t, map[*Client]struct{}{a: {}}, matched(m, updated(1)))
+	require.Equal(t, map[*Client]struct{}{a: {}, b: {}}, matched(m, updated(2)))
+	require.Empty(t, matched(m, updated(3)))
+	require.Equal(t, map[*Client]struct{}{a: {}}, matched(m, model.UserEvent{Type: model.EventUserDeleted, UserID: 3}))
+
+	// Reusing an ID replaces the subscription, including its index entries
+	m.subscribe(b, Subscription{ID: "mine", UserIDs: []int64{3}})
+	ids, _ = indexedUsers(m)
+	require.ElementsMatch(t, []int64{1, 2, 3}, ids)
+	require.Equal(t, map[*Client]struct{}{a: {}}, matched(m, updated(2)))
+	require.Equal(t, map[*Client]struct{}{b: {}}, matched(m, updated(3)))
+
+	// By group, then by ID
+	require.Empty(t, m.unsubscribe(a, UnsubscribeRequest{Group: "g"}))
+	ids, anyUser = indexedUsers(m)
+	require.Equal(t, []int64{3}, ids)
+	require.Zero(t, anyUser)
+	require.Len(t, m.unsubscribe(b, UnsubscribeRequest{ID: "other"}), 1, "an unknown ID removes nothing")
+	require.Empty(t, m.unsubscribe(b, UnsubscribeRequest{ID: "mine"}))
+	ids, anyUser = indexedUsers(m)
+	require.Empty(t, ids)
+	require.Zero(t, anyUser)
+
+	// An empty request removes everything
+	m.subscribe(a, Subscription{UserIDs: []int64{1}})
+	m.subscribe(a, Subscription{})
+	require.Empty(t, m.unsubscribe(a, UnsubscribeRequest{}))
+	ids, anyUser = indexedUsers(m)
+	require.Empty(t, ids)
+	require.Zero(t, anyUser)
+}
+
+func TestDisconnectLeavesIndex(t *testing.T) {
+	m, err := NewManager(nil, Config{})
+	require.NoError(t, err)
+	srv := httptest.NewServer(http.HandlerFunc(m.ServeWS))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.WriteJSON(Message{Type: "subscribe", Payload: Subscription{UserIDs: []int64{1}}}))
+	require.NoError(t, conn.WriteJSON(Message{Type: "subscribe", Payload: Subscription{EventTypes: []string{model.EventUserCreated}}}))
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	for i := 0; i < 2; i++ {
+		var resp Response
+		require.NoError(t, conn.ReadJSON(&resp))
+		require.Equal(t, "success", resp.Status)
+	}
+	ids, anyUser := indexedUsers(m)
+	require.Equal(t, []int64{1}, ids)
+	require.Equal(t, 1, anyUser)
+
+	require.NoError(t, conn.Close())
+	require.Eventually(t, func() bool {
+		m.RLock()
+		defer m.RUnlock()
+		return len(m.clients) == 0
+	}, time.Second, 10*time.Millisecond)
+	ids, anyUser = indexedUsers(m)
+	require.Empty(t, ids)
+	require.Zero(t, anyUser)
+}